@@ -0,0 +1,85 @@
+// Command migrate manages the schema of the musicdash database pointed to by MUSICDASH_DATABASE_URL.
+//
+// Usage:
+//
+//	migrate up [version]   apply pending migrations, up to the latest one or up to version
+//	migrate down [steps]   revert the last applied migration, or the last steps migrations
+//	migrate status         list all migrations and whether they've been applied
+package main
+
+import (
+	"bool3max/musicdash/db/migrations"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: migrate up [version] | down [steps] | status")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 || len(os.Args) > 3 {
+		usage()
+	}
+
+	// optional numeric argument
+	var number int
+	if len(os.Args) == 3 {
+		var err error
+		if number, err = strconv.Atoi(os.Args[2]); err != nil || number < 0 {
+			usage()
+		}
+	}
+
+	ctx := context.Background()
+
+	pool, err := pgxpool.New(ctx, os.Getenv("MUSICDASH_DATABASE_URL"))
+	if err != nil {
+		log.Fatalf("error connecting to database: %v\n", err)
+	}
+
+	defer pool.Close()
+
+	switch os.Args[1] {
+	case "up":
+		if len(os.Args) == 3 {
+			err = migrations.UpTo(ctx, pool, number)
+		} else {
+			err = migrations.Up(ctx, pool)
+		}
+	case "down":
+		if len(os.Args) < 3 {
+			number = 1
+		}
+
+		err = migrations.Down(ctx, pool, number)
+	case "status":
+		var statuses []migrations.Status
+		if statuses, err = migrations.GetStatus(ctx, pool); err != nil {
+			break
+		}
+
+		for _, status := range statuses {
+			applied := "pending"
+			if status.Applied {
+				applied = "applied " + status.AppliedAt.Format(time.DateTime)
+			}
+
+			fmt.Printf("%04d  %-24s %s\n", status.Version, status.Name, applied)
+		}
+	default:
+		usage()
+	}
+
+	if err != nil {
+		pool.Close()
+		log.Fatalln("migrate:", err)
+	}
+}
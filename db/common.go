@@ -1,9 +1,14 @@
 package db
 
 import (
+	"bool3max/musicdash/db/migrations"
 	"context"
+	"errors"
 	"fmt"
+	"log"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 )
@@ -14,29 +19,184 @@ var (
 	MUSICDASH_DATABASE_URL      = os.Getenv("MUSICDASH_DATABASE_URL")
 )
 
-// One instance of a Db{} database object is present per running program.
-// The pointer to that main one is declared here in the "db" package. It is unexported, and is
-// initially nil.
-var dbInstance *Db
-
 // An object representing a database connection.
 type Db struct {
 	pool *pgxpool.Pool
+
+	// keys that secrets stored in the database are encrypted under
+	keys *keyring
+}
+
+// Configuration of a database connection opened with Open(). Zero values of the pool and
+// timeout fields leave the pgxpool defaults (or the ones given in DatabaseUrl) in place.
+type Config struct {
+	DatabaseUrl string
+
+	// pool sizing
+	MaxConns          int32
+	MinConns          int32
+	MaxConnLifetime   time.Duration
+	MaxConnIdleTime   time.Duration
+	HealthCheckPeriod time.Duration
+
+	// maximum duration of a single statement, enforced by the server
+	StatementTimeout time.Duration
+
+	// the database is pinged at startup, retrying ConnectRetries times with ConnectRetryDelay,
+	// doubled after every attempt, in between attempts. Every attempt is bounded by ConnectTimeout.
+	ConnectTimeout    time.Duration
+	ConnectRetries    int
+	ConnectRetryDelay time.Duration
+
+	// apply pending schema migrations once connected, and re-encrypt secrets under the primary
+	// encryption key (see RotateEncryptionKeys())
+	Migrate bool
+
+	// keys for encrypting secrets stored in the database, primary key first. At least one is required.
+	EncryptionKeys []EncryptionKey
 }
 
-// Return a valid connected instance of the Db database object. This simply returns the global
-// ptr to an existing instance, but instantiates it if already isn't.
-func Acquire() *Db {
-	if dbInstance == nil {
-		pool, err := pgxpool.New(context.TODO(), MUSICDASH_DATABASE_URL)
+var ErrNoDatabaseUrl = errors.New("no database url configured")
+
+// Return the default configuration, reading the database url from MUSICDASH_DATABASE_URL, the
+// encryption keys from MUSICDASH_ENCRYPTION_KEYS (see ParseEncryptionKeys()) and the pool size from
+// MUSICDASH_DATABASE_MAX_CONNS, if set.
+func ConfigFromEnv() (Config, error) {
+	config := Config{
+		DatabaseUrl:       MUSICDASH_DATABASE_URL,
+		StatementTimeout:  30 * time.Second,
+		ConnectTimeout:    5 * time.Second,
+		ConnectRetries:    5,
+		ConnectRetryDelay: time.Second,
+		Migrate:           true,
+	}
+
+	if maxConns, err := strconv.Atoi(os.Getenv("MUSICDASH_DATABASE_MAX_CONNS")); err == nil && maxConns > 0 {
+		config.MaxConns = int32(maxConns)
+	}
+
+	keys, err := ParseEncryptionKeys(os.Getenv("MUSICDASH_ENCRYPTION_KEYS"))
+	if err != nil {
+		return Config{}, err
+	}
+
+	config.EncryptionKeys = keys
+
+	return config, nil
+}
+
+// Open a connection pool to the database described by config, wait for the database to become
+// reachable and, if config.Migrate is set, bring its schema up to date.
+func Open(ctx context.Context, config Config) (*Db, error) {
+	if config.DatabaseUrl == "" {
+		return nil, ErrNoDatabaseUrl
+	}
+
+	keys, err := newKeyring(config.EncryptionKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	poolConfig, err := pgxpool.ParseConfig(config.DatabaseUrl)
+	if err != nil {
+		return nil, fmt.Errorf("parsing database url: %w", err)
+	}
+
+	if config.MaxConns > 0 {
+		poolConfig.MaxConns = config.MaxConns
+	}
+
+	if config.MinConns > 0 {
+		poolConfig.MinConns = config.MinConns
+	}
+
+	if config.MaxConnLifetime > 0 {
+		poolConfig.MaxConnLifetime = config.MaxConnLifetime
+	}
+
+	if config.MaxConnIdleTime > 0 {
+		poolConfig.MaxConnIdleTime = config.MaxConnIdleTime
+	}
+
+	if config.HealthCheckPeriod > 0 {
+		poolConfig.HealthCheckPeriod = config.HealthCheckPeriod
+	}
+
+	if config.ConnectTimeout > 0 {
+		poolConfig.ConnConfig.ConnectTimeout = config.ConnectTimeout
+	}
+
+	if config.StatementTimeout > 0 {
+		poolConfig.ConnConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(config.StatementTimeout.Milliseconds(), 10)
+	}
+
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		return nil, fmt.Errorf("creating database pool: %w", err)
+	}
+
+	database := &Db{pool, keys}
+
+	if err := database.waitReachable(ctx, config); err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	if config.Migrate {
+		if err := migrations.Up(ctx, pool); err != nil {
+			pool.Close()
+			return nil, fmt.Errorf("migrating database schema: %w", err)
+		}
+
+		rotated, err := database.RotateEncryptionKeys(ctx)
 		if err != nil {
-			panic(fmt.Errorf("failed acquiring database pgxpool connection: %w", err))
+			pool.Close()
+			return nil, fmt.Errorf("re-encrypting secrets: %w", err)
 		}
 
-		dbInstance = &Db{pool}
+		if rotated > 0 {
+			log.Printf("db: re-encrypted {%v} stored credentials under key {%s}\n", rotated, keys.primary)
+		}
+	}
+
+	return database, nil
+}
+
+// Ping the database until it responds, retrying as configured.
+func (db *Db) waitReachable(ctx context.Context, config Config) error {
+	delay := config.ConnectRetryDelay
+
+	for attempt := 0; ; attempt++ {
+		err := db.Ping(ctx, config.ConnectTimeout)
+		if err == nil {
+			return nil
+		}
+
+		if attempt >= config.ConnectRetries {
+			return fmt.Errorf("database unreachable after %d attempts: %w", attempt+1, err)
+		}
+
+		log.Printf("db: database unreachable, retrying in %v: %v\n", delay, err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+	}
+}
+
+// Check that the database is reachable, failing if it doesn't respond within timeout (if non-zero).
+func (db *Db) Ping(ctx context.Context, timeout time.Duration) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
 	}
 
-	return dbInstance
+	return db.pool.Ping(ctx)
 }
 
 func (db *Db) Close() {
@@ -0,0 +1,314 @@
+package db
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Secrets stored in the database, such as Spotify tokens, are encrypted with AES-256-GCM. Every
+// ciphertext is stored along with the id of the key it was encrypted under, so that keys can be
+// rotated: new secrets are always encrypted under the primary key, whereas all configured keys can
+// decrypt. Once a new primary key is configured, RotateEncryptionKeys() re-encrypts existing
+// secrets under it, after which the old keys can be removed from the configuration.
+
+// A key used for encrypting secrets at rest.
+type EncryptionKey struct {
+	Id string
+
+	// 32 bytes
+	Key []byte
+}
+
+var (
+	ErrNoEncryptionKey      = errors.New("no encryption key configured")
+	ErrInvalidEncryptionKey = errors.New("invalid encryption key")
+	ErrUnknownEncryptionKey = errors.New("secret is encrypted under an unknown key")
+)
+
+// Parse a comma-separated list of encryption keys, each in the form "id:key", where key is 32 bytes
+// encoded in standard base64. The first key is the primary one.
+func ParseEncryptionKeys(s string) ([]EncryptionKey, error) {
+	keys := make([]EncryptionKey, 0)
+
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		id, encodedKey, found := strings.Cut(field, ":")
+		if !found || id == "" {
+			return nil, fmt.Errorf("%w: expected id:key", ErrInvalidEncryptionKey)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(encodedKey)
+		if err != nil {
+			return nil, fmt.Errorf("%w {%s}: %v", ErrInvalidEncryptionKey, id, err)
+		}
+
+		keys = append(keys, EncryptionKey{Id: id, Key: key})
+	}
+
+	return keys, nil
+}
+
+type keyring struct {
+	primary string
+	aeads   map[string]cipher.AEAD
+}
+
+func newKeyring(keys []EncryptionKey) (*keyring, error) {
+	if len(keys) == 0 {
+		return nil, ErrNoEncryptionKey
+	}
+
+	ring := &keyring{
+		primary: keys[0].Id,
+		aeads:   make(map[string]cipher.AEAD, len(keys)),
+	}
+
+	for _, key := range keys {
+		if len(key.Key) != 32 {
+			return nil, fmt.Errorf("%w {%s}: key must be 32 bytes long", ErrInvalidEncryptionKey, key.Id)
+		}
+
+		if _, exists := ring.aeads[key.Id]; exists {
+			return nil, fmt.Errorf("%w {%s}: duplicate key id", ErrInvalidEncryptionKey, key.Id)
+		}
+
+		block, err := aes.NewCipher(key.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		ring.aeads[key.Id] = aead
+	}
+
+	return ring, nil
+}
+
+// Encrypt plaintext under the primary key, returning the key's id and the base64-encoded nonce and
+// ciphertext. additionalData, which must be the same when decrypting, binds the ciphertext to its owner.
+func (ring *keyring) seal(plaintext string, additionalData []byte) (string, string, error) {
+	aead := ring.aeads[ring.primary]
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), additionalData)
+
+	return ring.primary, base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt a ciphertext returned by seal().
+func (ring *keyring) open(keyId string, ciphertext string, additionalData []byte) (string, error) {
+	aead, ok := ring.aeads[keyId]
+	if !ok {
+		return "", fmt.Errorf("%w {%s}", ErrUnknownEncryptionKey, keyId)
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("ciphertext too short")
+	}
+
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additionalData)
+	if err != nil {
+		return "", err
+	}
+
+	return string(plaintext), nil
+}
+
+// Return the hash of an auth token, which is what's stored in the database in place of the token.
+func hashAuthToken(token UserAuthToken) []byte {
+	hash := sha256.Sum256([]byte(token))
+	return hash[:]
+}
+
+// Re-encrypt all Spotify tokens that aren't encrypted under the primary key, including those stored
+// in plaintext before encryption was introduced, as well as Last.fm session keys, returning the number
+// of re-encrypted credentials, i.e. Spotify token pairs and session keys.
+func (db *Db) RotateEncryptionKeys(ctx context.Context) (int, error) {
+	rows, err := db.pool.Query(
+		ctx,
+		`
+			select userid, accesstoken, refreshtoken, keyid
+			from auth.spotify_token
+			where keyid is null or keyid<>$1
+		`,
+		db.keys.primary,
+	)
+
+	if err != nil {
+		return 0, err
+	}
+
+	type storedToken struct {
+		userId                    uuid.UUID
+		accessToken, refreshToken string
+		keyId                     *string
+	}
+
+	tokens, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (storedToken, error) {
+		var token storedToken
+		err := row.Scan(&token.userId, &token.accessToken, &token.refreshToken, &token.keyId)
+		return token, err
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	for _, token := range tokens {
+		accessToken, refreshToken := token.accessToken, token.refreshToken
+
+		// a null key id means the tokens are still in plaintext
+		if token.keyId != nil {
+			if accessToken, err = db.keys.open(*token.keyId, accessToken, token.userId[:]); err != nil {
+				return 0, fmt.Errorf("decrypting spotify tokens of {%s}: %w", token.userId.String(), err)
+			}
+
+			if refreshToken, err = db.keys.open(*token.keyId, refreshToken, token.userId[:]); err != nil {
+				return 0, fmt.Errorf("decrypting spotify tokens of {%s}: %w", token.userId.String(), err)
+			}
+		}
+
+		keyId, sealedAccessToken, err := db.keys.seal(accessToken, token.userId[:])
+		if err != nil {
+			return 0, err
+		}
+
+		_, sealedRefreshToken, err := db.keys.seal(refreshToken, token.userId[:])
+		if err != nil {
+			return 0, err
+		}
+
+		// the tokens are only replaced if they haven't been changed in the meantime
+		_, err = db.pool.Exec(
+			ctx,
+			`
+				update auth.spotify_token
+				set accesstoken=@sealedAccessToken, refreshtoken=@sealedRefreshToken, keyid=@keyId
+				where userid=@userId and accesstoken=@accessToken and keyid is not distinct from @oldKeyId
+			`,
+			pgx.NamedArgs{
+				"sealedAccessToken":  sealedAccessToken,
+				"sealedRefreshToken": sealedRefreshToken,
+				"keyId":              keyId,
+				"userId":             token.userId,
+				"accessToken":        token.accessToken,
+				"oldKeyId":           token.keyId,
+			},
+		)
+
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	rotated := len(tokens)
+
+	// secrets of linked accounts of other services, as a table and its column holding the sealed secret
+	for _, secrets := range [][2]string{
+		{"auth.user_lastfm", "session_key"},
+		{"auth.user_listenbrainz", "token"},
+	} {
+		count, err := db.rotateLinkedAccountSecrets(ctx, secrets[0], secrets[1])
+		if err != nil {
+			return 0, err
+		}
+
+		rotated += count
+	}
+
+	return rotated, nil
+}
+
+// Re-encrypt all secrets stored in column of table, keyed by userid, that aren't encrypted under the
+// primary key, returning their number.
+func (db *Db) rotateLinkedAccountSecrets(ctx context.Context, table, column string) (int, error) {
+	rows, err := db.pool.Query(
+		ctx,
+		`
+			select userid, `+column+`, keyid
+			from `+table+`
+			where keyid<>$1
+		`,
+		db.keys.primary,
+	)
+
+	if err != nil {
+		return 0, err
+	}
+
+	type storedSecret struct {
+		userId        uuid.UUID
+		secret, keyId string
+	}
+
+	secrets, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (storedSecret, error) {
+		var secret storedSecret
+		err := row.Scan(&secret.userId, &secret.secret, &secret.keyId)
+		return secret, err
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	for _, secret := range secrets {
+		plaintext, err := db.keys.open(secret.keyId, secret.secret, secret.userId[:])
+		if err != nil {
+			return 0, fmt.Errorf("decrypting %s of {%s}: %w", table, secret.userId.String(), err)
+		}
+
+		keyId, sealed, err := db.keys.seal(plaintext, secret.userId[:])
+		if err != nil {
+			return 0, err
+		}
+
+		// the secret is only replaced if it hasn't been changed in the meantime
+		_, err = db.pool.Exec(
+			ctx,
+			`
+				update `+table+`
+				set `+column+`=@sealed, keyid=@keyId
+				where userid=@userId and `+column+`=@oldSealed
+			`,
+			pgx.NamedArgs{
+				"sealed":    sealed,
+				"keyId":     keyId,
+				"userId":    secret.userId,
+				"oldSealed": secret.secret,
+			},
+		)
+
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(secrets), nil
+}
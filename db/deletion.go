@@ -0,0 +1,177 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// how long an account scheduled for deletion can still be restored for
+const AccountDeletionGracePeriod = 30 * 24 * time.Hour
+
+var ErrAccountNotScheduledForDeletion = errors.New("account not scheduled for deletion")
+
+// Schedule the user's account to be purged along with all of its data once gracePeriod elapses,
+// returning the time of the purge, and log out all of the user's sessions. Until then, the user can
+// still log in and restore the account with User.CancelDeletion(). Plays of the user aren't aggregated
+// in the meantime. A zero gracePeriod purges the account immediately.
+func (user *User) ScheduleDeletion(ctx context.Context, database *Db, gracePeriod time.Duration) (time.Time, error) {
+	if gracePeriod <= 0 {
+		if err := database.PurgeUser(ctx, user.Id); err != nil {
+			return time.Time{}, err
+		}
+
+		return time.Now(), nil
+	}
+
+	var purgeAt time.Time
+	err := pgx.BeginFunc(ctx, database.pool, func(tx pgx.Tx) error {
+		err := tx.QueryRow(
+			ctx,
+			`
+				update auth.user
+				set purge_at=now() + $2::interval
+				where id=$1
+				returning purge_at
+			`,
+			user.Id,
+			gracePeriod,
+		).Scan(&purgeAt)
+
+		if err != nil {
+			if err == pgx.ErrNoRows {
+				return ErrUserNotFound
+			}
+
+			return err
+		}
+
+		_, err = tx.Exec(
+			ctx,
+			`
+				delete from auth.auth_token
+				where userid=$1
+			`,
+			user.Id,
+		)
+
+		return err
+	})
+
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	user.PurgeAt = &purgeAt
+	return purgeAt, nil
+}
+
+// Restore the user's account that's scheduled for deletion. If it isn't, ErrAccountNotScheduledForDeletion
+// is returned.
+func (user *User) CancelDeletion(ctx context.Context, database *Db) error {
+	tag, err := database.pool.Exec(
+		ctx,
+		`
+			update auth.user
+			set purge_at=null
+			where id=$1 and purge_at is not null
+		`,
+		user.Id,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrAccountNotScheduledForDeletion
+	}
+
+	user.PurgeAt = nil
+	return nil
+}
+
+// Irreversibly delete a user along with all of its data: plays, import jobs, profile image, sessions, Spotify
+// tokens and the links to the Spotify profile and Last.fm account, all in a single transaction.
+func (db *Db) PurgeUser(ctx context.Context, userId uuid.UUID) error {
+	return pgx.BeginFunc(ctx, db.pool, func(tx pgx.Tx) error {
+		// all tables referencing the user are emptied explicitly, rather than relying on
+		// cascading deletes alone, so that the purge doesn't depend on every constraint being in place
+		for _, table := range []string{
+			"public.plays",
+			"public.match_review",
+			"public.import_job",
+			"public.ingest_unmatched",
+			"auth.user_profile_img",
+			"auth.auth_token",
+			"auth.spotify_token",
+			"auth.user_spotify",
+			"auth.user_lastfm",
+			"auth.user_listenbrainz",
+			"auth.ingest_token",
+			"auth.password_reset",
+			"auth.email_verification",
+		} {
+			if _, err := tx.Exec(ctx, "delete from "+table+" where userid=$1", userId); err != nil {
+				return err
+			}
+		}
+
+		tag, err := tx.Exec(
+			ctx,
+			`
+				delete from auth.user
+				where id=$1
+			`,
+			userId,
+		)
+
+		if err != nil {
+			return err
+		}
+
+		if tag.RowsAffected() == 0 {
+			return ErrUserNotFound
+		}
+
+		return nil
+	})
+}
+
+// Purge all accounts whose deletion grace period has elapsed, returning the number of purged accounts.
+// A failure to purge one account doesn't prevent others from being purged.
+func (db *Db) PurgeDeletedAccounts(ctx context.Context) (int, error) {
+	rows, err := db.pool.Query(
+		ctx,
+		`
+			select id
+			from auth.user
+			where purge_at <= now()
+		`,
+	)
+
+	if err != nil {
+		return 0, err
+	}
+
+	userIds, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, userId := range userIds {
+		if err := db.PurgeUser(ctx, userId); err != nil {
+			log.Printf("PurgeDeletedAccounts: error purging user {%v}: %v\n", userId.String(), err)
+			continue
+		}
+
+		purged++
+	}
+
+	return purged, nil
+}
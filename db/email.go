@@ -0,0 +1,182 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// how long an e-mail verification token can be used for after it's issued
+const EmailVerificationLifetime = 24 * time.Hour
+
+var (
+	ErrEmailNotVerified         = errors.New("e-mail address not verified")
+	ErrEmailAlreadyVerified     = errors.New("e-mail address already verified")
+	ErrEmailTaken               = errors.New("e-mail address already registered")
+	ErrInvalidVerificationToken = errors.New("invalid or expired e-mail verification token")
+)
+
+// Issue a single-use token verifying that the user owns the given e-mail address, returning the token.
+// If email is the user's current address, verifying it marks the address as verified, whereas if
+// it's a different one, verifying it changes the user's address to it. If the user's current address
+// is already verified, ErrEmailAlreadyVerified is returned, and if a different address is already
+// registered by another user, ErrEmailTaken is returned. The token is valid for EmailVerificationLifetime.
+func (user *User) NewEmailVerification(ctx context.Context, database *Db, email string) (string, error) {
+	var currentEmail, verified, taken bool
+	err := database.pool.QueryRow(
+		ctx,
+		`
+			select email=$2, email_verified_at is not null, exists (
+				select 1
+				from auth.user as other
+				where other.email=$2 and other.id<>$1
+			)
+			from auth.user
+			where id=$1
+		`,
+		user.Id,
+		email,
+	).Scan(&currentEmail, &verified, &taken)
+
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return "", ErrUserNotFound
+		}
+
+		return "", err
+	}
+
+	if currentEmail && verified {
+		return "", ErrEmailAlreadyVerified
+	}
+
+	if taken {
+		return "", ErrEmailTaken
+	}
+
+	token, err := newSingleUseToken()
+	if err != nil {
+		return "", err
+	}
+
+	// expired tokens of the user are of no use anymore
+	_, err = database.pool.Exec(
+		ctx,
+		`
+			delete from auth.email_verification
+			where userid=$1 and expires_at <= now()
+		`,
+		user.Id,
+	)
+
+	if err != nil {
+		return "", err
+	}
+
+	_, err = database.pool.Exec(
+		ctx,
+		`
+			insert into auth.email_verification
+			(token_hash, userid, email, expires_at)
+			values (@tokenHash, @userId, @email, now() + @lifetime::interval)
+		`,
+		pgx.NamedArgs{
+			"tokenHash": hashToken(token),
+			"userId":    user.Id,
+			"email":     email,
+			"lifetime":  EmailVerificationLifetime,
+		},
+	)
+
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// Verify the e-mail address that the token was issued for, setting it as the verified address of the
+// user it was issued to, and return the user. All pending verifications of the user are consumed. If the
+// token doesn't exist or has expired, ErrInvalidVerificationToken is returned, and if the address has
+// meanwhile been registered by another user, ErrEmailTaken is returned.
+func (db *Db) VerifyEmail(ctx context.Context, token string) (User, error) {
+	var userId uuid.UUID
+
+	err := pgx.BeginFunc(ctx, db.pool, func(tx pgx.Tx) error {
+		var email string
+		err := tx.QueryRow(
+			ctx,
+			`
+				delete from auth.email_verification
+				where token_hash=$1 and expires_at > now()
+				returning userid, email
+			`,
+			hashToken(token),
+		).Scan(&userId, &email)
+
+		if err != nil {
+			if err == pgx.ErrNoRows {
+				return ErrInvalidVerificationToken
+			}
+
+			return err
+		}
+
+		var taken bool
+		err = tx.QueryRow(
+			ctx,
+			`
+				select exists (
+					select 1
+					from auth.user
+					where email=$2 and id<>$1
+				)
+			`,
+			userId,
+			email,
+		).Scan(&taken)
+
+		if err != nil {
+			return err
+		}
+
+		if taken {
+			return ErrEmailTaken
+		}
+
+		_, err = tx.Exec(
+			ctx,
+			`
+				update auth.user
+				set email=$2, email_verified_at=now()
+				where id=$1
+			`,
+			userId,
+			email,
+		)
+
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(
+			ctx,
+			`
+				delete from auth.email_verification
+				where userid=$1
+			`,
+			userId,
+		)
+
+		return err
+	})
+
+	if err != nil {
+		return User{}, err
+	}
+
+	return db.GetUserFromId(ctx, userId)
+}
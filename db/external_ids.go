@@ -0,0 +1,103 @@
+package db
+
+import (
+	music "bool3max/musicdash/music"
+	"context"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Set the id issued by provider for a preserved resource, overwriting any existing one. An empty
+// externalId records that the provider has been consulted but doesn't know about the resource.
+func (db *Db) setExternalId(ctx context.Context, resourceType music.ResourceType, spotifyId string, provider music.Provider, externalId string) error {
+	_, err := db.pool.Exec(
+		ctx,
+		`
+			insert into spotify.external_id
+			(resourcetype, spotifyid, provider, externalid)
+			values (@resourceType, @spotifyId, @provider, @externalId)
+			on conflict on constraint external_id_pk do update
+			set externalid = @externalId
+		`,
+		pgx.NamedArgs{
+			"resourceType": resourceType,
+			"spotifyId":    spotifyId,
+			"provider":     provider,
+			"externalId":   externalId,
+		},
+	)
+
+	return err
+}
+
+// Resolve an id issued by provider to the Spotify id the resource is preserved under. Returns
+// ErrResourceNotPreserved if no preserved resource of the requested type carries the id. An id may be
+// shared by several preserved resources, such as a MusicBrainz recording released on several Spotify
+// albums, in which case the one with the lowest Spotify id is returned, so that the result is stable.
+func (db *Db) resolveExternalId(resourceType music.ResourceType, provider music.Provider, externalId string) (string, error) {
+	// the catalog is keyed by Spotify ids, those need no resolving
+	if provider == music.ProviderSpotify {
+		return externalId, nil
+	}
+
+	// empty ids only record that the provider doesn't know the resource
+	if externalId == "" {
+		return "", ErrResourceNotPreserved
+	}
+
+	var spotifyId string
+	err := db.pool.QueryRow(
+		context.TODO(),
+		`
+			select spotifyid
+			from spotify.external_id
+			where resourcetype=$1 and provider=$2 and externalid=$3
+			order by spotifyid
+			limit 1
+		`,
+		resourceType,
+		provider,
+		externalId,
+	).Scan(&spotifyId)
+
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return "", ErrResourceNotPreserved
+		}
+
+		return "", err
+	}
+
+	return spotifyId, nil
+}
+
+// Get a preserved track by an id issued by any provider.
+func (db *Db) GetTrackByExternalId(provider music.Provider, externalId string) (*music.Track, error) {
+	spotifyId, err := db.resolveExternalId(music.ResourceTrack, provider, externalId)
+	if err != nil {
+		return nil, err
+	}
+
+	return db.GetTrackById(spotifyId)
+}
+
+// Get a preserved album by an id issued by any provider.
+func (db *Db) GetAlbumByExternalId(provider music.Provider, externalId string) (*music.Album, error) {
+	spotifyId, err := db.resolveExternalId(music.ResourceAlbum, provider, externalId)
+	if err != nil {
+		return nil, err
+	}
+
+	return db.GetAlbumById(spotifyId)
+}
+
+// Get a preserved artist by an id issued by any provider. See music.ResourceProvider for the
+// meaning of discogFillLevel.
+func (db *Db) GetArtistByExternalId(provider music.Provider, externalId string, discogFillLevel int, albumTypes []music.AlbumType) (*music.Artist, error) {
+	spotifyId, err := db.resolveExternalId(music.ResourceArtist, provider, externalId)
+	if err != nil {
+		return nil, err
+	}
+
+	return db.GetArtistById(spotifyId, discogFillLevel, albumTypes)
+}
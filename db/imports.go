@@ -0,0 +1,328 @@
+package db
+
+import (
+	"bool3max/musicdash/spotify"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// An imported play is considered a duplicate of an already recorded play of the same track if they're
+// at most this far apart, as different sources record the time of the same play slightly differently.
+const ImportDuplicateWindow = time.Minute
+
+type ImportJobStatus string
+
+const (
+	ImportJobPending ImportJobStatus = "pending"
+	ImportJobRunning ImportJobStatus = "running"
+	ImportJobDone    ImportJobStatus = "done"
+	ImportJobFailed  ImportJobStatus = "failed"
+)
+
+var ErrImportJobNotFound = errors.New("import job not found")
+
+// A background job importing plays of a user from an external source, such as the Spotify extended
+// streaming history. Progress is recorded in the database as the job runs, by Db.SaveImportJob().
+type ImportJob struct {
+	Id     uuid.UUID
+	UserId uuid.UUID
+	// source of the imported plays
+	Source PlaySource
+	Status ImportJobStatus
+	Error  string
+
+	// number of plays to import, which may only become known once the job is running
+	Total int
+	// number of plays processed so far, the sum of the three counts below
+	Processed  int
+	Imported   int
+	Duplicates int
+	// plays whose tracks couldn't be resolved
+	Skipped int
+
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	FinishedAt *time.Time
+}
+
+// Whether the job has finished, successfully or not.
+func (job *ImportJob) Finished() bool {
+	return job.Status == ImportJobDone || job.Status == ImportJobFailed
+}
+
+const importJobColumns = `id, userid, source, status, coalesce(error, ''), total, processed, imported, duplicates, skipped, created_at, updated_at, finished_at`
+
+func scanImportJob(row pgx.Row) (ImportJob, error) {
+	var job ImportJob
+	err := row.Scan(
+		&job.Id,
+		&job.UserId,
+		&job.Source,
+		&job.Status,
+		&job.Error,
+		&job.Total,
+		&job.Processed,
+		&job.Imported,
+		&job.Duplicates,
+		&job.Skipped,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&job.FinishedAt,
+	)
+
+	return job, err
+}
+
+// Create a pending import job of the user, importing total plays from source.
+func (user *User) NewImportJob(ctx context.Context, database *Db, source PlaySource, total int) (ImportJob, error) {
+	return scanImportJob(database.pool.QueryRow(
+		ctx,
+		`
+			insert into public.import_job
+			(userid, source, total)
+			values ($1, $2, $3)
+			returning `+importJobColumns,
+		user.Id,
+		source,
+		total,
+	))
+}
+
+// Save the status, error, total and progress of the job. Once the job's status is set to a finished
+// one, its finish time is recorded as well.
+func (db *Db) SaveImportJob(ctx context.Context, job *ImportJob) error {
+	err := db.pool.QueryRow(
+		ctx,
+		`
+			update public.import_job
+			set status=@status,
+				error=@error,
+				total=@total,
+				processed=@processed,
+				imported=@imported,
+				duplicates=@duplicates,
+				skipped=@skipped,
+				updated_at=now(),
+				finished_at=case when @finished then coalesce(finished_at, now()) end
+			where id=@id
+			returning updated_at, finished_at
+		`,
+		pgx.NamedArgs{
+			"id":         job.Id,
+			"status":     job.Status,
+			"error":      nullString(job.Error),
+			"total":      job.Total,
+			"processed":  job.Processed,
+			"imported":   job.Imported,
+			"duplicates": job.Duplicates,
+			"skipped":    job.Skipped,
+			"finished":   job.Finished(),
+		},
+	).Scan(&job.UpdatedAt, &job.FinishedAt)
+
+	if err == pgx.ErrNoRows {
+		return ErrImportJobNotFound
+	}
+
+	return err
+}
+
+// Get one of the user's import jobs. If the user has no job with the id, ErrImportJobNotFound is returned.
+func (user *User) GetImportJob(ctx context.Context, database *Db, id uuid.UUID) (ImportJob, error) {
+	job, err := scanImportJob(database.pool.QueryRow(
+		ctx,
+		`
+			select `+importJobColumns+`
+			from public.import_job
+			where id=$1 and userid=$2
+		`,
+		id,
+		user.Id,
+	))
+
+	if err == pgx.ErrNoRows {
+		return ImportJob{}, ErrImportJobNotFound
+	}
+
+	return job, err
+}
+
+// Get all of the user's import jobs, most recent first.
+func (user *User) GetImportJobs(ctx context.Context, database *Db) ([]ImportJob, error) {
+	rows, err := database.pool.Query(
+		ctx,
+		`
+			select `+importJobColumns+`
+			from public.import_job
+			where userid=$1
+			order by created_at desc
+		`,
+		user.Id,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (ImportJob, error) {
+		return scanImportJob(row)
+	})
+}
+
+// Mark all unfinished import jobs as failed, returning their number. Jobs run within the process that
+// created them, so this should be called on startup for jobs interrupted by the process exiting.
+func (db *Db) FailInterruptedImportJobs(ctx context.Context) (int64, error) {
+	tag, err := db.pool.Exec(
+		ctx,
+		`
+			update public.import_job
+			set status='failed', error='interrupted', updated_at=now(), finished_at=now()
+			where status in ('pending', 'running')
+		`,
+	)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// Return those of the given track ids whose tracks aren't preserved in the database, each only once.
+func (db *Db) UnpreservedTrackIds(ctx context.Context, ids []string) ([]string, error) {
+	rows, err := db.pool.Query(
+		ctx,
+		`
+			select id
+			from unnest($1::varchar[]) as id
+			except
+			select spotifyid
+			from spotify.track
+		`,
+		uniqueIds(ids),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+// Record plays of the user imported from source, returning the number of imported plays and of
+// duplicates that weren't imported. Unlike User.SavePlays(), a play is a duplicate not only of a
+// play at exactly the same time, but of any play of the same track within ImportDuplicateWindow of it,
+// so that importing overlapping histories, or histories overlapping with aggregated plays, is harmless.
+// Only the SpotifyId of the plays' tracks is used.
+func (user *User) ImportPlays(ctx context.Context, database *Db, source PlaySource, plays []spotify.Play) (int, int, error) {
+	if len(plays) == 0 {
+		return 0, 0, nil
+	}
+
+	batch := &pgx.Batch{}
+	for _, play := range plays {
+		batch.Queue(
+			`
+				insert into public.plays
+				(userid, at, spotifyid, source)
+				select @userId, @at, @spotifyId, @source
+				where not exists (
+					select 1
+					from public.plays
+					where userid=@userId
+						and spotifyid=@spotifyId
+						and at between @at::timestamptz - @window::interval and @at::timestamptz + @window::interval
+				)
+				on conflict on constraint plays_userid_at_key do nothing
+			`,
+			pgx.NamedArgs{
+				"userId":    user.Id,
+				"at":        play.At,
+				"spotifyId": play.Track.SpotifyId,
+				"source":    source,
+				"window":    ImportDuplicateWindow,
+			},
+		)
+	}
+
+	imported := 0
+	err := pgx.BeginFunc(ctx, database.pool, func(tx pgx.Tx) error {
+		results := tx.SendBatch(ctx, batch)
+		defer results.Close()
+
+		for range plays {
+			tag, err := results.Exec()
+			if err != nil {
+				return err
+			}
+
+			imported += int(tag.RowsAffected())
+		}
+
+		return results.Close()
+	})
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return imported, len(plays) - imported, nil
+}
+
+// An imported play that couldn't be matched to a track, as described by its source.
+type UnmatchedPlay struct {
+	At     time.Time
+	Artist string
+	Album  string
+	Title  string
+}
+
+// Record plays of the job that couldn't be matched to tracks, so that they can be reported to the user.
+func (db *Db) SaveUnmatchedPlays(ctx context.Context, jobId uuid.UUID, plays []UnmatchedPlay) error {
+	if len(plays) == 0 {
+		return nil
+	}
+
+	rows := make([][]any, len(plays))
+	for idx, play := range plays {
+		rows[idx] = []any{jobId, play.At, play.Artist, play.Album, play.Title}
+	}
+
+	_, err := db.pool.CopyFrom(
+		ctx,
+		pgx.Identifier{"public", "import_unmatched"},
+		[]string{"jobid", "at", "artist", "album", "title"},
+		pgx.CopyFromRows(rows),
+	)
+
+	return err
+}
+
+// Get the plays of one of the user's import jobs that couldn't be matched to tracks, oldest first. If the
+// user has no job with the id, ErrImportJobNotFound is returned.
+func (user *User) GetUnmatchedPlays(ctx context.Context, database *Db, jobId uuid.UUID) ([]UnmatchedPlay, error) {
+	if _, err := user.GetImportJob(ctx, database, jobId); err != nil {
+		return nil, err
+	}
+
+	rows, err := database.pool.Query(
+		ctx,
+		`
+			select at, artist, album, title
+			from public.import_unmatched
+			where jobid=$1
+			order by at
+		`,
+		jobId,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowToStructByPos[UnmatchedPlay])
+}
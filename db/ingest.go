@@ -0,0 +1,196 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrInvalidIngestToken = errors.New("invalid ingest token")
+var ErrIngestTokenNotFound = errors.New("user has no ingest token")
+
+// An ingest token authenticates plays submitted by other players through the ingest API. It consists of
+// 16 random bytes encoded in hex, in the format of Last.fm session keys, so that it can be used as one by
+// Audioscrobbler clients. A user has at most one token, of which only the SHA-256 hash is stored.
+type IngestToken struct {
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+}
+
+// Issue a new ingest token for the user, replacing any previous one, and return it.
+func (user *User) NewIngestToken(ctx context.Context, database *Db) (string, error) {
+	tokenBytes := make([]byte, 16)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", err
+	}
+
+	token := hex.EncodeToString(tokenBytes)
+
+	_, err := database.pool.Exec(
+		ctx,
+		`
+			insert into auth.ingest_token
+			(userid, token_hash)
+			values (@userId, @tokenHash)
+			on conflict (userid) do update
+			set token_hash=excluded.token_hash,
+				created_at=now(),
+				last_used_at=null
+		`,
+		pgx.NamedArgs{
+			"userId":    user.Id,
+			"tokenHash": hashToken(token),
+		},
+	)
+
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// Get the user's ingest token. If there's none, ErrIngestTokenNotFound is returned.
+func (user *User) GetIngestToken(ctx context.Context, database *Db) (IngestToken, error) {
+	var token IngestToken
+
+	err := database.pool.QueryRow(
+		ctx,
+		`
+			select created_at, last_used_at
+			from auth.ingest_token
+			where userid=$1
+		`,
+		user.Id,
+	).Scan(&token.CreatedAt, &token.LastUsedAt)
+
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return IngestToken{}, ErrIngestTokenNotFound
+		}
+
+		return IngestToken{}, err
+	}
+
+	return token, nil
+}
+
+// Revoke the user's ingest token. If there's none, ErrIngestTokenNotFound is returned.
+func (user *User) RevokeIngestToken(ctx context.Context, database *Db) error {
+	tag, err := database.pool.Exec(
+		ctx,
+		`
+			delete from auth.ingest_token
+			where userid=$1
+		`,
+		user.Id,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrIngestTokenNotFound
+	}
+
+	return nil
+}
+
+// Return the user that an ingest token belongs to, recording its use. If the token is invalid, or its
+// user's account is scheduled for deletion, ErrInvalidIngestToken is returned.
+func (db *Db) GetUserFromIngestToken(ctx context.Context, token string) (User, error) {
+	var userId uuid.UUID
+
+	err := db.pool.QueryRow(
+		ctx,
+		`
+			update auth.ingest_token
+			set last_used_at=now()
+			from auth.user
+			where ingest_token.token_hash=$1
+				and auth.user.id=ingest_token.userid
+				and auth.user.purge_at is null
+			returning ingest_token.userid
+		`,
+		hashToken(token),
+	).Scan(&userId)
+
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return User{}, ErrInvalidIngestToken
+		}
+
+		return User{}, err
+	}
+
+	return db.GetUserFromId(ctx, userId)
+}
+
+// A play submitted through the ingest API that couldn't be matched to a track.
+type UnmatchedIngestedPlay struct {
+	UnmatchedPlay
+	Source PlaySource
+}
+
+// Record plays of the user submitted from source that couldn't be matched to tracks, so that they can be
+// reported to the user. Plays already recorded as unmatched at the same time, such as ones submitted again
+// by a client retrying a submission, are skipped.
+func (user *User) SaveUnmatchedIngestedPlays(ctx context.Context, database *Db, source PlaySource, plays []UnmatchedPlay) error {
+	if len(plays) == 0 {
+		return nil
+	}
+
+	batch := &pgx.Batch{}
+	for _, play := range plays {
+		batch.Queue(
+			`
+				insert into public.ingest_unmatched
+				(userid, at, source, artist, album, title)
+				values (@userId, @at, @source, @artist, @album, @title)
+				on conflict on constraint ingest_unmatched_userid_at_key do nothing
+			`,
+			pgx.NamedArgs{
+				"userId": user.Id,
+				"at":     play.At,
+				"source": source,
+				"artist": play.Artist,
+				"album":  play.Album,
+				"title":  play.Title,
+			},
+		)
+	}
+
+	return database.pool.SendBatch(ctx, batch).Close()
+}
+
+// Get up to limit of the user's submitted plays that couldn't be matched to tracks, most recent first.
+func (user *User) GetUnmatchedIngestedPlays(ctx context.Context, database *Db, limit int) ([]UnmatchedIngestedPlay, error) {
+	rows, err := database.pool.Query(
+		ctx,
+		`
+			select at, artist, album, title, source
+			from public.ingest_unmatched
+			where userid=$1
+			order by at desc
+			limit $2
+		`,
+		user.Id,
+		limit,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (UnmatchedIngestedPlay, error) {
+		var play UnmatchedIngestedPlay
+		err := row.Scan(&play.At, &play.Artist, &play.Album, &play.Title, &play.Source)
+		return play, err
+	})
+}
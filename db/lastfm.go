@@ -0,0 +1,182 @@
+package db
+
+import (
+	"bool3max/musicdash/music"
+	"bool3max/musicdash/spotify"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrLastfmNotLinked = errors.New("user has no linked last.fm account")
+
+// A Last.fm account linked to a user for scrobbling the user's plays.
+type LastfmLink struct {
+	Username   string
+	SessionKey string
+	LinkedAt   time.Time
+
+	// time of the most recent play scrobbled so far
+	ScrobbledUntil time.Time
+}
+
+// Link a Last.fm account to the user, given the session key obtained by the user authorizing musicdash,
+// replacing any previously linked account. Only plays after the time of linking are scrobbled.
+func (user *User) LinkLastfm(ctx context.Context, database *Db, username, sessionKey string) error {
+	keyId, sealedKey, err := database.keys.seal(sessionKey, user.Id[:])
+	if err != nil {
+		return err
+	}
+
+	_, err = database.pool.Exec(
+		ctx,
+		`
+			insert into auth.user_lastfm
+			(userid, username, session_key, keyid)
+			values (@userId, @username, @sessionKey, @keyId)
+			on conflict (userid) do update
+			set username=excluded.username,
+				session_key=excluded.session_key,
+				keyid=excluded.keyid,
+				linked_at=now(),
+				scrobbled_until=now()
+		`,
+		pgx.NamedArgs{
+			"userId":     user.Id,
+			"username":   username,
+			"sessionKey": sealedKey,
+			"keyId":      keyId,
+		},
+	)
+
+	return err
+}
+
+// Get the Last.fm account linked to the user. If there's none, ErrLastfmNotLinked is returned.
+func (user *User) GetLastfmLink(ctx context.Context, database *Db) (LastfmLink, error) {
+	var link LastfmLink
+	var keyId string
+
+	err := database.pool.QueryRow(
+		ctx,
+		`
+			select username, session_key, keyid, linked_at, scrobbled_until
+			from auth.user_lastfm
+			where userid=$1
+		`,
+		user.Id,
+	).Scan(&link.Username, &link.SessionKey, &keyId, &link.LinkedAt, &link.ScrobbledUntil)
+
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return LastfmLink{}, ErrLastfmNotLinked
+		}
+
+		return LastfmLink{}, err
+	}
+
+	if link.SessionKey, err = database.keys.open(keyId, link.SessionKey, user.Id[:]); err != nil {
+		return LastfmLink{}, err
+	}
+
+	return link, nil
+}
+
+// Unlink the user's Last.fm account, stopping scrobbling. If there's none, ErrLastfmNotLinked is returned.
+func (user *User) UnlinkLastfm(ctx context.Context, database *Db) error {
+	tag, err := database.pool.Exec(
+		ctx,
+		`
+			delete from auth.user_lastfm
+			where userid=$1
+		`,
+		user.Id,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrLastfmNotLinked
+	}
+
+	return nil
+}
+
+// Get up to limit of the user's plays aggregated from Spotify after the given time, oldest first, that
+// are to be scrobbled or submitted to another service. Imported plays are never submitted anywhere. spotifyProvider may be nil, see User.GetPlays().
+func (user *User) GetPlaysToScrobble(ctx context.Context, database *Db, after time.Time, limit int, spotifyProvider music.ResourceProvider) ([]spotify.Play, error) {
+	rows, err := database.pool.Query(
+		ctx,
+		`
+			select spotifyid, at
+			from public.plays
+			where userid=@userId and source=@source and at > @after
+			order by at
+			limit @limit
+		`,
+		pgx.NamedArgs{
+			"userId": user.Id,
+			"source": PlaySourceSpotify,
+			"after":  after,
+			"limit":  limit,
+		},
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	plays := make([]spotify.Play, 0, limit)
+	trackIds := make([]string, 0, limit)
+
+	var spotifyId string
+	var at time.Time
+	_, err = pgx.ForEachRow(rows, []any{&spotifyId, &at}, func() error {
+		plays = append(plays, spotify.Play{At: at})
+		trackIds = append(trackIds, spotifyId)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	tracks, err := database.getTracksWithFallback(ctx, trackIds, spotifyProvider)
+	if err != nil {
+		return nil, err
+	}
+
+	for idx := range plays {
+		plays[idx].Track = tracks[idx]
+	}
+
+	return plays, nil
+}
+
+// Record that the user's plays up to and including the given time have been scrobbled.
+func (user *User) SetScrobbledUntil(ctx context.Context, database *Db, until time.Time) error {
+	tag, err := database.pool.Exec(
+		ctx,
+		`
+			update auth.user_lastfm
+			set scrobbled_until=$2
+			where userid=$1
+		`,
+		user.Id,
+		until,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrLastfmNotLinked
+	}
+
+	return nil
+}
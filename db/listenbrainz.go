@@ -0,0 +1,130 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrListenBrainzNotLinked = errors.New("user has no linked listenbrainz account")
+
+// A ListenBrainz account linked to a user for submitting the user's plays.
+type ListenBrainzLink struct {
+	Username string
+	Token    string
+	LinkedAt time.Time
+
+	// time of the most recent play submitted so far
+	SubmittedUntil time.Time
+}
+
+// Link a ListenBrainz account to the user, given the account's user token, replacing any previously
+// linked account. Only plays after the time of linking are submitted.
+func (user *User) LinkListenBrainz(ctx context.Context, database *Db, username, token string) error {
+	keyId, sealedToken, err := database.keys.seal(token, user.Id[:])
+	if err != nil {
+		return err
+	}
+
+	_, err = database.pool.Exec(
+		ctx,
+		`
+			insert into auth.user_listenbrainz
+			(userid, username, token, keyid)
+			values (@userId, @username, @token, @keyId)
+			on conflict (userid) do update
+			set username=excluded.username,
+				token=excluded.token,
+				keyid=excluded.keyid,
+				linked_at=now(),
+				submitted_until=now()
+		`,
+		pgx.NamedArgs{
+			"userId":   user.Id,
+			"username": username,
+			"token":    sealedToken,
+			"keyId":    keyId,
+		},
+	)
+
+	return err
+}
+
+// Get the ListenBrainz account linked to the user. If there's none, ErrListenBrainzNotLinked is returned.
+func (user *User) GetListenBrainzLink(ctx context.Context, database *Db) (ListenBrainzLink, error) {
+	var link ListenBrainzLink
+	var keyId string
+
+	err := database.pool.QueryRow(
+		ctx,
+		`
+			select username, token, keyid, linked_at, submitted_until
+			from auth.user_listenbrainz
+			where userid=$1
+		`,
+		user.Id,
+	).Scan(&link.Username, &link.Token, &keyId, &link.LinkedAt, &link.SubmittedUntil)
+
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return ListenBrainzLink{}, ErrListenBrainzNotLinked
+		}
+
+		return ListenBrainzLink{}, err
+	}
+
+	if link.Token, err = database.keys.open(keyId, link.Token, user.Id[:]); err != nil {
+		return ListenBrainzLink{}, err
+	}
+
+	return link, nil
+}
+
+// Unlink the user's ListenBrainz account, stopping submissions. If there's none, ErrListenBrainzNotLinked
+// is returned.
+func (user *User) UnlinkListenBrainz(ctx context.Context, database *Db) error {
+	tag, err := database.pool.Exec(
+		ctx,
+		`
+			delete from auth.user_listenbrainz
+			where userid=$1
+		`,
+		user.Id,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrListenBrainzNotLinked
+	}
+
+	return nil
+}
+
+// Record that the user's plays up to and including the given time have been submitted to ListenBrainz.
+func (user *User) SetSubmittedUntil(ctx context.Context, database *Db, until time.Time) error {
+	tag, err := database.pool.Exec(
+		ctx,
+		`
+			update auth.user_listenbrainz
+			set submitted_until=$2
+			where userid=$1
+		`,
+		user.Id,
+		until,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrListenBrainzNotLinked
+	}
+
+	return nil
+}
@@ -0,0 +1,369 @@
+package db
+
+import (
+	music "bool3max/musicdash/music"
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// The loaders below fetch many catalog resources at once using a fixed number of queries,
+// regardless of the number of ids. Ids that aren't preserved are simply missing from the
+// returned maps, which are keyed by Spotify id.
+
+// Remove duplicate and empty ids.
+func uniqueIds(ids []string) []string {
+	unique := make([]string, 0, len(ids))
+	seen := make(map[string]bool, len(ids))
+
+	for _, id := range ids {
+		if id != "" && !seen[id] {
+			unique = append(unique, id)
+			seen[id] = true
+		}
+	}
+
+	return unique
+}
+
+// Load the complete sets of external ids of many preserved resources of the same type,
+// including their Spotify ids.
+func (db *Db) loadExternalIds(ctx context.Context, resourceType music.ResourceType, spotifyIds []string) (map[string]music.ExternalIds, error) {
+	ids := make(map[string]music.ExternalIds, len(spotifyIds))
+	for _, spotifyId := range spotifyIds {
+		ids[spotifyId] = music.ExternalIds{music.ProviderSpotify: spotifyId}
+	}
+
+	rows, err := db.pool.Query(
+		ctx,
+		`
+			select spotifyid, provider, externalid
+			from spotify.external_id
+			where resourcetype=$1 and spotifyid=any($2) and externalid <> ''
+		`,
+		resourceType,
+		spotifyIds,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var spotifyId, provider, externalId string
+	_, err = pgx.ForEachRow(rows, []any{&spotifyId, &provider, &externalId}, func() error {
+		resourceIds := ids[spotifyId]
+		resourceIds.Set(music.Provider(provider), externalId)
+		ids[spotifyId] = resourceIds
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+func (db *Db) loadArtists(ctx context.Context, artistIds []string) (map[string]music.Artist, error) {
+	artistIds = uniqueIds(artistIds)
+	artists := make(map[string]music.Artist, len(artistIds))
+
+	if len(artistIds) == 0 {
+		return artists, nil
+	}
+
+	rows, err := db.pool.Query(
+		ctx,
+		`
+			select spotifyid, name, spotifyuri, followers
+			from spotify.artist
+			where spotifyid=any($1)
+		`,
+		artistIds,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var artist music.Artist
+	_, err = pgx.ForEachRow(rows, []any{&artist.SpotifyId, &artist.Name, &artist.SpotifyURI, &artist.SpotifyFollowerCount}, func() error {
+		artists[artist.SpotifyId] = artist
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	externalIds, err := db.loadExternalIds(ctx, music.ResourceArtist, artistIds)
+	if err != nil {
+		return nil, err
+	}
+
+	for artistId, artist := range artists {
+		artist.ExternalIds = externalIds[artistId]
+		artists[artistId] = artist
+	}
+
+	return artists, nil
+}
+
+// Load albums along with their artists. Tracklists are never filled.
+func (db *Db) loadAlbums(ctx context.Context, albumIds []string) (map[string]music.Album, error) {
+	albumIds = uniqueIds(albumIds)
+	albums := make(map[string]music.Album, len(albumIds))
+
+	if len(albumIds) == 0 {
+		return albums, nil
+	}
+
+	rows, err := db.pool.Query(
+		ctx,
+		`
+			select spotifyid, title, counttracks, releasedate, spotifyuri, type, upc
+			from spotify.album
+			where spotifyid=any($1)
+		`,
+		albumIds,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var album music.Album
+	var albumType string
+	_, err = pgx.ForEachRow(rows, []any{&album.SpotifyId, &album.Title, &album.CountTracks, &album.ReleaseDate, &album.SpotifyURI, &albumType, &album.Upc}, func() error {
+		var err error
+		if album.Type, err = music.ParseAlbumType(albumType); err != nil {
+			return err
+		}
+
+		albums[album.SpotifyId] = album
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	// spotify ids of all album artists, main artist first
+	rows, err = db.pool.Query(
+		ctx,
+		`
+			select spotifyidalbum, spotifyidartist
+			from spotify.album_artist
+			where spotifyidalbum=any($1) and albumgroup<>'appears_on'
+			order by spotifyidalbum, ismain desc
+		`,
+		albumIds,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	albumArtistIds := make(map[string][]string, len(albums))
+	allArtistIds := make([]string, 0)
+
+	var albumId, artistId string
+	_, err = pgx.ForEachRow(rows, []any{&albumId, &artistId}, func() error {
+		albumArtistIds[albumId] = append(albumArtistIds[albumId], artistId)
+		allArtistIds = append(allArtistIds, artistId)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	artists, err := db.loadArtists(ctx, allArtistIds)
+	if err != nil {
+		return nil, err
+	}
+
+	externalIds, err := db.loadExternalIds(ctx, music.ResourceAlbum, albumIds)
+	if err != nil {
+		return nil, err
+	}
+
+	for albumId, album := range albums {
+		album.ExternalIds = externalIds[albumId]
+		for _, artistId := range albumArtistIds[albumId] {
+			if artist, ok := artists[artistId]; ok {
+				album.Artists = append(album.Artists, artist)
+			}
+		}
+
+		albums[albumId] = album
+	}
+
+	return albums, nil
+}
+
+// Load tracks along with their albums and artists.
+func (db *Db) loadTracks(ctx context.Context, trackIds []string) (map[string]music.Track, error) {
+	trackIds = uniqueIds(trackIds)
+	tracks := make(map[string]music.Track, len(trackIds))
+
+	if len(trackIds) == 0 {
+		return tracks, nil
+	}
+
+	rows, err := db.pool.Query(
+		ctx,
+		`
+			select spotifyid, title, duration, tracklistnum, discnum, popularity, spotifyuri, explicit, isrc, spotifyidalbum
+			from spotify.track
+			where spotifyid=any($1)
+		`,
+		trackIds,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// id of belonging album of every track
+	albumIdOf := make(map[string]string, len(trackIds))
+
+	var track music.Track
+	var albumId string
+
+	// track duration in milliseconds from database
+	var trackDuration uint
+
+	_, err = pgx.ForEachRow(
+		rows,
+		[]any{&track.SpotifyId, &track.Title, &trackDuration, &track.TracklistNum, &track.DiscNum, &track.SpotifyPopularity, &track.SpotifyURI, &track.IsExplicit, &track.Isrc, &albumId},
+		func() error {
+			// convert millisecond uint duration to time.Duration
+			track.Duration = time.Duration(trackDuration) * time.Millisecond
+			tracks[track.SpotifyId] = track
+			albumIdOf[track.SpotifyId] = albumId
+			return nil
+		},
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// spotify ids of all artists on the tracks, main artist first
+	rows, err = db.pool.Query(
+		ctx,
+		`
+			select spotifyidtrack, spotifyidartist
+			from spotify.track_artist
+			where spotifyidtrack=any($1)
+			order by spotifyidtrack, ismain desc
+		`,
+		trackIds,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	trackArtistIds := make(map[string][]string, len(tracks))
+	allArtistIds := make([]string, 0)
+
+	var trackId, artistId string
+	_, err = pgx.ForEachRow(rows, []any{&trackId, &artistId}, func() error {
+		trackArtistIds[trackId] = append(trackArtistIds[trackId], artistId)
+		allArtistIds = append(allArtistIds, artistId)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	artists, err := db.loadArtists(ctx, allArtistIds)
+	if err != nil {
+		return nil, err
+	}
+
+	allAlbumIds := make([]string, 0, len(albumIdOf))
+	for _, albumId := range albumIdOf {
+		allAlbumIds = append(allAlbumIds, albumId)
+	}
+
+	albums, err := db.loadAlbums(ctx, allAlbumIds)
+	if err != nil {
+		return nil, err
+	}
+
+	externalIds, err := db.loadExternalIds(ctx, music.ResourceTrack, trackIds)
+	if err != nil {
+		return nil, err
+	}
+
+	for trackId, track := range tracks {
+		track.ExternalIds = externalIds[trackId]
+		track.Album = albums[albumIdOf[trackId]]
+
+		for _, artistId := range trackArtistIds[trackId] {
+			if artist, ok := artists[artistId]; ok {
+				track.Artists = append(track.Artists, artist)
+			}
+		}
+
+		tracks[trackId] = track
+	}
+
+	return tracks, nil
+}
+
+// Get many tracks at once, in the order of ids, getting those that aren't preserved from
+// spotifyProvider instead. If spotifyProvider is nil, tracks that aren't preserved, as well as
+// ones unknown to it, only have their Spotify id set.
+func (db *Db) getTracksWithFallback(ctx context.Context, ids []string, spotifyProvider music.ResourceProvider) ([]music.Track, error) {
+	preserved, err := db.loadTracks(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	// ids of resources of other providers, such as local files, are unknown to Spotify
+	missingIds := make([]string, 0)
+	for _, id := range uniqueIds(ids) {
+		if _, ok := preserved[id]; ok {
+			continue
+		}
+
+		if music.IsSpotifyId(id) {
+			missingIds = append(missingIds, id)
+		} else {
+			preserved[id] = music.Track{SpotifyId: id}
+		}
+	}
+
+	if len(missingIds) > 0 && spotifyProvider == nil {
+		for _, id := range missingIds {
+			preserved[id] = music.Track{SpotifyId: id}
+		}
+	} else if len(missingIds) > 0 {
+		fetched, err := spotifyProvider.GetSeveralTracksById(missingIds)
+		if err != nil {
+			return nil, err
+		}
+
+		for idx, track := range fetched {
+			// ids unknown to Spotify are returned as empty tracks
+			if track.SpotifyId != missingIds[idx] {
+				track = music.Track{SpotifyId: missingIds[idx]}
+			}
+
+			preserved[missingIds[idx]] = track
+		}
+	}
+
+	tracks := make([]music.Track, len(ids))
+	for idx, id := range ids {
+		tracks[idx] = preserved[id]
+	}
+
+	return tracks, nil
+}
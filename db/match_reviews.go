@@ -0,0 +1,280 @@
+package db
+
+import (
+	"bool3max/musicdash/spotify"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+type MatchReviewStatus string
+
+const (
+	MatchReviewPending   MatchReviewStatus = "pending"
+	MatchReviewConfirmed MatchReviewStatus = "confirmed"
+	MatchReviewCorrected MatchReviewStatus = "corrected"
+)
+
+var ErrMatchReviewNotFound = errors.New("match review not found")
+
+// A candidate track of a match review. The track's details are stored along with the review, so that
+// the candidates can be shown without them being preserved.
+type MatchReviewCandidate struct {
+	SpotifyId  string   `json:"spotify_id"`
+	Title      string   `json:"title"`
+	Artists    []string `json:"artists"`
+	Album      string   `json:"album"`
+	DurationMs int64    `json:"duration_ms"`
+	Score      float64  `json:"score"`
+}
+
+// A low-confidence match of plays with the given metadata to a track, which the user is to confirm or
+// correct. Plays are recorded as the best candidate in the meantime. Every distinct artist, album and
+// title, ignoring case, has at most one review per user, which later plays with the same metadata are
+// matched by.
+type MatchReview struct {
+	Id     uuid.UUID
+	Artist string
+	Album  string
+	Title  string
+
+	// the track that the plays are recorded as
+	SpotifyId string
+	// score of the best candidate
+	Score float64
+	// best first
+	Candidates []MatchReviewCandidate
+
+	Status     MatchReviewStatus
+	CreatedAt  time.Time
+	ReviewedAt *time.Time
+}
+
+const matchReviewColumns = `id, artist, album, title, spotifyid, score, candidates, status, created_at, reviewed_at`
+
+func scanMatchReview(row pgx.Row) (MatchReview, error) {
+	var review MatchReview
+	err := row.Scan(
+		&review.Id,
+		&review.Artist,
+		&review.Album,
+		&review.Title,
+		&review.SpotifyId,
+		&review.Score,
+		&review.Candidates,
+		&review.Status,
+		&review.CreatedAt,
+		&review.ReviewedAt,
+	)
+
+	if err == pgx.ErrNoRows {
+		return MatchReview{}, ErrMatchReviewNotFound
+	}
+
+	return review, err
+}
+
+// Create a pending review of a match for the user, unless the user already has one for the same
+// metadata, and return the stored review.
+func (user *User) NewMatchReview(ctx context.Context, database *Db, review MatchReview) (MatchReview, error) {
+	_, err := database.pool.Exec(
+		ctx,
+		`
+			insert into public.match_review
+			(userid, artist, album, title, spotifyid, score, candidates)
+			values (@userId, @artist, @album, @title, @spotifyId, @score, @candidates)
+			on conflict do nothing
+		`,
+		pgx.NamedArgs{
+			"userId":     user.Id,
+			"artist":     review.Artist,
+			"album":      review.Album,
+			"title":      review.Title,
+			"spotifyId":  review.SpotifyId,
+			"score":      review.Score,
+			"candidates": review.Candidates,
+		},
+	)
+
+	if err != nil {
+		return MatchReview{}, err
+	}
+
+	return user.GetMatchReviewByMetadata(ctx, database, review.Artist, review.Album, review.Title)
+}
+
+// Get the user's review of the match of plays with the given metadata, ignoring case. If there's none,
+// ErrMatchReviewNotFound is returned.
+func (user *User) GetMatchReviewByMetadata(ctx context.Context, database *Db, artist, album, title string) (MatchReview, error) {
+	return scanMatchReview(database.pool.QueryRow(
+		ctx,
+		`
+			select `+matchReviewColumns+`
+			from public.match_review
+			where userid=@userId and lower(artist)=lower(@artist) and lower(album)=lower(@album) and lower(title)=lower(@title)
+		`,
+		pgx.NamedArgs{
+			"userId": user.Id,
+			"artist": artist,
+			"album":  album,
+			"title":  title,
+		},
+	))
+}
+
+// Get one of the user's match reviews. If the user has none with the id, ErrMatchReviewNotFound is returned.
+func (user *User) GetMatchReview(ctx context.Context, database *Db, id uuid.UUID) (MatchReview, error) {
+	return scanMatchReview(database.pool.QueryRow(
+		ctx,
+		`
+			select `+matchReviewColumns+`
+			from public.match_review
+			where id=$1 and userid=$2
+		`,
+		id,
+		user.Id,
+	))
+}
+
+// Get up to limit of the user's match reviews with the given status, most recent first.
+func (user *User) GetMatchReviews(ctx context.Context, database *Db, status MatchReviewStatus, limit int) ([]MatchReview, error) {
+	rows, err := database.pool.Query(
+		ctx,
+		`
+			select `+matchReviewColumns+`
+			from public.match_review
+			where userid=$1 and status=$2
+			order by created_at desc
+			limit $3
+		`,
+		user.Id,
+		status,
+		limit,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (MatchReview, error) {
+		return scanMatchReview(row)
+	})
+}
+
+// Confirm that the track of one of the user's match reviews is the right one. If the user has no review
+// with the id, ErrMatchReviewNotFound is returned.
+func (user *User) ConfirmMatchReview(ctx context.Context, database *Db, id uuid.UUID) error {
+	tag, err := database.pool.Exec(
+		ctx,
+		`
+			update public.match_review
+			set status=@status, reviewed_at=now()
+			where id=@id and userid=@userId
+		`,
+		pgx.NamedArgs{
+			"status": MatchReviewConfirmed,
+			"id":     id,
+			"userId": user.Id,
+		},
+	)
+
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrMatchReviewNotFound
+	}
+
+	return nil
+}
+
+// Correct the track of one of the user's match reviews, re-pointing all plays recorded by the match to
+// it, and return the number of those plays. The track must be preserved. If the user has no review with
+// the id, ErrMatchReviewNotFound is returned.
+func (user *User) CorrectMatchReview(ctx context.Context, database *Db, id uuid.UUID, spotifyId string) (int, error) {
+	var corrected int
+
+	err := pgx.BeginFunc(ctx, database.pool, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(
+			ctx,
+			`
+				update public.match_review
+				set spotifyid=@spotifyId, status=@status, reviewed_at=now()
+				where id=@id and userid=@userId
+			`,
+			pgx.NamedArgs{
+				"spotifyId": spotifyId,
+				"status":    MatchReviewCorrected,
+				"id":        id,
+				"userId":    user.Id,
+			},
+		)
+
+		if err != nil {
+			return err
+		}
+
+		if tag.RowsAffected() == 0 {
+			return ErrMatchReviewNotFound
+		}
+
+		tag, err = tx.Exec(
+			ctx,
+			`
+				update public.plays
+				set spotifyid=$1
+				where userid=$2 and reviewid=$3
+			`,
+			spotifyId,
+			user.Id,
+			id,
+		)
+
+		corrected = int(tag.RowsAffected())
+		return err
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return corrected, nil
+}
+
+// Record that the user's plays, imported from source, were recorded by the matches of the reviews with the
+// given ids, in order of plays. Plays whose review id is uuid.Nil are left as they are, as are plays at the
+// same time recorded from other sources, such as ones aggregated from Spotify, so that correcting a review
+// never re-points them.
+func (user *User) LinkPlaysToReviews(ctx context.Context, database *Db, source PlaySource, plays []spotify.Play, reviewIds []uuid.UUID) error {
+	batch := &pgx.Batch{}
+	for idx, play := range plays {
+		if reviewIds[idx] == uuid.Nil {
+			continue
+		}
+
+		batch.Queue(
+			`
+				update public.plays
+				set reviewid=@reviewId
+				where userid=@userId and at=@at and spotifyid=@spotifyId and source=@source and reviewid is null
+			`,
+			pgx.NamedArgs{
+				"reviewId":  reviewIds[idx],
+				"userId":    user.Id,
+				"at":        play.At,
+				"spotifyId": play.Track.SpotifyId,
+				"source":    source,
+			},
+		)
+	}
+
+	if batch.Len() == 0 {
+		return nil
+	}
+
+	return database.pool.SendBatch(ctx, batch).Close()
+}
@@ -0,0 +1,17 @@
+-- Drops everything created by the initial schema, including all data.
+
+DROP TABLE public.plays;
+
+DROP SCHEMA spotify CASCADE;
+
+DROP SCHEMA auth CASCADE;
+
+DROP FUNCTION public.generate_uid(size integer);
+
+DROP EXTENSION pgcrypto;
+
+DROP EXTENSION fuzzystrmatch;
+
+DROP EXTENSION citext;
+
+GRANT USAGE ON SCHEMA public TO PUBLIC;
@@ -0,0 +1,231 @@
+-- Initial schema, equivalent to the last musicdash_create_db_and_schemas.sql dump taken before
+-- migrations were introduced. Databases created from that dump are baselined at this version
+-- instead of having it applied.
+
+CREATE SCHEMA auth;
+
+CREATE SCHEMA spotify;
+
+CREATE EXTENSION IF NOT EXISTS citext WITH SCHEMA public;
+
+COMMENT ON EXTENSION citext IS 'data type for case-insensitive character strings';
+
+CREATE EXTENSION IF NOT EXISTS fuzzystrmatch WITH SCHEMA public;
+
+COMMENT ON EXTENSION fuzzystrmatch IS 'determine similarities and distance between strings';
+
+CREATE EXTENSION IF NOT EXISTS pgcrypto WITH SCHEMA public;
+
+COMMENT ON EXTENSION pgcrypto IS 'cryptographic functions';
+
+CREATE FUNCTION public.generate_uid(size integer) RETURNS text
+    LANGUAGE plpgsql
+    AS $$
+DECLARE
+  characters TEXT := 'ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789';
+  bytes BYTEA := gen_random_bytes(size);
+  l INT := length(characters);
+  i INT := 0;
+  output TEXT := '';
+BEGIN
+  WHILE i < size LOOP
+    output := output || substr(characters, get_byte(bytes, i) % l + 1, 1);
+    i := i + 1;
+  END LOOP;
+  RETURN output;
+END;
+$$;
+
+CREATE TABLE auth.auth_token (
+    userid uuid NOT NULL,
+    token character varying NOT NULL,
+    granted_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+CREATE TABLE auth.spotify_token (
+    userid uuid NOT NULL,
+    accesstoken character varying NOT NULL,
+    refreshtoken character varying NOT NULL,
+    expiresat timestamp with time zone NOT NULL
+);
+
+CREATE TABLE auth."user" (
+    id uuid DEFAULT gen_random_uuid() NOT NULL,
+    username character varying(30) NOT NULL,
+    registered_at timestamp without time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    email public.citext NOT NULL,
+    pwdhash bytea,
+    refreshedat timestamp with time zone
+);
+
+CREATE TABLE auth.user_profile_img (
+    userid uuid NOT NULL,
+    width integer NOT NULL,
+    height integer NOT NULL,
+    data bytea NOT NULL,
+    uploaded_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    size integer NOT NULL
+);
+
+CREATE TABLE auth.user_spotify (
+    userid uuid NOT NULL,
+    spotify_displayname character varying NOT NULL,
+    spotify_followers integer,
+    spotify_uri character varying NOT NULL,
+    profile_image_url character varying NOT NULL,
+    profile_image_width integer NOT NULL,
+    profile_image_height integer NOT NULL,
+    country character varying,
+    spotify_email character varying NOT NULL,
+    spotify_url character varying NOT NULL,
+    spotify_id character varying NOT NULL
+);
+
+CREATE TABLE public.plays (
+    userid uuid NOT NULL,
+    spotifyid character varying NOT NULL,
+    at timestamp with time zone NOT NULL
+);
+
+COMMENT ON TABLE public.plays IS 'Stores all individual plays by musicdash users. One recorded play per row.';
+
+CREATE TABLE spotify.album (
+    spotifyid character(22) NOT NULL,
+    title character varying NOT NULL,
+    counttracks integer NOT NULL,
+    releasedate date,
+    spotifyuri character varying NOT NULL,
+    type character varying NOT NULL,
+    isrc character varying,
+    ean character varying,
+    upc character varying
+);
+
+CREATE TABLE spotify.album_artist (
+    spotifyidartist character(22) NOT NULL,
+    spotifyidalbum character(22) NOT NULL,
+    ismain boolean NOT NULL
+);
+
+CREATE TABLE spotify.artist (
+    spotifyid character(22) NOT NULL,
+    name character varying NOT NULL,
+    spotifyuri character varying,
+    followers integer
+);
+
+CREATE TABLE spotify.images (
+    width integer NOT NULL,
+    height integer NOT NULL,
+    mimetype character varying NOT NULL,
+    spotifyid character(22) NOT NULL,
+    data bytea NOT NULL,
+    url character varying NOT NULL
+);
+
+CREATE TABLE spotify.track (
+    spotifyid character(22) NOT NULL,
+    title character varying NOT NULL,
+    duration integer NOT NULL,
+    tracklistnum integer,
+    popularity integer,
+    spotifyuri character varying NOT NULL,
+    explicit boolean NOT NULL,
+    isrc character varying,
+    ean character varying,
+    upc character varying,
+    discnum integer DEFAULT 1 NOT NULL,
+    spotifyidalbum character varying
+);
+
+CREATE TABLE spotify.track_artist (
+    spotifyidtrack character(22) NOT NULL,
+    spotifyidartist character(22) NOT NULL,
+    ismain boolean NOT NULL
+);
+
+ALTER TABLE ONLY auth.auth_token
+    ADD CONSTRAINT auth_token_un UNIQUE (token);
+
+ALTER TABLE ONLY auth.spotify_token
+    ADD CONSTRAINT spotify_token_pk PRIMARY KEY (userid);
+
+ALTER TABLE ONLY auth."user"
+    ADD CONSTRAINT user_email_key UNIQUE (email);
+
+ALTER TABLE ONLY auth."user"
+    ADD CONSTRAINT user_pkey PRIMARY KEY (id);
+
+ALTER TABLE ONLY auth.user_profile_img
+    ADD CONSTRAINT user_profile_img_pk PRIMARY KEY (userid);
+
+ALTER TABLE ONLY auth."user"
+    ADD CONSTRAINT user_pwdhash_key UNIQUE (pwdhash);
+
+ALTER TABLE ONLY auth.user_spotify
+    ADD CONSTRAINT user_spotify_pk PRIMARY KEY (userid, spotify_id);
+
+ALTER TABLE ONLY auth.user_spotify
+    ADD CONSTRAINT user_spotify_unique UNIQUE (userid);
+
+ALTER TABLE ONLY auth.user_spotify
+    ADD CONSTRAINT user_spotify_unique_1 UNIQUE (spotify_id);
+
+ALTER TABLE ONLY auth."user"
+    ADD CONSTRAINT user_username_key UNIQUE (username);
+
+ALTER TABLE ONLY spotify.album_artist
+    ADD CONSTRAINT album_artist_pk PRIMARY KEY (spotifyidartist, spotifyidalbum);
+
+ALTER TABLE ONLY spotify.album_artist
+    ADD CONSTRAINT album_artist_un UNIQUE (spotifyidalbum, ismain, spotifyidartist);
+
+ALTER TABLE ONLY spotify.album
+    ADD CONSTRAINT album_pk PRIMARY KEY (spotifyid);
+
+ALTER TABLE ONLY spotify.artist
+    ADD CONSTRAINT artist_pk PRIMARY KEY (spotifyid);
+
+ALTER TABLE ONLY spotify.images
+    ADD CONSTRAINT images_pk PRIMARY KEY (url, spotifyid, width, height);
+
+ALTER TABLE ONLY spotify.track_artist
+    ADD CONSTRAINT track_artist_pk PRIMARY KEY (spotifyidtrack, spotifyidartist);
+
+ALTER TABLE ONLY spotify.track_artist
+    ADD CONSTRAINT track_artist_un UNIQUE (spotifyidtrack, spotifyidartist, ismain);
+
+ALTER TABLE ONLY spotify.track
+    ADD CONSTRAINT track_pk PRIMARY KEY (spotifyid);
+
+ALTER TABLE ONLY auth.auth_token
+    ADD CONSTRAINT login_session_token_user_fk FOREIGN KEY (userid) REFERENCES auth."user"(id);
+
+ALTER TABLE ONLY auth.spotify_token
+    ADD CONSTRAINT spotify_user_fk FOREIGN KEY (userid) REFERENCES auth."user"(id);
+
+ALTER TABLE ONLY auth.user_profile_img
+    ADD CONSTRAINT user_profile_img_user_fk FOREIGN KEY (userid) REFERENCES auth."user"(id);
+
+ALTER TABLE ONLY auth.user_spotify
+    ADD CONSTRAINT user_spotify_user_fk FOREIGN KEY (userid) REFERENCES auth."user"(id);
+
+ALTER TABLE ONLY public.plays
+    ADD CONSTRAINT plays_user_fk FOREIGN KEY (userid) REFERENCES auth."user"(id);
+
+ALTER TABLE ONLY spotify.album_artist
+    ADD CONSTRAINT album_artist_fk FOREIGN KEY (spotifyidartist) REFERENCES spotify.artist(spotifyid);
+
+ALTER TABLE ONLY spotify.album_artist
+    ADD CONSTRAINT album_artist_fk1 FOREIGN KEY (spotifyidalbum) REFERENCES spotify.album(spotifyid);
+
+ALTER TABLE ONLY spotify.track
+    ADD CONSTRAINT track_album_fk FOREIGN KEY (spotifyidalbum) REFERENCES spotify.album(spotifyid);
+
+ALTER TABLE ONLY spotify.track_artist
+    ADD CONSTRAINT track_artist_fk FOREIGN KEY (spotifyidtrack) REFERENCES spotify.track(spotifyid);
+
+ALTER TABLE ONLY spotify.track_artist
+    ADD CONSTRAINT track_artist_fk_1 FOREIGN KEY (spotifyidartist) REFERENCES spotify.artist(spotifyid);
+
+REVOKE USAGE ON SCHEMA public FROM PUBLIC;
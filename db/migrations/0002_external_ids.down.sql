@@ -0,0 +1 @@
+DROP TABLE spotify.external_id;
@@ -0,0 +1,13 @@
+CREATE TABLE spotify.external_id (
+    resourcetype character varying NOT NULL,
+    spotifyid character(22) NOT NULL,
+    provider character varying NOT NULL,
+    externalid character varying NOT NULL
+);
+
+COMMENT ON TABLE spotify.external_id IS 'Ids that preserved tracks, albums and artists are known by at providers other than Spotify. An empty externalid means the provider was consulted but does not know the resource.';
+
+ALTER TABLE ONLY spotify.external_id
+    ADD CONSTRAINT external_id_pk PRIMARY KEY (resourcetype, spotifyid, provider);
+
+CREATE UNIQUE INDEX external_id_provider_externalid_idx ON spotify.external_id USING btree (resourcetype, provider, externalid) WHERE ((externalid)::text <> ''::text);
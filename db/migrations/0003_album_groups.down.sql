@@ -0,0 +1,3 @@
+DELETE FROM spotify.album_artist WHERE albumgroup = 'appears_on';
+
+ALTER TABLE spotify.album_artist DROP COLUMN albumgroup;
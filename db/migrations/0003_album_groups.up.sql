@@ -0,0 +1,11 @@
+ALTER TABLE spotify.album_artist ADD COLUMN albumgroup character varying;
+
+-- every relation recorded so far is between an album and one of its own artists
+UPDATE spotify.album_artist
+SET albumgroup = album.type
+FROM spotify.album
+WHERE album.spotifyid = album_artist.spotifyidalbum;
+
+ALTER TABLE spotify.album_artist ALTER COLUMN albumgroup SET NOT NULL;
+
+COMMENT ON COLUMN spotify.album_artist.albumgroup IS 'Group of the album in the artist''s discography: album, single or compilation for the album''s own artists, appears_on for artists only credited on some of its tracks.';
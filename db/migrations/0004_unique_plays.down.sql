@@ -0,0 +1 @@
+ALTER TABLE public.plays DROP CONSTRAINT plays_userid_at_key;
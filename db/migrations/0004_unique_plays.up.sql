@@ -0,0 +1,9 @@
+-- keep a single row of every play recorded more than once
+DELETE FROM public.plays AS duplicate
+USING public.plays AS kept
+WHERE duplicate.userid = kept.userid
+    AND duplicate.at = kept.at
+    AND duplicate.ctid > kept.ctid;
+
+ALTER TABLE ONLY public.plays
+    ADD CONSTRAINT plays_userid_at_key UNIQUE (userid, at);
@@ -0,0 +1,10 @@
+DROP INDEX spotify.track_title_trgm_idx;
+DROP INDEX spotify.track_searchtext_trgm_idx;
+DROP INDEX spotify.album_title_trgm_idx;
+DROP INDEX spotify.album_searchtext_trgm_idx;
+DROP INDEX spotify.artist_name_trgm_idx;
+
+ALTER TABLE spotify.track DROP COLUMN searchtext;
+ALTER TABLE spotify.album DROP COLUMN searchtext;
+
+DROP EXTENSION pg_trgm;
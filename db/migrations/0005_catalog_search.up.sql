@@ -0,0 +1,31 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm WITH SCHEMA public;
+
+COMMENT ON EXTENSION pg_trgm IS 'text similarity measurement and index searching based on trigrams';
+
+ALTER TABLE spotify.track ADD COLUMN searchtext character varying NOT NULL DEFAULT '';
+ALTER TABLE spotify.album ADD COLUMN searchtext character varying NOT NULL DEFAULT '';
+
+COMMENT ON COLUMN spotify.track.searchtext IS 'Title of the track followed by the names of its artists, main artist first. Used for searching.';
+COMMENT ON COLUMN spotify.album.searchtext IS 'Title of the album followed by the names of its own artists, main artist first. Used for searching.';
+
+UPDATE spotify.track
+SET searchtext = concat_ws(' ', track.title, (
+    SELECT string_agg(artist.name, ' ' ORDER BY track_artist.ismain DESC, artist.name)
+    FROM spotify.track_artist
+    JOIN spotify.artist ON artist.spotifyid = track_artist.spotifyidartist
+    WHERE track_artist.spotifyidtrack = track.spotifyid
+));
+
+UPDATE spotify.album
+SET searchtext = concat_ws(' ', album.title, (
+    SELECT string_agg(artist.name, ' ' ORDER BY album_artist.ismain DESC, artist.name)
+    FROM spotify.album_artist
+    JOIN spotify.artist ON artist.spotifyid = album_artist.spotifyidartist
+    WHERE album_artist.spotifyidalbum = album.spotifyid AND album_artist.albumgroup <> 'appears_on'
+));
+
+CREATE INDEX track_title_trgm_idx ON spotify.track USING gin (title public.gin_trgm_ops);
+CREATE INDEX track_searchtext_trgm_idx ON spotify.track USING gin (searchtext public.gin_trgm_ops);
+CREATE INDEX album_title_trgm_idx ON spotify.album USING gin (title public.gin_trgm_ops);
+CREATE INDEX album_searchtext_trgm_idx ON spotify.album USING gin (searchtext public.gin_trgm_ops);
+CREATE INDEX artist_name_trgm_idx ON spotify.artist USING gin (name public.gin_trgm_ops);
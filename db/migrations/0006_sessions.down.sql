@@ -0,0 +1,13 @@
+DROP INDEX auth.auth_token_userid_idx;
+
+ALTER TABLE auth.auth_token
+    DROP CONSTRAINT auth_token_pk,
+    DROP COLUMN id,
+    DROP COLUMN expires_at,
+    DROP COLUMN last_seen_at,
+    DROP COLUMN user_agent,
+    DROP COLUMN ip;
+
+ALTER TABLE auth.auth_token ALTER COLUMN granted_at TYPE timestamp without time zone;
+
+COMMENT ON TABLE auth.auth_token IS NULL;
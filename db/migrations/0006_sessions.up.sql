@@ -0,0 +1,23 @@
+ALTER TABLE auth.auth_token ALTER COLUMN granted_at TYPE timestamp with time zone;
+
+ALTER TABLE auth.auth_token
+    ADD COLUMN id uuid DEFAULT public.gen_random_uuid() NOT NULL,
+    ADD COLUMN expires_at timestamp with time zone,
+    ADD COLUMN last_seen_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    ADD COLUMN user_agent character varying DEFAULT '' NOT NULL,
+    ADD COLUMN ip character varying DEFAULT '' NOT NULL;
+
+-- sessions granted so far expire like new ones would have, counting from their grant
+UPDATE auth.auth_token
+SET expires_at = granted_at + interval '90 days', last_seen_at = CURRENT_TIMESTAMP;
+
+ALTER TABLE auth.auth_token ALTER COLUMN expires_at SET NOT NULL;
+
+ALTER TABLE ONLY auth.auth_token
+    ADD CONSTRAINT auth_token_pk PRIMARY KEY (id);
+
+CREATE INDEX auth_token_userid_idx ON auth.auth_token USING btree (userid);
+
+COMMENT ON TABLE auth.auth_token IS 'Login sessions. A session is valid until expires_at, as long as it is used at least once per idle timeout, tracked by last_seen_at.';
+COMMENT ON COLUMN auth.auth_token.user_agent IS 'User agent of the device the session was last used from.';
+COMMENT ON COLUMN auth.auth_token.ip IS 'IP address the session was last used from.';
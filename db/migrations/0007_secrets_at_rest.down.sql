@@ -0,0 +1,16 @@
+-- auth tokens can't be recovered from their hashes, so all sessions are ended
+DELETE FROM auth.auth_token;
+
+ALTER TABLE auth.auth_token
+    DROP CONSTRAINT auth_token_hash_un,
+    DROP COLUMN token_hash,
+    ADD COLUMN token character varying NOT NULL;
+
+ALTER TABLE ONLY auth.auth_token
+    ADD CONSTRAINT auth_token_un UNIQUE (token);
+
+-- encrypted spotify tokens can't be decrypted without the program's keys, so they're dropped and
+-- users have to reconnect their Spotify accounts
+DELETE FROM auth.spotify_token WHERE keyid IS NOT NULL;
+
+ALTER TABLE auth.spotify_token DROP COLUMN keyid;
@@ -0,0 +1,19 @@
+-- only hashes of auth tokens are stored from now on
+ALTER TABLE auth.auth_token ADD COLUMN token_hash bytea;
+
+UPDATE auth.auth_token SET token_hash = public.digest(token, 'sha256');
+
+ALTER TABLE auth.auth_token
+    ALTER COLUMN token_hash SET NOT NULL,
+    DROP CONSTRAINT auth_token_un,
+    DROP COLUMN token;
+
+ALTER TABLE ONLY auth.auth_token
+    ADD CONSTRAINT auth_token_hash_un UNIQUE (token_hash);
+
+COMMENT ON COLUMN auth.auth_token.token_hash IS 'SHA-256 hash of the auth token. The token itself is never stored.';
+
+-- existing spotify tokens are encrypted by the program once it starts with an encryption key
+ALTER TABLE auth.spotify_token ADD COLUMN keyid character varying;
+
+COMMENT ON COLUMN auth.spotify_token.keyid IS 'Id of the key that accesstoken and refreshtoken are encrypted under. Null if they are still stored in plaintext.';
@@ -0,0 +1,16 @@
+CREATE TABLE auth.password_reset (
+    token_hash bytea NOT NULL,
+    userid uuid NOT NULL,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    expires_at timestamp with time zone NOT NULL
+);
+
+COMMENT ON TABLE auth.password_reset IS 'Pending password resets. Rows are deleted once used. Only SHA-256 hashes of reset tokens are stored.';
+
+ALTER TABLE ONLY auth.password_reset
+    ADD CONSTRAINT password_reset_pk PRIMARY KEY (token_hash);
+
+ALTER TABLE ONLY auth.password_reset
+    ADD CONSTRAINT password_reset_user_fk FOREIGN KEY (userid) REFERENCES auth."user"(id) ON DELETE CASCADE;
+
+CREATE INDEX password_reset_userid_idx ON auth.password_reset USING btree (userid);
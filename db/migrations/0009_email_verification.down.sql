@@ -0,0 +1,3 @@
+DROP TABLE auth.email_verification;
+
+ALTER TABLE auth."user" DROP COLUMN email_verified_at;
@@ -0,0 +1,24 @@
+ALTER TABLE auth."user" ADD COLUMN email_verified_at timestamp with time zone;
+
+COMMENT ON COLUMN auth."user".email_verified_at IS 'When the user proved to own the e-mail address. Null if the address is unverified.';
+
+-- accounts registered before verification was introduced keep working as before
+UPDATE auth."user" SET email_verified_at = registered_at;
+
+CREATE TABLE auth.email_verification (
+    token_hash bytea NOT NULL,
+    userid uuid NOT NULL,
+    email public.citext NOT NULL,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    expires_at timestamp with time zone NOT NULL
+);
+
+COMMENT ON TABLE auth.email_verification IS 'Pending verifications of e-mail addresses, either of the address a user signed up with or of a new address the user is changing to. Only SHA-256 hashes of verification tokens are stored.';
+
+ALTER TABLE ONLY auth.email_verification
+    ADD CONSTRAINT email_verification_pk PRIMARY KEY (token_hash);
+
+ALTER TABLE ONLY auth.email_verification
+    ADD CONSTRAINT email_verification_user_fk FOREIGN KEY (userid) REFERENCES auth."user"(id) ON DELETE CASCADE;
+
+CREATE INDEX email_verification_userid_idx ON auth.email_verification USING btree (userid);
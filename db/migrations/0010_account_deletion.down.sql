@@ -0,0 +1,23 @@
+ALTER TABLE auth.auth_token
+    DROP CONSTRAINT login_session_token_user_fk,
+    ADD CONSTRAINT login_session_token_user_fk FOREIGN KEY (userid) REFERENCES auth."user"(id);
+
+ALTER TABLE auth.spotify_token
+    DROP CONSTRAINT spotify_user_fk,
+    ADD CONSTRAINT spotify_user_fk FOREIGN KEY (userid) REFERENCES auth."user"(id);
+
+ALTER TABLE auth.user_profile_img
+    DROP CONSTRAINT user_profile_img_user_fk,
+    ADD CONSTRAINT user_profile_img_user_fk FOREIGN KEY (userid) REFERENCES auth."user"(id);
+
+ALTER TABLE auth.user_spotify
+    DROP CONSTRAINT user_spotify_user_fk,
+    ADD CONSTRAINT user_spotify_user_fk FOREIGN KEY (userid) REFERENCES auth."user"(id);
+
+ALTER TABLE public.plays
+    DROP CONSTRAINT plays_user_fk,
+    ADD CONSTRAINT plays_user_fk FOREIGN KEY (userid) REFERENCES auth."user"(id);
+
+DROP INDEX auth.user_purge_at_idx;
+
+ALTER TABLE auth."user" DROP COLUMN purge_at;
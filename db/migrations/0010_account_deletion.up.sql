@@ -0,0 +1,26 @@
+ALTER TABLE auth."user" ADD COLUMN purge_at timestamp with time zone;
+
+COMMENT ON COLUMN auth."user".purge_at IS 'When the account, which the user requested to be deleted, is purged along with all of its data. Null unless deletion was requested.';
+
+CREATE INDEX user_purge_at_idx ON auth."user" USING btree (purge_at) WHERE (purge_at IS NOT NULL);
+
+-- purging a user deletes all of its data along with it
+ALTER TABLE auth.auth_token
+    DROP CONSTRAINT login_session_token_user_fk,
+    ADD CONSTRAINT login_session_token_user_fk FOREIGN KEY (userid) REFERENCES auth."user"(id) ON DELETE CASCADE;
+
+ALTER TABLE auth.spotify_token
+    DROP CONSTRAINT spotify_user_fk,
+    ADD CONSTRAINT spotify_user_fk FOREIGN KEY (userid) REFERENCES auth."user"(id) ON DELETE CASCADE;
+
+ALTER TABLE auth.user_profile_img
+    DROP CONSTRAINT user_profile_img_user_fk,
+    ADD CONSTRAINT user_profile_img_user_fk FOREIGN KEY (userid) REFERENCES auth."user"(id) ON DELETE CASCADE;
+
+ALTER TABLE auth.user_spotify
+    DROP CONSTRAINT user_spotify_user_fk,
+    ADD CONSTRAINT user_spotify_user_fk FOREIGN KEY (userid) REFERENCES auth."user"(id) ON DELETE CASCADE;
+
+ALTER TABLE public.plays
+    DROP CONSTRAINT plays_user_fk,
+    ADD CONSTRAINT plays_user_fk FOREIGN KEY (userid) REFERENCES auth."user"(id) ON DELETE CASCADE;
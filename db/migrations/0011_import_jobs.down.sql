@@ -0,0 +1,3 @@
+DROP INDEX public.plays_userid_spotifyid_at_idx;
+
+DROP TABLE public.import_job;
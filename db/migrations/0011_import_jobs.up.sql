@@ -0,0 +1,32 @@
+CREATE TABLE public.import_job (
+    id uuid DEFAULT gen_random_uuid() NOT NULL,
+    userid uuid NOT NULL,
+    source character varying NOT NULL,
+    status character varying DEFAULT 'pending'::character varying NOT NULL,
+    total integer DEFAULT 0 NOT NULL,
+    processed integer DEFAULT 0 NOT NULL,
+    imported integer DEFAULT 0 NOT NULL,
+    duplicates integer DEFAULT 0 NOT NULL,
+    skipped integer DEFAULT 0 NOT NULL,
+    error character varying,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    finished_at timestamp with time zone,
+    CONSTRAINT import_job_status_check CHECK (((status)::text = ANY ((ARRAY['pending'::character varying, 'running'::character varying, 'done'::character varying, 'failed'::character varying])::text[])))
+);
+
+COMMENT ON TABLE public.import_job IS 'Background jobs importing plays from external sources, such as the Spotify extended streaming history, along with their progress.';
+COMMENT ON COLUMN public.import_job.total IS 'Number of plays to be imported, known once the job is created.';
+COMMENT ON COLUMN public.import_job.duplicates IS 'Plays not imported as they were already recorded.';
+COMMENT ON COLUMN public.import_job.skipped IS 'Plays not imported as their tracks could not be resolved.';
+
+ALTER TABLE ONLY public.import_job
+    ADD CONSTRAINT import_job_pk PRIMARY KEY (id);
+
+ALTER TABLE ONLY public.import_job
+    ADD CONSTRAINT import_job_user_fk FOREIGN KEY (userid) REFERENCES auth."user"(id) ON DELETE CASCADE;
+
+CREATE INDEX import_job_userid_idx ON public.import_job USING btree (userid, created_at);
+
+-- plays of the same track close to each other are looked up when de-duplicating imported plays
+CREATE INDEX plays_userid_spotifyid_at_idx ON public.plays USING btree (userid, spotifyid, at);
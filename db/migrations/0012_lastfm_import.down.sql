@@ -0,0 +1,3 @@
+DROP TABLE public.import_unmatched;
+
+ALTER TABLE public.plays DROP COLUMN source;
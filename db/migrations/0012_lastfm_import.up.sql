@@ -0,0 +1,18 @@
+ALTER TABLE public.plays ADD COLUMN source character varying DEFAULT 'spotify'::character varying NOT NULL;
+
+COMMENT ON COLUMN public.plays.source IS 'Where the play was recorded from: spotify (aggregated from recently played tracks), spotify_history (imported extended streaming history) or lastfm (imported scrobbles).';
+
+CREATE TABLE public.import_unmatched (
+    jobid uuid NOT NULL,
+    at timestamp with time zone NOT NULL,
+    artist character varying NOT NULL,
+    album character varying NOT NULL,
+    title character varying NOT NULL
+);
+
+COMMENT ON TABLE public.import_unmatched IS 'Imported plays that could not be matched to a track, reported back to the user that imported them.';
+
+ALTER TABLE ONLY public.import_unmatched
+    ADD CONSTRAINT import_unmatched_job_fk FOREIGN KEY (jobid) REFERENCES public.import_job(id) ON DELETE CASCADE;
+
+CREATE INDEX import_unmatched_jobid_idx ON public.import_unmatched USING btree (jobid, at);
@@ -0,0 +1 @@
+DROP TABLE auth.user_lastfm;
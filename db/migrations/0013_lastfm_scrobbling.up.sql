@@ -0,0 +1,17 @@
+CREATE TABLE auth.user_lastfm (
+    userid uuid NOT NULL,
+    username character varying NOT NULL,
+    session_key character varying NOT NULL,
+    keyid character varying NOT NULL,
+    linked_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    scrobbled_until timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+COMMENT ON TABLE auth.user_lastfm IS 'Last.fm accounts linked for scrobbling. Session keys are encrypted like Spotify tokens, under the key identified by keyid.';
+COMMENT ON COLUMN auth.user_lastfm.scrobbled_until IS 'Time of the most recent play submitted to Last.fm. Plays after it are yet to be scrobbled.';
+
+ALTER TABLE ONLY auth.user_lastfm
+    ADD CONSTRAINT user_lastfm_pk PRIMARY KEY (userid);
+
+ALTER TABLE ONLY auth.user_lastfm
+    ADD CONSTRAINT user_lastfm_user_fk FOREIGN KEY (userid) REFERENCES auth."user"(id) ON DELETE CASCADE;
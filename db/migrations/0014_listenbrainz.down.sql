@@ -0,0 +1,3 @@
+COMMENT ON COLUMN public.plays.source IS 'Where the play was recorded from: spotify (aggregated from recently played tracks), spotify_history (imported extended streaming history) or lastfm (imported scrobbles).';
+
+DROP TABLE auth.user_listenbrainz;
@@ -0,0 +1,19 @@
+CREATE TABLE auth.user_listenbrainz (
+    userid uuid NOT NULL,
+    username character varying NOT NULL,
+    token character varying NOT NULL,
+    keyid character varying NOT NULL,
+    linked_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    submitted_until timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+COMMENT ON TABLE auth.user_listenbrainz IS 'ListenBrainz accounts linked for submitting listens. User tokens are encrypted like Spotify tokens, under the key identified by keyid.';
+COMMENT ON COLUMN auth.user_listenbrainz.submitted_until IS 'Time of the most recent play submitted to ListenBrainz. Plays after it are yet to be submitted.';
+
+ALTER TABLE ONLY auth.user_listenbrainz
+    ADD CONSTRAINT user_listenbrainz_pk PRIMARY KEY (userid);
+
+ALTER TABLE ONLY auth.user_listenbrainz
+    ADD CONSTRAINT user_listenbrainz_user_fk FOREIGN KEY (userid) REFERENCES auth."user"(id) ON DELETE CASCADE;
+
+COMMENT ON COLUMN public.plays.source IS 'Where the play was recorded from: spotify (aggregated from recently played tracks), spotify_history (imported extended streaming history), lastfm (imported scrobbles) or listenbrainz (imported listens).';
@@ -0,0 +1,5 @@
+COMMENT ON COLUMN public.plays.source IS 'Where the play was recorded from: spotify (aggregated from recently played tracks), spotify_history (imported extended streaming history), lastfm (imported scrobbles) or listenbrainz (imported listens).';
+
+DROP TABLE public.ingest_unmatched;
+
+DROP TABLE auth.ingest_token;
@@ -0,0 +1,35 @@
+CREATE TABLE auth.ingest_token (
+    userid uuid NOT NULL,
+    token_hash bytea NOT NULL,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    last_used_at timestamp with time zone
+);
+
+COMMENT ON TABLE auth.ingest_token IS 'Tokens authenticating submissions of plays from other players through the ingest API. Only SHA-256 hashes of the tokens are stored.';
+
+ALTER TABLE ONLY auth.ingest_token
+    ADD CONSTRAINT ingest_token_pk PRIMARY KEY (userid);
+
+ALTER TABLE ONLY auth.ingest_token
+    ADD CONSTRAINT ingest_token_hash_key UNIQUE (token_hash);
+
+ALTER TABLE ONLY auth.ingest_token
+    ADD CONSTRAINT ingest_token_user_fk FOREIGN KEY (userid) REFERENCES auth."user"(id) ON DELETE CASCADE;
+
+CREATE TABLE public.ingest_unmatched (
+    userid uuid NOT NULL,
+    at timestamp with time zone NOT NULL,
+    source character varying NOT NULL,
+    artist character varying NOT NULL,
+    album character varying NOT NULL,
+    title character varying NOT NULL
+);
+
+COMMENT ON TABLE public.ingest_unmatched IS 'Plays submitted through the ingest API that could not be matched to a track.';
+
+ALTER TABLE ONLY public.ingest_unmatched
+    ADD CONSTRAINT ingest_unmatched_user_fk FOREIGN KEY (userid) REFERENCES auth."user"(id) ON DELETE CASCADE;
+
+CREATE INDEX ingest_unmatched_userid_at_idx ON public.ingest_unmatched USING btree (userid, at);
+
+COMMENT ON COLUMN public.plays.source IS 'Where the play was recorded from: spotify (aggregated from recently played tracks), spotify_history (imported extended streaming history), lastfm (imported scrobbles), listenbrainz (imported listens), or ingest_listenbrainz and ingest_audioscrobbler (submitted by other players through the ingest API).';
@@ -0,0 +1,5 @@
+DROP INDEX public.plays_reviewid_idx;
+
+ALTER TABLE public.plays DROP COLUMN reviewid;
+
+DROP TABLE public.match_review;
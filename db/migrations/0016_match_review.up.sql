@@ -0,0 +1,37 @@
+CREATE TABLE public.match_review (
+    id uuid DEFAULT gen_random_uuid() NOT NULL,
+    userid uuid NOT NULL,
+    artist character varying NOT NULL,
+    album character varying NOT NULL,
+    title character varying NOT NULL,
+    spotifyid character varying NOT NULL,
+    score real NOT NULL,
+    candidates jsonb NOT NULL,
+    status character varying DEFAULT 'pending'::character varying NOT NULL,
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    reviewed_at timestamp with time zone,
+    CONSTRAINT match_review_status_check CHECK (((status)::text = ANY ((ARRAY['pending'::character varying, 'confirmed'::character varying, 'corrected'::character varying])::text[])))
+);
+
+COMMENT ON TABLE public.match_review IS 'Low-confidence matches of imported or submitted plays to tracks, awaiting confirmation or correction by the user. Reviewed matches are kept and reused for later plays with the same metadata.';
+COMMENT ON COLUMN public.match_review.spotifyid IS 'Track that the plays are recorded as: the best candidate until the match is corrected.';
+COMMENT ON COLUMN public.match_review.candidates IS 'Candidate tracks that were considered, best first, along with their scores.';
+
+ALTER TABLE ONLY public.match_review
+    ADD CONSTRAINT match_review_pk PRIMARY KEY (id);
+
+ALTER TABLE ONLY public.match_review
+    ADD CONSTRAINT match_review_user_fk FOREIGN KEY (userid) REFERENCES auth."user"(id) ON DELETE CASCADE;
+
+CREATE UNIQUE INDEX match_review_userid_metadata_key ON public.match_review USING btree (userid, lower((artist)::text), lower((album)::text), lower((title)::text));
+
+CREATE INDEX match_review_userid_status_idx ON public.match_review USING btree (userid, status, created_at);
+
+ALTER TABLE public.plays ADD COLUMN reviewid uuid;
+
+COMMENT ON COLUMN public.plays.reviewid IS 'Review of the low-confidence match that the play was recorded by, if any. Correcting the match re-points the play.';
+
+ALTER TABLE ONLY public.plays
+    ADD CONSTRAINT plays_review_fk FOREIGN KEY (reviewid) REFERENCES public.match_review(id) ON DELETE SET NULL;
+
+CREATE INDEX plays_reviewid_idx ON public.plays USING btree (reviewid) WHERE (reviewid IS NOT NULL);
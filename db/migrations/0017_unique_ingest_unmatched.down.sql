@@ -0,0 +1,3 @@
+ALTER TABLE public.ingest_unmatched DROP CONSTRAINT ingest_unmatched_userid_at_key;
+
+CREATE INDEX ingest_unmatched_userid_at_idx ON public.ingest_unmatched USING btree (userid, at);
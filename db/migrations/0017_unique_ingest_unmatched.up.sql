@@ -0,0 +1,11 @@
+-- keep a single row of every unmatched play submitted more than once
+DELETE FROM public.ingest_unmatched AS duplicate
+USING public.ingest_unmatched AS kept
+WHERE duplicate.userid = kept.userid
+    AND duplicate.at = kept.at
+    AND duplicate.ctid > kept.ctid;
+
+DROP INDEX public.ingest_unmatched_userid_at_idx;
+
+ALTER TABLE ONLY public.ingest_unmatched
+    ADD CONSTRAINT ingest_unmatched_userid_at_key UNIQUE (userid, at);
@@ -0,0 +1,369 @@
+// The migrations package manages the database schema through numbered migrations embedded into
+// the program. Each migration consists of two files, NNNN_name.up.sql and NNNN_name.down.sql,
+// that respectively apply and revert it. Applied migrations are recorded in public.schema_version.
+//
+// Migrations are applied while holding a session-level advisory lock, so that multiple instances
+// of the program starting up at once never apply the same migration twice.
+package migrations
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//go:embed *.sql
+var migrationFiles embed.FS
+
+// arbitrary, but fixed key of the advisory lock held while migrating
+const advisoryLockKey = 0x6d64736d
+
+var (
+	ErrUnknownVersion = errors.New("database schema has a version unknown to this program")
+	ErrInvalidTarget  = errors.New("invalid target schema version")
+)
+
+var migrationFileRegex = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version  int
+	Name     string
+	Up, Down string
+}
+
+// The state of a single migration in a particular database.
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Probes detecting the version of databases created from the schema dumps that preceded
+// migrations, newest first. Such databases are baselined at the detected version instead of
+// having the migrations up to it applied.
+var baselineProbes = []struct {
+	version int
+	query   string
+}{
+	{3, `select exists (select 1 from information_schema.columns where table_schema='spotify' and table_name='album_artist' and column_name='albumgroup')`},
+	{2, `select to_regclass('spotify.external_id') is not null`},
+	{1, `select to_regclass('auth.user') is not null`},
+}
+
+// Return all embedded migrations, ordered by version. Versions must start at 1 and be consecutive,
+// and every migration must have both an up and a down file.
+func Load() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+
+	for _, entry := range entries {
+		match := migrationFileRegex.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name {%s}", entry.Name())
+		}
+
+		version, _ := strconv.Atoi(match[1])
+
+		contents, err := migrationFiles.ReadFile(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has conflicting names {%s} and {%s}", version, migration.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			migration.Up = string(contents)
+		} else {
+			migration.Down = string(contents)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for version := 1; version <= len(byVersion); version++ {
+		migration, ok := byVersion[version]
+		if !ok {
+			return nil, fmt.Errorf("migration versions are not consecutive, %d is missing", version)
+		}
+
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d (%s) lacks an up or a down file", version, migration.Name)
+		}
+
+		migrations = append(migrations, *migration)
+	}
+
+	return migrations, nil
+}
+
+// Return the version of the newest embedded migration.
+func Latest() (int, error) {
+	migrations, err := Load()
+	if err != nil {
+		return 0, err
+	}
+
+	return len(migrations), nil
+}
+
+// Acquire a dedicated connection holding the migration advisory lock, make sure that the version
+// table exists and call fn with the connection. The lock is released once fn returns.
+func withLock(ctx context.Context, pool *pgxpool.Pool, fn func(conn *pgxpool.Conn) error) error {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "select pg_advisory_lock($1)", advisoryLockKey); err != nil {
+		return fmt.Errorf("acquiring migration lock: %w", err)
+	}
+
+	defer func() {
+		if _, err := conn.Exec(context.Background(), "select pg_advisory_unlock($1)", advisoryLockKey); err != nil {
+			log.Printf("migrations: error releasing migration lock: %v\n", err)
+		}
+	}()
+
+	_, err = conn.Exec(
+		ctx,
+		`
+			create table if not exists public.schema_version (
+				version integer primary key,
+				name character varying not null,
+				appliedat timestamp with time zone default current_timestamp not null
+			)
+		`,
+	)
+
+	if err != nil {
+		return fmt.Errorf("creating schema_version table: %w", err)
+	}
+
+	return fn(conn)
+}
+
+// Return the applied migration versions along with the time they were applied at.
+func appliedVersions(ctx context.Context, conn *pgxpool.Conn) (map[int]time.Time, error) {
+	rows, err := conn.Query(ctx, "select version, appliedat from public.schema_version")
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]time.Time)
+
+	var version int
+	var appliedAt time.Time
+	_, err = pgx.ForEachRow(rows, []any{&version, &appliedAt}, func() error {
+		applied[version] = appliedAt
+		return nil
+	})
+
+	return applied, err
+}
+
+// Record the version of a database created from one of the pre-migration schema dumps.
+func baseline(ctx context.Context, conn *pgxpool.Conn, migrations []Migration) error {
+	for _, probe := range baselineProbes {
+		var matches bool
+		if err := conn.QueryRow(ctx, probe.query).Scan(&matches); err != nil {
+			return err
+		}
+
+		if !matches {
+			continue
+		}
+
+		log.Printf("migrations: existing schema detected, baselining at version %d\n", probe.version)
+
+		for _, migration := range migrations[:probe.version] {
+			_, err := conn.Exec(
+				ctx,
+				"insert into public.schema_version (version, name) values ($1, $2)",
+				migration.Version,
+				migration.Name,
+			)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	return nil
+}
+
+// Apply a single migration, up or down, in a transaction along with updating the version table.
+func apply(ctx context.Context, conn *pgxpool.Conn, migration Migration, up bool) error {
+	return pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		script, record := migration.Down, "delete from public.schema_version where version=$1"
+		if up {
+			script, record = migration.Up, "insert into public.schema_version (version, name) values ($1, $2)"
+		}
+
+		// migrations are multi-statement scripts, which only the simple protocol allows
+		if _, err := tx.Exec(ctx, script, pgx.QueryExecModeSimpleProtocol); err != nil {
+			return err
+		}
+
+		args := []any{migration.Version}
+		if up {
+			args = append(args, migration.Name)
+		}
+
+		_, err := tx.Exec(ctx, record, args...)
+		return err
+	})
+}
+
+// Apply all migrations that haven't been applied yet, up to the latest one.
+func Up(ctx context.Context, pool *pgxpool.Pool) error {
+	latest, err := Latest()
+	if err != nil {
+		return err
+	}
+
+	return UpTo(ctx, pool, latest)
+}
+
+// Apply all migrations that haven't been applied yet, up to and including target.
+func UpTo(ctx context.Context, pool *pgxpool.Pool, target int) error {
+	migrations, err := Load()
+	if err != nil {
+		return err
+	}
+
+	if target < 0 || target > len(migrations) {
+		return ErrInvalidTarget
+	}
+
+	return withLock(ctx, pool, func(conn *pgxpool.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		if len(applied) == 0 {
+			if err := baseline(ctx, conn, migrations); err != nil {
+				return fmt.Errorf("baselining existing schema: %w", err)
+			}
+
+			if applied, err = appliedVersions(ctx, conn); err != nil {
+				return err
+			}
+		}
+
+		for version := range applied {
+			if version > len(migrations) {
+				return ErrUnknownVersion
+			}
+		}
+
+		for _, migration := range migrations[:target] {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+
+			log.Printf("migrations: applying %04d_%s\n", migration.Version, migration.Name)
+			if err := apply(ctx, conn, migration, true); err != nil {
+				return fmt.Errorf("applying migration %d (%s): %w", migration.Version, migration.Name, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// Revert the last steps applied migrations, newest first.
+func Down(ctx context.Context, pool *pgxpool.Pool, steps int) error {
+	migrations, err := Load()
+	if err != nil {
+		return err
+	}
+
+	return withLock(ctx, pool, func(conn *pgxpool.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		versions := make([]int, 0, len(applied))
+		for version := range applied {
+			if version > len(migrations) {
+				return ErrUnknownVersion
+			}
+
+			versions = append(versions, version)
+		}
+
+		slices.Sort(versions)
+		slices.Reverse(versions)
+
+		for _, version := range versions[:min(steps, len(versions))] {
+			migration := migrations[version-1]
+
+			log.Printf("migrations: reverting %04d_%s\n", migration.Version, migration.Name)
+			if err := apply(ctx, conn, migration, false); err != nil {
+				return fmt.Errorf("reverting migration %d (%s): %w", migration.Version, migration.Name, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// Return the state of every embedded migration in the database.
+func GetStatus(ctx context.Context, pool *pgxpool.Pool) ([]Status, error) {
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, len(migrations))
+
+	err = withLock(ctx, pool, func(conn *pgxpool.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for idx, migration := range migrations {
+			appliedAt, ok := applied[migration.Version]
+			statuses[idx] = Status{Migration: migration, Applied: ok, AppliedAt: appliedAt}
+		}
+
+		for version := range applied {
+			if version > len(migrations) {
+				return ErrUnknownVersion
+			}
+		}
+
+		return nil
+	})
+
+	return statuses, err
+}
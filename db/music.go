@@ -4,7 +4,6 @@ import (
 	music "bool3max/musicdash/music"
 	"context"
 	"errors"
-	"time"
 
 	"github.com/jackc/pgx/v5"
 )
@@ -13,231 +12,84 @@ var (
 	ErrResourceNotPreserved = errors.New("resource not found in database")
 )
 
-// return a new *pgxpool.Pool connected to the local database
-
 func (db *Db) GetTrackById(trackId string) (*music.Track, error) {
-	track := new(music.Track)
-	track.SpotifyId = trackId
-
-	// id of belonging album
-	var albumId string
-
-	// track duration in milliseconds from database
-	var trackDuration uint
-
-	// query the base info about the track from the database
-	row := db.pool.QueryRow(
-		context.TODO(),
-		`
-			select title, duration, tracklistnum, discnum, popularity, spotifyuri, explicit, isrc, spotifyidalbum
-			from spotify.track		
-			where spotifyid=$1
-		`,
-		trackId,
-	)
-
-	err := row.Scan(
-		&track.Title,
-		&trackDuration,
-		&track.TracklistNum,
-		&track.DiscNum,
-		&track.SpotifyPopularity,
-		&track.SpotifyURI,
-		&track.IsExplicit,
-		&track.Isrc,
-		&albumId,
-	)
-
-	if err != nil {
-		if err == pgx.ErrNoRows {
-			return nil, ErrResourceNotPreserved
-		}
-		return nil, err
-	}
-
-	// convert millisecond uint duration to time.Duration
-	track.Duration = time.Duration(trackDuration * 1e6)
-
-	belongingAlbum, err := db.GetAlbumById(albumId)
+	tracks, err := db.GetSeveralTracksById([]string{trackId})
 	if err != nil {
 		return nil, err
 	}
 
-	track.Album = *belongingAlbum
-
-	// spotify ids of all artists on the track, main artist first
-	sqlQueryTrackArtists := `
-		select spotifyidartist
-		from spotify.track
-			inner join spotify.track_artist on (spotify.track.spotifyid=spotify.track_artist.spotifyidtrack)
-		where spotifyid=$1
-		order by spotify.track_artist.ismain desc
-	`
-
-	rows, err := db.pool.Query(
-		context.TODO(),
-		sqlQueryTrackArtists,
-		trackId,
-	)
-
-	if err != nil {
-		return nil, err
-	}
+	return &tracks[0], nil
+}
 
-	artistIds, err := pgx.CollectRows[string](rows, pgx.RowTo)
+// Get several preserved tracks in the order of ids. Returns ErrResourceNotPreserved
+// if any of the tracks isn't preserved.
+func (db *Db) GetSeveralTracksById(ids []string) ([]music.Track, error) {
+	loaded, err := db.loadTracks(context.TODO(), ids)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, artistId := range artistIds {
-		artist, err := db.GetArtistById(artistId, 0, nil)
-		if err != nil {
-			return nil, err
-		}
-
-		track.Artists = append(track.Artists, *artist)
-	}
-
-	return track, nil
-}
-
-func (db *Db) GetSeveralTracksById(ids []string) ([]music.Track, error) {
 	tracks := make([]music.Track, len(ids))
 	for idx, trackId := range ids {
-		track, err := db.GetTrackById(trackId)
-		if err != nil {
-			return []music.Track{}, err
+		track, ok := loaded[trackId]
+		if !ok {
+			return []music.Track{}, ErrResourceNotPreserved
 		}
-		tracks[idx] = *track
+
+		tracks[idx] = track
 	}
 
 	return tracks, nil
 }
 
 func (db *Db) GetAlbumById(albumId string) (*music.Album, error) {
-	album := new(music.Album)
-	album.SpotifyId = albumId
-
-	sqlQueryBaseInfo := `
-		select title, counttracks, releasedate, spotifyuri, type, upc
-		from spotify.album	
-		where spotifyid=$1
-	`
-
-	row := db.pool.QueryRow(
-		context.TODO(),
-		sqlQueryBaseInfo,
-		albumId,
-	)
-
-	var albumType string
-	err := row.Scan(
-		&album.Title,
-		&album.CountTracks,
-		&album.ReleaseDate,
-		&album.SpotifyURI,
-		&albumType,
-		&album.Upc,
-	)
-
+	albums, err := db.GetSeveralAlbumsById([]string{albumId})
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			return nil, ErrResourceNotPreserved
-		}
-
 		return nil, err
 	}
 
-	switch albumType {
-	case "album":
-		album.Type = music.AlbumRegular
-	case "compilation":
-		album.Type = music.AlbumCompilation
-	case "single":
-		album.Type = music.AlbumSingle
-	}
-
-	// spotify ids of all album artists, main artist first
-	sqlQueryAlbumArtists := `
-		select spotifyidartist 
-		from spotify.album_artist
-		where spotifyidalbum=$1
-		order by ismain desc
-	`
-
-	rows, err := db.pool.Query(
-		context.TODO(),
-		sqlQueryAlbumArtists,
-		albumId,
-	)
-
-	if err != nil {
-		return nil, err
-	}
+	return &albums[0], nil
+}
 
-	artistIds, err := pgx.CollectRows(rows, pgx.RowTo[string])
+// Get several preserved albums in the order of ids. Returns ErrResourceNotPreserved
+// if any of the albums isn't preserved.
+func (db *Db) GetSeveralAlbumsById(ids []string) ([]music.Album, error) {
+	loaded, err := db.loadAlbums(context.TODO(), ids)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, artistId := range artistIds {
-		artist, err := db.GetArtistById(artistId, 0, nil)
-		if err != nil {
-			return nil, err
-		}
-
-		album.Artists = append(album.Artists, *artist)
-	}
-
-	return album, nil
-}
-
-func (db *Db) GetSeveralAlbumsById(ids []string) ([]music.Album, error) {
 	albums := make([]music.Album, len(ids))
 	for idx, albumId := range ids {
-		track, err := db.GetAlbumById(albumId)
-		if err != nil {
-			return []music.Album{}, err
+		album, ok := loaded[albumId]
+		if !ok {
+			return []music.Album{}, ErrResourceNotPreserved
 		}
-		albums[idx] = *track
+
+		albums[idx] = album
 	}
 
 	return albums, nil
 }
 
 func (db *Db) GetArtistById(artistId string, discogFillLevel int, albumTypes []music.AlbumType) (*music.Artist, error) {
-	artist := new(music.Artist)
-	artist.SpotifyId = artistId
-	sqlQueryBaseInfo := `
-		select name, spotifyuri, followers
-		from spotify.artist
-		where spotifyid=$1
-	`
-
-	row := db.pool.QueryRow(
-		context.TODO(),
-		sqlQueryBaseInfo,
-		artistId,
-	)
-
-	err := row.Scan(
-		&artist.Name,
-		&artist.SpotifyURI,
-		&artist.SpotifyFollowerCount,
-	)
-
+	artists, err := db.loadArtists(context.TODO(), []string{artistId})
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			return nil, ErrResourceNotPreserved
-		}
 		return nil, err
 	}
 
+	artist, ok := artists[artistId]
+	if !ok {
+		return nil, ErrResourceNotPreserved
+	}
+
 	if discogFillLevel > 0 {
-		artist.FillDiscography(db, albumTypes, discogFillLevel > 1)
+		if err := artist.FillDiscography(db, albumTypes, discogFillLevel > 1); err != nil {
+			return nil, err
+		}
 	}
 
-	return artist, nil
+	return &artist, nil
 }
 
 func (db *Db) GetArtistDiscography(artist *music.Artist, includeGroups []music.AlbumType) ([]music.Album, error) {
@@ -245,13 +97,12 @@ func (db *Db) GetArtistDiscography(artist *music.Artist, includeGroups []music.A
 		includeGroups = []music.AlbumType{music.AlbumRegular}
 	}
 
-	discog := make([]music.Album, 0)
-
+	// the group of an album is recorded per artist, so that the same album is e.g. in the
+	// "album" group of its artists and in the "appears_on" group of a featured artist
 	sqlQueryDiscog := `
-		select spotifyidalbum
+		select spotifyidalbum, albumgroup
 		from spotify.album_artist
-			inner join spotify.album on (spotify.album_artist.spotifyidalbum=spotify.album.spotifyid)	
-		where spotifyidartist=$1 and ismain is true and type=any($2)
+		where spotifyidartist=$1 and albumgroup=any($2)
 	`
 
 	rows, err := db.pool.Query(
@@ -265,88 +116,73 @@ func (db *Db) GetArtistDiscography(artist *music.Artist, includeGroups []music.A
 		return nil, err
 	}
 
-	albumIds, err := pgx.CollectRows[string](rows, pgx.RowTo)
+	groupOf := make(map[string]music.AlbumType)
+	albumIds := make([]string, 0)
+
+	var albumId string
+	var group music.AlbumType
+	_, err = pgx.ForEachRow(rows, []any{&albumId, &group}, func() error {
+		groupOf[albumId] = group
+		albumIds = append(albumIds, albumId)
+		return nil
+	})
+
 	if err != nil {
 		return nil, err
 	}
 
-	for _, albumId := range albumIds {
-		album, err := db.GetAlbumById(albumId)
-		if err != nil {
-			return nil, err
-		}
+	albums, err := db.loadAlbums(context.TODO(), albumIds)
+	if err != nil {
+		return nil, err
+	}
 
-		discog = append(discog, *album)
+	discog := make([]music.Album, 0, len(albums))
+	for albumId, album := range albums {
+		album.Group = groupOf[albumId]
+		discog = append(discog, album)
 	}
 
+	music.SortDiscography(discog)
+
 	return discog, nil
 }
 
+// Return all preserved tracks of the album, ordered by disc and tracklist number.
 func (db *Db) GetAlbumTracklist(album *music.Album) ([]music.Track, error) {
-	tracklist := make([]music.Track, album.CountTracks)
-
 	// IDs of all tracks on the album
 	sqlQueryTracks := `
 		select spotifyid
 		from spotify.track
 		where spotifyidalbum=$1
-		limit $2
+		order by discnum, tracklistnum
 	`
 
 	rows, err := db.pool.Query(
 		context.TODO(),
 		sqlQueryTracks,
 		album.SpotifyId,
-		album.CountTracks,
 	)
 
 	if err != nil {
 		return nil, err
 	}
 
-	trackIds, err := pgx.CollectRows[string](rows, pgx.RowTo)
+	trackIds, err := pgx.CollectRows(rows, pgx.RowTo[string])
 	if err != nil {
 		return nil, err
 	}
 
-	for trackIdx, trackId := range trackIds {
-		track, err := db.GetTrackById(trackId)
-		if err != nil {
-			return nil, err
-		}
-
-		tracklist[trackIdx] = *track
-	}
-
-	return tracklist, nil
+	return db.GetSeveralTracksById(trackIds)
 }
 
 // Get<Resource>ByMatch methods of the "db" resource provider search exclusively
-// based on the name of the resource being searched for, whereas the same methods
+// the preserved catalog (see Db.Search()) and return the most similar resource, or
+// ErrResourceNotPreserved if none is similar enough, whereas the same methods
 // of the Spotify provider search the entire spotify catalog (using its /search endpoint)
 // and return the first result of the requested type
 func (db *Db) GetAlbumByMatch(iden string) (*music.Album, error) {
-	sqlQuerySearch := `
-		select spotifyid
-		from spotify.album
-		order by levenshtein($1, title)
-		limit 1
-	`
-
-	row := db.pool.QueryRow(
-		context.TODO(),
-		sqlQuerySearch,
-		iden,
-	)
-
-	var spotifyId string
-	err := row.Scan(&spotifyId)
-
+	spotifyId, err := db.searchBest(context.TODO(), iden, music.ResourceAlbum)
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			return nil, ErrResourceNotPreserved
-		}
-
 		return nil, err
 	}
 
@@ -354,26 +190,8 @@ func (db *Db) GetAlbumByMatch(iden string) (*music.Album, error) {
 }
 
 func (db *Db) GetArtistByMatch(iden string, discogFillLevel int, albumTypes []music.AlbumType) (*music.Artist, error) {
-	sqlQuerySearch := `
-		select spotifyid
-		from spotify.artist
-		order by levenshtein($1, name)
-		limit 1
-	`
-
-	row := db.pool.QueryRow(
-		context.TODO(),
-		sqlQuerySearch,
-		iden,
-	)
-
-	var spotifyId string
-	err := row.Scan(&spotifyId)
-
+	spotifyId, err := db.searchBest(context.TODO(), iden, music.ResourceArtist)
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			return nil, ErrResourceNotPreserved
-		}
 		return nil, err
 	}
 
@@ -381,27 +199,8 @@ func (db *Db) GetArtistByMatch(iden string, discogFillLevel int, albumTypes []mu
 }
 
 func (db *Db) GetTrackByMatch(iden string) (*music.Track, error) {
-	sqlQuerySearch := `
-		select spotifyid
-		from spotify.track
-		order by levenshtein($1, title)
-		limit 1
-	`
-
-	row := db.pool.QueryRow(
-		context.TODO(),
-		sqlQuerySearch,
-		iden,
-	)
-
-	var spotifyId string
-	err := row.Scan(&spotifyId)
-
+	spotifyId, err := db.searchBest(context.TODO(), iden, music.ResourceTrack)
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			return nil, ErrResourceNotPreserved
-		}
-
 		return nil, err
 	}
 
@@ -0,0 +1,145 @@
+package db
+
+import (
+	music "bool3max/musicdash/music"
+	"bool3max/musicdash/musicbrainz"
+	"context"
+	"log"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Attach MusicBrainz ids to at most "limit" preserved tracks and albums that don't have one yet,
+// along with their artists. Tracks are looked up by their ISRC and albums by their UPC/EAN, so
+// resources without those are never enriched. Resources that MusicBrainz doesn't know about get
+// an empty MusicBrainz id, so they aren't looked up again on subsequent calls. Returns the number of tracks
+// and albums that were successfully enriched.
+func (db *Db) AttachMusicBrainzIds(ctx context.Context, mb *musicbrainz.Client, limit int) (int, error) {
+	enriched := 0
+
+	rows, err := db.pool.Query(
+		ctx,
+		`
+			select spotifyid
+			from spotify.track
+			where coalesce(isrc, '') <> '' and not exists (
+				select 1 from spotify.external_id
+				where resourcetype='track' and external_id.spotifyid=track.spotifyid and provider='musicbrainz'
+			)
+			limit $1
+		`,
+		limit,
+	)
+
+	if err != nil {
+		return enriched, err
+	}
+
+	trackIds, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return enriched, err
+	}
+
+	tracks, err := db.GetSeveralTracksById(trackIds)
+	if err != nil {
+		return enriched, err
+	}
+
+	for idx, trackId := range trackIds {
+		track := &tracks[idx]
+
+		if err := mb.EnrichTrack(track); err != nil {
+			if err == musicbrainz.ErrNotFound {
+				if err := db.setExternalId(ctx, music.ResourceTrack, trackId, music.ProviderMusicBrainz, ""); err != nil {
+					return enriched, err
+				}
+
+				continue
+			}
+
+			log.Printf("AttachMusicBrainzIds: error enriching track {%s}: %v\n", trackId, err)
+			return enriched, err
+		}
+
+		if err := db.setExternalId(ctx, music.ResourceTrack, track.SpotifyId, music.ProviderMusicBrainz, track.ExternalIds.Get(music.ProviderMusicBrainz)); err != nil {
+			return enriched, err
+		}
+
+		for _, artist := range track.Artists {
+			artistMbid := artist.ExternalIds.Get(music.ProviderMusicBrainz)
+			if artistMbid == "" {
+				continue
+			}
+
+			if err := db.setExternalId(ctx, music.ResourceArtist, artist.SpotifyId, music.ProviderMusicBrainz, artistMbid); err != nil {
+				return enriched, err
+			}
+		}
+
+		enriched += 1
+	}
+
+	rows, err = db.pool.Query(
+		ctx,
+		`
+			select spotifyid
+			from spotify.album
+			where (coalesce(upc, '') <> '' or coalesce(ean, '') <> '') and not exists (
+				select 1 from spotify.external_id
+				where resourcetype='album' and external_id.spotifyid=album.spotifyid and provider='musicbrainz'
+			)
+			limit $1
+		`,
+		limit,
+	)
+
+	if err != nil {
+		return enriched, err
+	}
+
+	albumIds, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return enriched, err
+	}
+
+	albums, err := db.GetSeveralAlbumsById(albumIds)
+	if err != nil {
+		return enriched, err
+	}
+
+	for idx, albumId := range albumIds {
+		album := &albums[idx]
+
+		if err := mb.EnrichAlbum(album); err != nil {
+			if err == musicbrainz.ErrNotFound {
+				if err := db.setExternalId(ctx, music.ResourceAlbum, albumId, music.ProviderMusicBrainz, ""); err != nil {
+					return enriched, err
+				}
+
+				continue
+			}
+
+			log.Printf("AttachMusicBrainzIds: error enriching album {%s}: %v\n", albumId, err)
+			return enriched, err
+		}
+
+		if err := db.setExternalId(ctx, music.ResourceAlbum, album.SpotifyId, music.ProviderMusicBrainz, album.ExternalIds.Get(music.ProviderMusicBrainz)); err != nil {
+			return enriched, err
+		}
+
+		for _, artist := range album.Artists {
+			artistMbid := artist.ExternalIds.Get(music.ProviderMusicBrainz)
+			if artistMbid == "" {
+				continue
+			}
+
+			if err := db.setExternalId(ctx, music.ResourceArtist, artist.SpotifyId, music.ProviderMusicBrainz, artistMbid); err != nil {
+				return enriched, err
+			}
+		}
+
+		enriched += 1
+	}
+
+	return enriched, nil
+}
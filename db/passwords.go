@@ -0,0 +1,276 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// how long a password reset token can be used for after it's issued
+const PasswordResetLifetime = time.Hour
+
+var ErrNoPasswordSet = errors.New("user has no password set")
+var ErrInvalidResetToken = errors.New("invalid or expired password reset token")
+
+// Hash a user's password. The password to be hashed is salted by prepending to it the unique user id (uuidv4).
+func hashPassword(userId uuid.UUID, password string) ([]byte, error) {
+	pwdToHash := make([]byte, 0, len(userId)+len(password))
+	pwdToHash = append(pwdToHash, userId[:]...)
+	pwdToHash = append(pwdToHash, password...)
+
+	return bcrypt.GenerateFromPassword(pwdToHash, bcrypt.DefaultCost)
+}
+
+// Check whether password is the one that pwdHash was computed from by hashPassword().
+func checkPassword(pwdHash []byte, userId uuid.UUID, password string) bool {
+	pwdToCheck := make([]byte, 0, len(userId)+len(password))
+	pwdToCheck = append(pwdToCheck, userId[:]...)
+	pwdToCheck = append(pwdToCheck, password...)
+
+	return bcrypt.CompareHashAndPassword(pwdHash, pwdToCheck) == nil
+}
+
+// Generate a token for single-use links sent to users, such as password reset links.
+func newSingleUseToken() (string, error) {
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", err
+	}
+
+	// url-safe, as the token is sent as part of a link
+	return base64.RawURLEncoding.EncodeToString(tokenBytes), nil
+}
+
+// Return the hash of a single-use token, which is what's stored in the database in place of the token.
+func hashToken(token string) []byte {
+	hash := sha256.Sum256([]byte(token))
+	return hash[:]
+}
+
+// Verify that password is the user's password. If it isn't, ErrPasswordIncorrect is returned, and if
+// the user has no password at all (i.e. only ever logged in with Spotify), ErrNoPasswordSet is returned.
+func (user *User) CheckPassword(ctx context.Context, database *Db, password string) error {
+	var pwdHash []byte
+	err := database.pool.QueryRow(
+		ctx,
+		`
+			select pwdhash
+			from auth.user
+			where id=$1
+		`,
+		user.Id,
+	).Scan(&pwdHash)
+
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return ErrUserNotFound
+		}
+
+		return err
+	}
+
+	if pwdHash == nil {
+		return ErrNoPasswordSet
+	}
+
+	if !checkPassword(pwdHash, user.Id, password) {
+		return ErrPasswordIncorrect
+	}
+
+	return nil
+}
+
+// Change the user's password after verifying its current one, and revoke all of the user's sessions
+// other than keepSessionId, i.e. the one the password is being changed from. currentPassword is
+// verified by User.CheckPassword().
+func (user *User) ChangePassword(ctx context.Context, database *Db, currentPassword, newPassword string, keepSessionId uuid.UUID) error {
+	if err := user.CheckPassword(ctx, database, currentPassword); err != nil {
+		return err
+	}
+
+	newPwdHash, err := hashPassword(user.Id, newPassword)
+	if err != nil {
+		return err
+	}
+
+	return pgx.BeginFunc(ctx, database.pool, func(tx pgx.Tx) error {
+		_, err := tx.Exec(
+			ctx,
+			`
+				update auth.user
+				set pwdhash=$2
+				where id=$1
+			`,
+			user.Id,
+			newPwdHash,
+		)
+
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(
+			ctx,
+			`
+				delete from auth.auth_token
+				where userid=$1 and id<>$2
+			`,
+			user.Id,
+			keepSessionId,
+		)
+
+		return err
+	})
+}
+
+// Issue a single-use password reset token for the user registered with the given e-mail address,
+// returning the user and the token. If no user is registered with the address, ErrEmailNotRegistered
+// is returned, and if the address hasn't been verified, ErrEmailNotVerified is returned, as resetting
+// the password through an address that may not be the user's would allow taking over the account.
+// The token is valid for PasswordResetLifetime.
+func (db *Db) NewPasswordReset(ctx context.Context, email string) (User, string, error) {
+	var userId uuid.UUID
+	var verified bool
+	err := db.pool.QueryRow(
+		ctx,
+		`
+			select id, email_verified_at is not null
+			from auth.user
+			where email=$1
+		`,
+		email,
+	).Scan(&userId, &verified)
+
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return User{}, "", ErrEmailNotRegistered
+		}
+
+		return User{}, "", err
+	}
+
+	if !verified {
+		return User{}, "", ErrEmailNotVerified
+	}
+
+	user, err := db.GetUserFromId(ctx, userId)
+	if err != nil {
+		return User{}, "", err
+	}
+
+	token, err := newSingleUseToken()
+	if err != nil {
+		return User{}, "", err
+	}
+
+	// expired tokens of the user are of no use anymore
+	_, err = db.pool.Exec(
+		ctx,
+		`
+			delete from auth.password_reset
+			where userid=$1 and expires_at <= now()
+		`,
+		userId,
+	)
+
+	if err != nil {
+		return User{}, "", err
+	}
+
+	_, err = db.pool.Exec(
+		ctx,
+		`
+			insert into auth.password_reset
+			(token_hash, userid, expires_at)
+			values (@tokenHash, @userId, now() + @lifetime::interval)
+		`,
+		pgx.NamedArgs{
+			"tokenHash": hashToken(token),
+			"userId":    userId,
+			"lifetime":  PasswordResetLifetime,
+		},
+	)
+
+	if err != nil {
+		return User{}, "", err
+	}
+
+	return user, token, nil
+}
+
+// Set a new password for the user that the password reset token was issued to. The token, along with
+// all other pending reset tokens of the user, is consumed, and all of the user's sessions are revoked.
+// If the token doesn't exist or has expired, ErrInvalidResetToken is returned.
+func (db *Db) ResetPassword(ctx context.Context, token string, newPassword string) error {
+	return pgx.BeginFunc(ctx, db.pool, func(tx pgx.Tx) error {
+		var userId uuid.UUID
+		err := tx.QueryRow(
+			ctx,
+			`
+				delete from auth.password_reset
+				where token_hash=$1 and expires_at > now()
+				returning userid
+			`,
+			hashToken(token),
+		).Scan(&userId)
+
+		if err != nil {
+			if err == pgx.ErrNoRows {
+				return ErrInvalidResetToken
+			}
+
+			return err
+		}
+
+		pwdHash, err := hashPassword(userId, newPassword)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(
+			ctx,
+			`
+				update auth.user
+				set pwdhash=$2
+				where id=$1
+			`,
+			userId,
+			pwdHash,
+		)
+
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(
+			ctx,
+			`
+				delete from auth.password_reset
+				where userid=$1
+			`,
+			userId,
+		)
+
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(
+			ctx,
+			`
+				delete from auth.auth_token
+				where userid=$1
+			`,
+			userId,
+		)
+
+		return err
+	})
+}
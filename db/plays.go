@@ -0,0 +1,170 @@
+package db
+
+import (
+	music "bool3max/musicdash/music"
+	"bool3max/musicdash/spotify"
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Where a play was recorded from.
+type PlaySource string
+
+const (
+	// aggregated from the user's recently played tracks on Spotify
+	PlaySourceSpotify PlaySource = "spotify"
+	// imported from the user's Spotify Extended Streaming History
+	PlaySourceSpotifyHistory PlaySource = "spotify_history"
+	// imported from the user's Last.fm scrobbles
+	PlaySourceLastfm PlaySource = "lastfm"
+	// imported from the user's ListenBrainz listens
+	PlaySourceListenBrainz PlaySource = "listenbrainz"
+	// submitted by another player through the ingest API, as a ListenBrainz submit-listens request
+	PlaySourceIngestListenBrainz PlaySource = "ingest_listenbrainz"
+	// submitted by another player through the ingest API, as an Audioscrobbler track.scrobble call
+	PlaySourceIngestAudioscrobbler PlaySource = "ingest_audioscrobbler"
+)
+
+// Criteria restricting the plays returned by User.GetPlays(). Zero values don't restrict anything.
+// Filtering by artist, album or the explicit flag only matches plays of preserved tracks.
+type PlayFilter struct {
+	// plays in the range [From, To)
+	From, To time.Time
+
+	ArtistId string
+	AlbumId  string
+	TrackId  string
+	Explicit *bool
+
+	Source PlaySource
+}
+
+// Position in a user's history that a page of plays continues from, see User.GetPlays().
+type PlayCursor struct {
+	At      time.Time
+	TrackId string
+}
+
+// Cursor continuing right after play.
+func PlayCursorAfter(play spotify.Play) PlayCursor {
+	return PlayCursor{At: play.At, TrackId: play.Track.SpotifyId}
+}
+
+// Get a page of the user's plays matching filter, most recent first. Pages are keyed by the time and
+// the track of the play, so that plays at the same time are never skipped or repeated: pass the zero
+// cursor as before to get the first page, and PlayCursorAfter() the last play of a page to get the next
+// one. spotifyProvider is used for tracks that aren't preserved, and may be nil if only preserved tracks
+// need to be complete.
+func (user *User) GetPlays(ctx context.Context, database *Db, filter PlayFilter, before PlayCursor, limit int, spotifyProvider music.ResourceProvider) ([]spotify.Play, error) {
+	rows, err := database.pool.Query(
+		ctx,
+		`
+			select plays.spotifyid, plays.at
+			from public.plays
+			where plays.userid=@userId
+				and (@before::timestamptz is null or (plays.at, plays.spotifyid) < (@before, @beforeTrackId::varchar))
+				and (@from::timestamptz is null or plays.at >= @from)
+				and (@to::timestamptz is null or plays.at < @to)
+				and (@trackId::varchar is null or plays.spotifyid=@trackId)
+				and (@source::varchar is null or plays.source=@source)
+				and (@artistId::varchar is null or exists (
+					select 1
+					from spotify.track_artist
+					where track_artist.spotifyidtrack=plays.spotifyid and track_artist.spotifyidartist=@artistId
+				))
+				and (@albumId::varchar is null or exists (
+					select 1
+					from spotify.track
+					where track.spotifyid=plays.spotifyid and track.spotifyidalbum=@albumId
+				))
+				and (@explicit::boolean is null or exists (
+					select 1
+					from spotify.track
+					where track.spotifyid=plays.spotifyid and track.explicit=@explicit
+				))
+			order by plays.at desc, plays.spotifyid desc
+			limit @limit
+		`,
+		pgx.NamedArgs{
+			"userId":        user.Id,
+			"before":        nullTime(before.At),
+			"beforeTrackId": before.TrackId,
+			"from":          nullTime(filter.From),
+			"to":            nullTime(filter.To),
+			"trackId":       nullString(filter.TrackId),
+			"artistId":      nullString(filter.ArtistId),
+			"albumId":       nullString(filter.AlbumId),
+			"source":        nullString(string(filter.Source)),
+			"explicit":      filter.Explicit,
+			"limit":         limit,
+		},
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	plays := make([]spotify.Play, 0, limit)
+	trackIds := make([]string, 0, limit)
+
+	var spotifyId string
+	var at time.Time
+	_, err = pgx.ForEachRow(rows, []any{&spotifyId, &at}, func() error {
+		plays = append(plays, spotify.Play{At: at})
+		trackIds = append(trackIds, spotifyId)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	// get track data from local database, or from Spotify for tracks that aren't preserved
+	tracks, err := database.getTracksWithFallback(ctx, trackIds, spotifyProvider)
+	if err != nil {
+		return nil, err
+	}
+
+	for idx := range plays {
+		plays[idx].Track = tracks[idx]
+	}
+
+	return plays, nil
+}
+
+// Call fn with consecutive pages of all of the user's plays matching filter, most recent first, so
+// that the whole history can be processed without loading it into memory at once. Iteration stops at
+// the first error returned by fn, which is then returned. spotifyProvider may be nil, see GetPlays().
+func (user *User) ForEachPlayPage(ctx context.Context, database *Db, filter PlayFilter, pageSize int, spotifyProvider music.ResourceProvider, fn func([]spotify.Play) error) error {
+	var before PlayCursor
+
+	for {
+		plays, err := user.GetPlays(ctx, database, filter, before, pageSize, spotifyProvider)
+		if err != nil {
+			return err
+		}
+
+		if len(plays) > 0 {
+			if err := fn(plays); err != nil {
+				return err
+			}
+		}
+
+		if len(plays) < pageSize {
+			return nil
+		}
+
+		before = PlayCursorAfter(plays[len(plays)-1])
+	}
+}
+
+// Convert the empty string to a SQL null.
+func nullString(s string) *string {
+	if s == "" {
+		return nil
+	}
+
+	return &s
+}
@@ -0,0 +1,263 @@
+package db
+
+import (
+	music "bool3max/musicdash/music"
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Minimum similarity (between 0 and 1) of a resource to the search query for it to be matched,
+// unless another one is given in SearchOptions. The same as pg_trgm's default.
+const DefaultSearchThreshold = 0.3
+
+var ErrInvalidSearchThreshold = errors.New("search threshold must be between 0 and 1")
+
+type SearchOptions struct {
+	// types of resources to search for, all of them if empty
+	Types []music.ResourceType
+
+	Limit  int
+	Offset int
+
+	// minimum similarity of matches, DefaultSearchThreshold if zero
+	Threshold float64
+}
+
+// A single search result. Exactly one of Track, Album and Artist is set, according to Type.
+type SearchMatch struct {
+	Type music.ResourceType
+
+	// similarity of the resource to the query, between 0 and 1
+	Score float64
+
+	Track  *music.Track
+	Album  *music.Album
+	Artist *music.Artist
+}
+
+// Search the preserved catalog for tracks, albums and artists similar to query, returning a page of
+// matches ordered by descending similarity. Tracks and albums are matched both by their title alone
+// and by their title combined with the names of their artists, so that queries such as
+// "bohemian rhapsody queen" match as well. Artists are matched by their name.
+// Artists of matched albums and tracks are filled, but their discographies and tracklists aren't.
+func (db *Db) Search(ctx context.Context, query string, options SearchOptions) ([]SearchMatch, error) {
+	threshold := options.Threshold
+	if threshold == 0 {
+		threshold = DefaultSearchThreshold
+	}
+
+	if threshold < 0 || threshold > 1 {
+		return nil, ErrInvalidSearchThreshold
+	}
+
+	types := make([]string, len(options.Types))
+	for idx, resourceType := range options.Types {
+		types[idx] = string(resourceType)
+	}
+
+	if len(types) == 0 {
+		types = []string{string(music.ResourceTrack), string(music.ResourceAlbum), string(music.ResourceArtist)}
+	}
+
+	type searchRow struct {
+		resourceType music.ResourceType
+		spotifyId    string
+		score        float64
+	}
+
+	var rows []searchRow
+
+	// the % operator, which the trigram indexes are used for, matches according to
+	// pg_trgm.similarity_threshold, which is only set for the duration of the transaction
+	err := pgx.BeginFunc(ctx, db.pool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `select set_config('pg_trgm.similarity_threshold', $1, true)`, strconv.FormatFloat(threshold, 'f', -1, 64)); err != nil {
+			return err
+		}
+
+		result, err := tx.Query(
+			ctx,
+			`
+				select resourcetype, spotifyid, score
+				from (
+					select 'track' as resourcetype, spotifyid, greatest(similarity(title, @query), similarity(searchtext, @query)) as score
+					from spotify.track
+					where 'track'=any(@types) and (title % @query or searchtext % @query)
+
+					union all
+
+					select 'album', spotifyid, greatest(similarity(title, @query), similarity(searchtext, @query))
+					from spotify.album
+					where 'album'=any(@types) and (title % @query or searchtext % @query)
+
+					union all
+
+					select 'artist', spotifyid, similarity(name, @query)
+					from spotify.artist
+					where 'artist'=any(@types) and name % @query
+				) as matches
+				order by score desc, resourcetype, spotifyid
+				limit @limit
+				offset @offset
+			`,
+			pgx.NamedArgs{
+				"query":  query,
+				"types":  types,
+				"limit":  nullLimit(options.Limit),
+				"offset": options.Offset,
+			},
+		)
+
+		if err != nil {
+			return err
+		}
+
+		rows, err = pgx.CollectRows(result, func(row pgx.CollectableRow) (searchRow, error) {
+			var match searchRow
+			var resourceType string
+			err := row.Scan(&resourceType, &match.spotifyId, &match.score)
+			match.resourceType = music.ResourceType(resourceType)
+			return match, err
+		})
+
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	idsOf := make(map[music.ResourceType][]string, len(types))
+	for _, row := range rows {
+		idsOf[row.resourceType] = append(idsOf[row.resourceType], row.spotifyId)
+	}
+
+	tracks, err := db.loadTracks(ctx, idsOf[music.ResourceTrack])
+	if err != nil {
+		return nil, err
+	}
+
+	albums, err := db.loadAlbums(ctx, idsOf[music.ResourceAlbum])
+	if err != nil {
+		return nil, err
+	}
+
+	artists, err := db.loadArtists(ctx, idsOf[music.ResourceArtist])
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([]SearchMatch, 0, len(rows))
+	for _, row := range rows {
+		match := SearchMatch{
+			Type:  row.resourceType,
+			Score: row.score,
+		}
+
+		switch row.resourceType {
+		case music.ResourceTrack:
+			track := tracks[row.spotifyId]
+			match.Track = &track
+		case music.ResourceAlbum:
+			album := albums[row.spotifyId]
+			match.Album = &album
+		case music.ResourceArtist:
+			artist := artists[row.spotifyId]
+			match.Artist = &artist
+		}
+
+		matches = append(matches, match)
+	}
+
+	return matches, nil
+}
+
+// Search the preserved catalog for tracks matching a structured query: tracks with the query's ISRC, if
+// any, followed by tracks similar to its title and artist. Implements music.TrackSearcher.
+func (db *Db) SearchTracks(ctx context.Context, query music.MatchQuery, limit int) ([]music.Track, error) {
+	tracks := make([]music.Track, 0, limit)
+
+	if query.Isrc != "" {
+		rows, err := db.pool.Query(
+			ctx,
+			`
+				select spotifyid
+				from spotify.track
+				where isrc=$1
+				limit $2
+			`,
+			query.Isrc,
+			limit,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		ids, err := pgx.CollectRows(rows, pgx.RowTo[string])
+		if err != nil {
+			return nil, err
+		}
+
+		byIsrc, err := db.loadTracks(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, track := range byIsrc {
+			tracks = append(tracks, track)
+		}
+	}
+
+	matches, err := db.Search(ctx, query.Title+" "+query.Artist, SearchOptions{
+		Types: []music.ResourceType{music.ResourceTrack},
+		Limit: limit,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, match := range matches {
+		tracks = append(tracks, *match.Track)
+	}
+
+	return tracks, nil
+}
+
+// Return the id of the preserved resource of the given type most similar to query,
+// or ErrResourceNotPreserved if there's none similar enough.
+func (db *Db) searchBest(ctx context.Context, query string, resourceType music.ResourceType) (string, error) {
+	matches, err := db.Search(ctx, query, SearchOptions{
+		Types: []music.ResourceType{resourceType},
+		Limit: 1,
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	if len(matches) == 0 {
+		return "", ErrResourceNotPreserved
+	}
+
+	switch resourceType {
+	case music.ResourceTrack:
+		return matches[0].Track.SpotifyId, nil
+	case music.ResourceAlbum:
+		return matches[0].Album.SpotifyId, nil
+	default:
+		return matches[0].Artist.SpotifyId, nil
+	}
+}
+
+// Convert a non-positive limit to a SQL null, i.e. no limit.
+func nullLimit(limit int) *int {
+	if limit <= 0 {
+		return nil
+	}
+
+	return &limit
+}
@@ -0,0 +1,246 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// An authentication token is generated upon successful login and consists of 64 random bytes of data,
+// encoded in base64 format. Only its SHA-256 hash is stored in the database. Every auth token identifies
+// a login session.
+type UserAuthToken string
+
+const (
+	// absolute lifetime of a session, after which the user has to log in again
+	SessionLifetime = 90 * 24 * time.Hour
+
+	// sessions unused for longer than this expire before their absolute expiry
+	SessionIdleTimeout = 30 * 24 * time.Hour
+
+	// a session's last use is only recorded if the previous one is older than this,
+	// so that not every authenticated request incurs a write
+	sessionRenewInterval = 5 * time.Minute
+)
+
+var ErrInvalidAuthToken = errors.New("invalid auth token")
+var ErrSessionNotFound = errors.New("session not found")
+
+// The device a session is used from, as reported by its requests.
+type SessionDevice struct {
+	UserAgent string
+	Ip        string
+}
+
+type Session struct {
+	Id         uuid.UUID
+	UserId     uuid.UUID
+	GrantedAt  time.Time
+	LastSeenAt time.Time
+
+	// absolute expiry of the session, see Session.Expiry()
+	ExpiresAt time.Time
+
+	// device the session was last used from
+	Device SessionDevice
+}
+
+// Return the time at which the session expires unless it's used again.
+func (session *Session) Expiry() time.Time {
+	idleExpiry := session.LastSeenAt.Add(SessionIdleTimeout)
+	if idleExpiry.Before(session.ExpiresAt) {
+		return idleExpiry
+	}
+
+	return session.ExpiresAt
+}
+
+// Unconditionally issue an auth token for an user, starting a new session on the given device. The function
+// generates a new valid UserAuthToken, saves it in the database, and returns it. The function doesn't check
+// if the passed userId is valid, and attempts to insert it into auth.auth_token, which will of course fail
+// on an invalid user id due to the foreign key constraint.
+func (db *Db) UserNewAuthToken(ctx context.Context, userId uuid.UUID, device SessionDevice) (UserAuthToken, error) {
+	// generate new random 64 bytes to use as auth token
+	authToken := make([]byte, 64)
+	_, err := rand.Read(authToken)
+	if err != nil {
+		return "", err
+	}
+
+	// encode as base64 string
+	authTokenB64 := base64.StdEncoding.EncodeToString(authToken)
+
+	// preserve token into database
+	_, err = db.pool.Exec(
+		ctx,
+		`
+			insert into auth.auth_token
+			(userid, token_hash, expires_at, user_agent, ip)
+			values (@userId, @tokenHash, now() + @lifetime::interval, @userAgent, @ip)
+		`,
+		pgx.NamedArgs{
+			"userId":    userId,
+			"tokenHash": hashAuthToken(UserAuthToken(authTokenB64)),
+			"lifetime":  SessionLifetime,
+			"userAgent": device.UserAgent,
+			"ip":        device.Ip,
+		},
+	)
+
+	if err != nil {
+		return "", err
+	}
+
+	return UserAuthToken(authTokenB64), nil
+}
+
+// Validate the passed UserAuthToken and return an instance of the User that it belongs to, along with
+// its session. Using a session renews it, extending its idle expiry and recording the device it was used
+// from. If the passed auth. token is invalid (i.e. does not exist in the database or its session has
+// expired), return an ErrorInvalidAuthToken error and an empty User{} instance.
+func (db *Db) GetUserFromAuthToken(ctx context.Context, token UserAuthToken, device SessionDevice) (User, Session, error) {
+	var session Session
+	err := db.pool.QueryRow(
+		ctx,
+		`
+			with session as (
+				select id, userid, granted_at, last_seen_at, expires_at, user_agent, ip
+				from auth.auth_token
+				where token_hash=@tokenHash and expires_at > now() and last_seen_at > now() - @idleTimeout::interval
+			), renewed as (
+				update auth.auth_token
+				set last_seen_at=now(), user_agent=@userAgent, ip=@ip
+				from session
+				where auth_token.id=session.id
+					and (session.last_seen_at < now() - @renewInterval::interval or session.user_agent<>@userAgent or session.ip<>@ip)
+				returning auth_token.last_seen_at
+			)
+			select id, userid, granted_at, coalesce((select last_seen_at from renewed), last_seen_at), expires_at
+			from session
+		`,
+		pgx.NamedArgs{
+			"tokenHash":     hashAuthToken(token),
+			"idleTimeout":   SessionIdleTimeout,
+			"renewInterval": sessionRenewInterval,
+			"userAgent":     device.UserAgent,
+			"ip":            device.Ip,
+		},
+	).Scan(&session.Id, &session.UserId, &session.GrantedAt, &session.LastSeenAt, &session.ExpiresAt)
+
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			// auth token not in database, or session expired
+			return User{}, Session{}, ErrInvalidAuthToken
+		}
+
+		// other db error
+		return User{}, Session{}, err
+	}
+
+	session.Device = device
+
+	user, err := db.GetUserFromId(ctx, session.UserId)
+	if err != nil {
+		return User{}, Session{}, err
+	}
+
+	return user, session, nil
+}
+
+// Revoke a specific auth. token
+func (db *Db) RevokeAuthToken(ctx context.Context, token UserAuthToken) error {
+	_, err := db.pool.Exec(
+		ctx,
+		`
+			delete from auth.auth_token
+			where auth.auth_token.token_hash = $1
+		`,
+		hashAuthToken(token),
+	)
+
+	return err
+}
+
+// Delete all expired sessions of all users, returning the number of deleted sessions.
+func (db *Db) DeleteExpiredSessions(ctx context.Context) (int64, error) {
+	tag, err := db.pool.Exec(
+		ctx,
+		`
+			delete from auth.auth_token
+			where expires_at <= now() or last_seen_at <= now() - $1::interval
+		`,
+		SessionIdleTimeout,
+	)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// Get all of the user's active sessions, most recently used first.
+func (user *User) GetSessions(ctx context.Context, database *Db) ([]Session, error) {
+	rows, err := database.pool.Query(
+		ctx,
+		`
+			select id, userid, granted_at, last_seen_at, expires_at, user_agent, ip
+			from auth.auth_token
+			where userid=$1 and expires_at > now() and last_seen_at > now() - $2::interval
+			order by last_seen_at desc
+		`,
+		user.Id,
+		SessionIdleTimeout,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (Session, error) {
+		var session Session
+		err := row.Scan(&session.Id, &session.UserId, &session.GrantedAt, &session.LastSeenAt, &session.ExpiresAt, &session.Device.UserAgent, &session.Device.Ip)
+		return session, err
+	})
+}
+
+// Revoke a single session of the user. If the user has no such session, ErrSessionNotFound is returned.
+func (user *User) RevokeSession(ctx context.Context, database *Db, sessionId uuid.UUID) error {
+	tag, err := database.pool.Exec(
+		ctx,
+		`
+			delete from auth.auth_token
+			where userid=$1 and id=$2
+		`,
+		user.Id,
+		sessionId,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrSessionNotFound
+	}
+
+	return nil
+}
+
+func (user *User) RevokeAllTokens(ctx context.Context, database *Db) error {
+	_, err := database.pool.Exec(
+		ctx,
+		`
+			delete from auth.auth_token
+			where auth.auth_token.userid=$1
+		`,
+		user.Id,
+	)
+
+	return err
+}
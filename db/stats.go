@@ -0,0 +1,160 @@
+package db
+
+import (
+	music "bool3max/musicdash/music"
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// How plays are counted when computing statistics.
+type StatsMode string
+
+const (
+	// every Spotify track is counted separately
+	StatsByTrack StatsMode = "track"
+
+	// plays of all tracks of the same recording (see music.GroupRecordings()) are counted together,
+	// so that e.g. plays of a song's album version and of its remastered reissue add up
+	StatsByRecording StatsMode = "recording"
+)
+
+var ErrInvalidStatsMode = errors.New("invalid stats mode")
+
+// When counting by recording with a limit, only the limit*recordingCandidatesFactor most played tracks are
+// grouped into recordings, so that the whole listening history doesn't have to be loaded. Recordings whose
+// tracks are all played less than those are left out, even if together they'd make it into the top.
+const recordingCandidatesFactor = 5
+
+type TrackStat struct {
+	// the most played track of the group
+	Track music.Track
+	Plays int
+
+	// key of the recording when counting by recording, empty otherwise
+	RecordingKey string
+
+	// all played tracks counted towards the stat, most played first
+	Tracks []music.Track
+}
+
+// Return the user's most played tracks with plays in the range [from, to), most played first.
+// A zero from or to leaves the range open on that side, and a limit of 0 returns all tracks.
+// As with GetRecentPlaysFromDB(), spotifyProvider is used for tracks that aren't preserved.
+func (user *User) GetTopTracks(ctx context.Context, database *Db, from, to time.Time, limit int, mode StatsMode, spotifyProvider music.ResourceProvider) ([]TrackStat, error) {
+	if mode != StatsByTrack && mode != StatsByRecording {
+		return nil, ErrInvalidStatsMode
+	}
+
+	// when counting by track only the top tracks need to be loaded, whereas a top recording may
+	// consist of less played tracks
+	candidates := limit
+	if mode == StatsByRecording {
+		candidates *= recordingCandidatesFactor
+	}
+
+	rows, err := database.pool.Query(
+		ctx,
+		`
+			select spotifyid, count(*)
+			from public.plays
+			where userid=@userId
+				and (@from::timestamptz is null or at >= @from)
+				and (@to::timestamptz is null or at < @to)
+			group by spotifyid
+			order by count(*) desc, spotifyid
+			limit nullif(@candidates::bigint, 0)
+		`,
+		pgx.NamedArgs{
+			"userId":     user.Id,
+			"from":       nullTime(from),
+			"to":         nullTime(to),
+			"candidates": candidates,
+		},
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	type playCount struct {
+		spotifyId string
+		plays     int
+	}
+
+	counts, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (playCount, error) {
+		var count playCount
+		err := row.Scan(&count.spotifyId, &count.plays)
+		return count, err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	trackIds := make([]string, len(counts))
+	for idx, count := range counts {
+		trackIds[idx] = count.spotifyId
+	}
+
+	tracks, err := database.getTracksWithFallback(ctx, trackIds, spotifyProvider)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make([]TrackStat, 0, len(counts))
+
+	if mode == StatsByTrack {
+		for idx, count := range counts {
+			stats = append(stats, TrackStat{
+				Track:  tracks[idx],
+				Plays:  count.plays,
+				Tracks: []music.Track{tracks[idx]},
+			})
+		}
+
+		return stats, nil
+	}
+
+	playsOf := make(map[string]int, len(counts))
+	for _, count := range counts {
+		playsOf[count.spotifyId] = count.plays
+	}
+
+	// tracks are ordered by plays, so the first track of every recording is its most played one
+	for _, recording := range music.GroupRecordings(tracks) {
+		stat := TrackStat{
+			Track:        recording.Tracks[0],
+			RecordingKey: recording.Key,
+			Tracks:       recording.Tracks,
+		}
+
+		for _, track := range recording.Tracks {
+			stat.Plays += playsOf[track.SpotifyId]
+		}
+
+		stats = append(stats, stat)
+	}
+
+	slices.SortStableFunc(stats, func(a, b TrackStat) int {
+		return b.Plays - a.Plays
+	})
+
+	if limit > 0 && len(stats) > limit {
+		stats = stats[:limit]
+	}
+
+	return stats, nil
+}
+
+// Convert the zero time.Time to a SQL null.
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+
+	return &t
+}
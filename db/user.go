@@ -4,8 +4,6 @@ import (
 	music "bool3max/musicdash/music"
 	"bool3max/musicdash/spotify"
 	"context"
-	"crypto/rand"
-	"encoding/base64"
 	"errors"
 	"fmt"
 	"log"
@@ -13,26 +11,25 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
-	"golang.org/x/crypto/bcrypt"
 )
 
-// An authentication token is generated upon successful login and consists of 64 random bytes of data,
-// encoded in base64 format and stored as a string in the database.
-type UserAuthToken string
-
 var ErrEmailNotRegistered = errors.New("e-mail does not exist in database")
 var ErrPasswordIncorrect = errors.New("password incorrect")
-var ErrInvalidAuthToken = errors.New("invalid auth token")
 var ErrSpotifyProfileNotLinked = errors.New("user has no linked spotify profile")
 var ErrUserNotFound = errors.New("user not found")
 var ErrNoProfileImageSet = errors.New("user has no profile image set")
 
 type User struct {
-	Id           uuid.UUID
-	RegisteredAt time.Time
-	Username     string
-	Email        string
-	Spotify      *spotify.Client
+	Id            uuid.UUID
+	RegisteredAt  time.Time
+	Username      string
+	Email         string
+	EmailVerified bool
+
+	// when the account is purged, if the user requested it to be deleted
+	PurgeAt *time.Time
+
+	Spotify *spotify.Client
 }
 
 type UserProfileImage struct {
@@ -45,65 +42,12 @@ type UserProfileImage struct {
 // Get the last N plays made by the corresponding user, order by most recent play first.
 // An alternative Spotify ResourceProvider must be passed in to handle cases where a track
 // isn't preserved in the database.
-func (user *User) GetRecentPlaysFromDB(limit int, spotifyProvider music.ResourceProvider) ([]spotify.Play, error) {
-	rows, err := Acquire().pool.Query(
-		context.Background(),
-		`
-			select spotifyid, at
-			from public.plays
-			where userid=$1
-			order by at desc
-			limit $2
-		`,
-		user.Id,
-		limit,
-	)
-
-	if err != nil {
-		return nil, err
-	}
-
-	plays, err := pgx.CollectRows[spotify.Play](rows, func(row pgx.CollectableRow) (spotify.Play, error) {
-		var spotifyId string
-		var at time.Time
-
-		if err := row.Scan(&spotifyId, &at); err != nil {
-			return spotify.Play{}, err
-		}
-
-		// attempt to get track data from local database
-		track, err := Acquire().GetTrackById(spotifyId)
-
-		// found track preserved in database
-		if err == nil {
-			return spotify.Play{
-				At:    at,
-				Track: *track,
-			}, nil
-		}
-
-		// track not in database, get it from Spotify
-		if err == ErrResourceNotPreserved {
-			track, err := spotifyProvider.GetTrackById(spotifyId)
-			if err != nil {
-				return spotify.Play{}, err
-			}
-
-			return spotify.Play{
-				Track: *track,
-				At:    at,
-			}, nil
-		}
-
-		// other error?
-		return spotify.Play{}, err
-	})
-
-	return plays, nil
+func (user *User) GetRecentPlaysFromDB(database *Db, limit int, spotifyProvider music.ResourceProvider) ([]spotify.Play, error) {
+	return user.GetPlays(context.Background(), database, PlayFilter{}, PlayCursor{}, limit, spotifyProvider)
 }
 
-// Return a slice of registered users who have a linked Spotify client. User.Spotify clients
-// are not initialized.
+// Return a slice of registered users who have a linked Spotify client, excluding users whose
+// accounts are scheduled for deletion. User.Spotify clients are not initialized.
 func (db *Db) GetUsersWithSpotifyLinked() ([]User, error) {
 	users := make([]User, 0)
 
@@ -113,6 +57,7 @@ func (db *Db) GetUsersWithSpotifyLinked() ([]User, error) {
 			select id, username, registered_at, email
 			from auth.user_spotify	
 				inner join auth.user on auth.user.id=auth.user_spotify.userid
+			where auth.user.purge_at is null
 		`,
 	)
 
@@ -144,8 +89,8 @@ func (db *Db) GetUsersWithSpotifyLinked() ([]User, error) {
 // Set the user's profile image. All profile images must first be converted to webp format and sanitized.
 // This method performs no such checks, I do them in the http handler.
 // It sets the "size" column based on the length of the provided binary data of the image.
-func (user *User) SetProfileImage(ctx context.Context, width, height int, data []byte) error {
-	_, err := Acquire().pool.Exec(
+func (user *User) SetProfileImage(ctx context.Context, database *Db, width, height int, data []byte) error {
+	_, err := database.pool.Exec(
 		ctx,
 		`
 			insert into auth.user_profile_img
@@ -169,19 +114,20 @@ func (user *User) SetProfileImage(ctx context.Context, width, height int, data [
 
 // Attach a new, refreshed spotify.Client instance to user.Spotify using authentication parameters from the database.
 // Saves potentially new auth. parameters to the database.
-func (user *User) AttachSpotifyAuth(ctx context.Context) error {
+func (user *User) AttachSpotifyAuth(ctx context.Context, database *Db) error {
 	var accessToken, refreshToken string
+	var keyId *string
 	var expiresAt time.Time
 
-	err := Acquire().pool.QueryRow(
+	err := database.pool.QueryRow(
 		ctx,
 		`
-			select accesstoken, refreshtoken, expiresat
+			select accesstoken, refreshtoken, keyid, expiresat
 			from auth.spotify_token
 			where userid=$1
 		`,
 		user.Id,
-	).Scan(&accessToken, &refreshToken, &expiresAt)
+	).Scan(&accessToken, &refreshToken, &keyId, &expiresAt)
 
 	if err != nil {
 		// No spotify auth. params. in db for current user -> profile not linked
@@ -193,6 +139,17 @@ func (user *User) AttachSpotifyAuth(ctx context.Context) error {
 		return err
 	}
 
+	// tokens without a key id are still in plaintext and are encrypted when saved below
+	if keyId != nil {
+		if accessToken, err = database.keys.open(*keyId, accessToken, user.Id[:]); err != nil {
+			return err
+		}
+
+		if refreshToken, err = database.keys.open(*keyId, refreshToken, user.Id[:]); err != nil {
+			return err
+		}
+	}
+
 	userSpotifyClient := spotify.AuthorizationCodeFromParams(
 		MUSICDASH_SPOTIFY_CLIENT_ID,
 		MUSICDASH_SPOTIFY_SECRET,
@@ -210,7 +167,7 @@ func (user *User) AttachSpotifyAuth(ctx context.Context) error {
 	user.Spotify = userSpotifyClient
 
 	// save potentially-refreshed new spotify auth. params. to database
-	if err = user.SaveSpotifyAuthDB(ctx); err != nil {
+	if err = user.SaveSpotifyAuthDB(ctx, database); err != nil {
 		log.Println("Error preserving Spotify AuthParams to database: ", err)
 		user.Spotify = nil
 		return err
@@ -219,25 +176,36 @@ func (user *User) AttachSpotifyAuth(ctx context.Context) error {
 	return nil
 }
 
-// Preserve the current parameters in user.Spotify to the database unconditionally.
-func (user *User) SaveSpotifyAuthDB(ctx context.Context) error {
+// Preserve the current parameters in user.Spotify to the database unconditionally, encrypting the tokens.
+func (user *User) SaveSpotifyAuthDB(ctx context.Context, database *Db) error {
 	if user.Spotify == nil {
 		return nil
 	}
 
-	_, err := Acquire().pool.Exec(
+	keyId, accessToken, err := database.keys.seal(user.Spotify.AccessToken, user.Id[:])
+	if err != nil {
+		return err
+	}
+
+	_, refreshToken, err := database.keys.seal(user.Spotify.RefreshToken, user.Id[:])
+	if err != nil {
+		return err
+	}
+
+	_, err = database.pool.Exec(
 		ctx,
 		`
 			insert into auth.spotify_token
-			(userid, accesstoken, refreshtoken, expiresat)
-			values (@userId, @accessToken, @refreshToken, @expiresAt)
+			(userid, accesstoken, refreshtoken, keyid, expiresat)
+			values (@userId, @accessToken, @refreshToken, @keyId, @expiresAt)
 			on conflict on constraint spotify_token_pk do update
-			set accesstoken=@accessToken, refreshtoken=@refreshToken, expiresat=@expiresAt
+			set accesstoken=@accessToken, refreshtoken=@refreshToken, keyid=@keyId, expiresat=@expiresAt
 		`,
 		pgx.NamedArgs{
 			"userId":       user.Id,
-			"accessToken":  user.Spotify.AccessToken,
-			"refreshToken": user.Spotify.RefreshToken,
+			"accessToken":  accessToken,
+			"refreshToken": refreshToken,
+			"keyId":        keyId,
 			"expiresAt":    user.Spotify.ExpiresAt,
 		},
 	)
@@ -248,8 +216,8 @@ func (user *User) SaveSpotifyAuthDB(ctx context.Context) error {
 // Associate/link a Spotify user profile with a musicdash account. This should only be done once the user
 // properly authenticates with Spotify. The user cannot have more than one linked Spotify account per
 // musicdash account.
-func (user *User) LinkSpotifyProfile(ctx context.Context, spotifyProfile spotify.UserProfile) error {
-	_, err := Acquire().pool.Exec(
+func (user *User) LinkSpotifyProfile(ctx context.Context, database *Db, spotifyProfile spotify.UserProfile) error {
+	_, err := database.pool.Exec(
 		ctx,
 		`
 			insert into auth.user_spotify
@@ -276,8 +244,8 @@ func (user *User) LinkSpotifyProfile(ctx context.Context, spotifyProfile spotify
 	return err
 }
 
-func (user *User) UnlinkSpotifyProfile(ctx context.Context) error {
-	_, err := Acquire().pool.Exec(
+func (user *User) UnlinkSpotifyProfile(ctx context.Context, database *Db) error {
+	_, err := database.pool.Exec(
 		ctx,
 		`
 			delete from auth.user_spotify
@@ -289,11 +257,11 @@ func (user *User) UnlinkSpotifyProfile(ctx context.Context) error {
 	return err
 }
 
-func (user *User) GetLinkedSpotifyProfile(ctx context.Context) (spotify.UserProfile, error) {
+func (user *User) GetLinkedSpotifyProfile(ctx context.Context, database *Db) (spotify.UserProfile, error) {
 	var profile spotify.UserProfile
 	profile.ProfileImages = make([]music.Image, 1)
 
-	err := Acquire().pool.QueryRow(
+	err := database.pool.QueryRow(
 		ctx,
 		`
 			select spotify_id, spotify_displayname, spotify_followers, spotify_uri, spotify_url, profile_image_url, profile_image_width, profile_image_height, country, spotify_email
@@ -318,48 +286,6 @@ func (user *User) String() string {
 	return fmt.Sprintf("[id:{%s}, username:{%s}, email:{%s}]", user.Id.String(), user.Username, user.Email)
 }
 
-func (user *User) RevokeAllTokens(ctx context.Context) error {
-	_, err := Acquire().pool.Exec(
-		ctx,
-		`
-			delete from auth.auth_token
-			where auth.auth_token.userid=$1
-		`,
-		user.Id,
-	)
-
-	return err
-}
-
-// Validate the passed UserAuthToken and return an instance of the User that it belongs to.
-// If the passed auth. token is invalid (i.e. does not exist in the database), return an
-// ErrorInvalidAuthToken error and an empty User{} instance.
-func (db *Db) GetUserFromAuthToken(ctx context.Context, token UserAuthToken) (User, error) {
-	var userId uuid.UUID
-	err := db.pool.QueryRow(
-		ctx,
-		`
-			select userid
-			from auth.auth_token
-			where token=$1
-			limit 1
-		`,
-		string(token),
-	).Scan(&userId)
-
-	if err != nil {
-		if err == pgx.ErrNoRows {
-			// auth token not in database
-			return User{}, ErrInvalidAuthToken
-		}
-
-		// other db error
-		return User{}, err
-	}
-
-	return db.GetUserFromId(ctx, userId)
-}
-
 // Check if the specified username already exists in the database.
 func (db *Db) UsernameIsRegistered(ctx context.Context, username string) (bool, error) {
 	err := db.pool.QueryRow(ctx, "select username from auth.user where username=$1 limit 1", username).Scan(nil)
@@ -394,12 +320,12 @@ func (db *Db) GetUserFromId(ctx context.Context, userId uuid.UUID) (User, error)
 	err := db.pool.QueryRow(
 		ctx,
 		`
-			select username, email, registered_at
+			select username, email, email_verified_at is not null, registered_at, purge_at
 			from auth.user
 			where id=$1
 		`,
 		userId,
-	).Scan(&newUser.Username, &newUser.Email, &newUser.RegisteredAt)
+	).Scan(&newUser.Username, &newUser.Email, &newUser.EmailVerified, &newUser.RegisteredAt, &newUser.PurgeAt)
 
 	if err != nil {
 		if err == pgx.ErrNoRows {
@@ -452,14 +378,7 @@ func (db *Db) UserInsert(username, password, email string) (uuid.UUID, error) {
 	// (this happens when "continue with spotify" is used and the account is considered simply
 	// a vessel for logging into with spotify)
 	if password != "" {
-		pwdAsBytes := []byte(password)
-
-		// The password to be hashed is salted by prepending to it the unique user id (uuidv4)
-		pwdToHash := make([]byte, 0, len(userUuid)+len(pwdAsBytes))
-		pwdToHash = append(pwdToHash, userUuid[:]...)
-		pwdToHash = append(pwdToHash, pwdAsBytes...)
-
-		pwdHash, err = bcrypt.GenerateFromPassword(pwdToHash, bcrypt.DefaultCost)
+		pwdHash, err = hashPassword(userUuid, password)
 		if err != nil {
 			return uuid.UUID{}, err
 		}
@@ -494,8 +413,6 @@ func (db *Db) UserInsert(username, password, email string) (uuid.UUID, error) {
 // but its associated password is guessed incorrectly, an ErrPasswordIncorrect error is returned.
 // Otherwise, if both the email exists and the psasword is correct, error is nil.
 func (db *Db) UserValidateLoginCred(ctx context.Context, passwordGuess, email string) (uuid.UUID, error) {
-	passwordGuessBytes := []byte(passwordGuess)
-
 	row := db.pool.QueryRow(
 		ctx,
 		`
@@ -523,15 +440,8 @@ func (db *Db) UserValidateLoginCred(ctx context.Context, passwordGuess, email st
 		}
 	}
 
-	// A properly salted byte slice of the password the user guessed, as a []byte
-	passwordGuessSaltedBytes := make([]byte, 0, len(userId)+len(passwordGuessBytes))
-	passwordGuessSaltedBytes = append(passwordGuessSaltedBytes, userId[:]...)
-	passwordGuessSaltedBytes = append(passwordGuessSaltedBytes, passwordGuessBytes...)
-
 	// compare correct password in db and guess
-	err = bcrypt.CompareHashAndPassword(pwdHashDb, passwordGuessSaltedBytes)
-	// passwords do not match
-	if err != nil {
+	if !checkPassword(pwdHashDb, userId, passwordGuess) {
 		return uuid.UUID{}, ErrPasswordIncorrect
 	}
 
@@ -540,58 +450,6 @@ func (db *Db) UserValidateLoginCred(ctx context.Context, passwordGuess, email st
 
 }
 
-// Unconditionally issue an auth token for an user. The function generates a new valid UserAuthToken,
-// saves it in the // database, and returns it. The function doesn't check if the passed userId is valid, and attempts
-// to insert it into auth.auth_token, which will of course fail on an invalid user id due to the
-// foreign key constraint.
-func (db *Db) UserNewAuthToken(ctx context.Context, userId uuid.UUID) (UserAuthToken, error) {
-	// generate new random 64 bytes to use as auth token
-	authToken := make([]byte, 64)
-	_, err := rand.Read(authToken)
-	if err != nil {
-		return "", err
-	}
-
-	// encode as base64 string
-	authTokenB64 := base64.StdEncoding.EncodeToString(authToken)
-
-	// insert the generated auth token into the database
-
-	// preserve token into database
-	_, err = db.pool.Exec(
-		ctx,
-		`
-			insert into auth.auth_token
-			(userid, token)
-			values (@userId, @authToken)
-		`,
-		pgx.NamedArgs{
-			"userId":    userId,
-			"authToken": authTokenB64,
-		},
-	)
-
-	if err != nil {
-		return "", err
-	}
-
-	return UserAuthToken(authTokenB64), nil
-}
-
-// Revoke a specific auth. token
-func (db *Db) RevokeAuthToken(ctx context.Context, token UserAuthToken) error {
-	_, err := db.pool.Exec(
-		ctx,
-		`
-			delete from auth.auth_token
-			where auth.auth_token.token = $1	
-		`,
-		token,
-	)
-
-	return err
-}
-
 // Get the current set profile image of the specified user. If the user has no profile image set,
 // an ErrNoProfileImageSet error is returned and a default profile image should be supplied.
 func (db *Db) GetUserProfileImage(ctx context.Context, userId uuid.UUID) (UserProfileImage, error) {
@@ -617,20 +475,23 @@ func (db *Db) GetUserProfileImage(ctx context.Context, userId uuid.UUID) (UserPr
 	return newProfileImg, nil
 }
 
-// Unconditionally preserve all Spotify plays in the "plays" slice to the database and
-// associate them with the given user.
-// TODO: do these inserts in a transaction!!
-func (user *User) SavePlays(plays []spotify.Play) error {
-	db := Acquire()
+// Preserve all Spotify plays in the "plays" slice to the database and associate them with the
+// given user, returning the number of newly preserved plays. Plays already preserved (i.e. by the
+// same user at the same time) are skipped, so saving the same plays again is harmless. Either all
+// new plays are preserved or, on error, none are.
+func (user *User) SavePlays(database *Db, plays []spotify.Play) (int, error) {
+	if len(plays) == 0 {
+		return 0, nil
+	}
 
+	batch := &pgx.Batch{}
 	for _, play := range plays {
-
-		_, err := db.pool.Exec(
-			context.Background(),
+		batch.Queue(
 			`
 				insert into public.plays
 				(userid, at, spotifyid)
 				values (@userId, @at, @spotifyId)
+				on conflict on constraint plays_userid_at_key do nothing
 			`,
 			pgx.NamedArgs{
 				"userId":    user.Id,
@@ -638,12 +499,29 @@ func (user *User) SavePlays(plays []spotify.Play) error {
 				"spotifyId": play.Track.SpotifyId,
 			},
 		)
+	}
 
-		if err != nil {
-			log.Printf("SavePlays: error saving play {%s}@{%v} for {%v}: %v\n", play.Track.SpotifyId, play.At, user.Id.String(), err)
-			return err
+	inserted := 0
+	err := pgx.BeginFunc(context.Background(), database.pool, func(tx pgx.Tx) error {
+		results := tx.SendBatch(context.Background(), batch)
+		defer results.Close()
+
+		for _, play := range plays {
+			tag, err := results.Exec()
+			if err != nil {
+				log.Printf("SavePlays: error saving play {%s}@{%v} for {%v}: %v\n", play.Track.SpotifyId, play.At, user.Id.String(), err)
+				return err
+			}
+
+			inserted += int(tag.RowsAffected())
 		}
+
+		return results.Close()
+	})
+
+	if err != nil {
+		return 0, err
 	}
 
-	return nil
+	return inserted, nil
 }
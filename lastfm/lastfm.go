@@ -1,24 +1,131 @@
-// the lastfm package deals ONLY with fetching and exporting
-// a lastfm user's COMPLETE list of all lifetime scrobbles
+// the lastfm package deals with fetching a lastfm user's scrobbles,
+// and with submitting new ones on behalf of users that authorized the application
 package lastfm
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
 	"strconv"
+	"sync"
 	"time"
 )
 
-const apiEndpoint = "http://ws.audioscrobbler.com/2.0/"
+const DefaultBaseUrl = "https://ws.audioscrobbler.com/2.0/"
 const apiResponseMaxLimit = 200
 
+// Codes of errors returned by the API, see https://www.last.fm/api/errorcodes
+const (
+	ErrorCodeInvalidParameters = 6
+	ErrorCodeOperationFailed   = 8
+	ErrorCodeInvalidSessionKey = 9
+	ErrorCodeServiceOffline    = 11
+	ErrorCodeTemporaryError    = 16
+	ErrorCodeRateLimitExceeded = 29
+)
+
+// An error returned by the Last.fm API.
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf("last.fm error %v: %v", err.Code, err.Message)
+}
+
+// Whether the request that failed with the error may succeed if retried.
+func (err *Error) Temporary() bool {
+	switch err.Code {
+	case ErrorCodeOperationFailed, ErrorCodeServiceOffline, ErrorCodeTemporaryError, ErrorCodeRateLimitExceeded:
+		return true
+	}
+
+	return false
+}
+
+// An error response from the API that isn't in its JSON format, e.g. from a proxy in front of it.
+type httpError struct {
+	status int
+}
+
+func (err httpError) Error() string {
+	return fmt.Sprintf("last.fm http status %v", err.status)
+}
+
+// A client of the Last.fm API. The zero value isn't usable, use NewClient().
+type Client struct {
+	ApiKey string
+	// shared secret of the application, needed for signed calls, i.e. authentication and scrobbling
+	Secret string
+
+	// the endpoints can be pointed at a stand-in of Last.fm, e.g. for testing
+	BaseUrl    string
+	AuthUrl    string
+	HTTPClient *http.Client
+
+	// maximum number of pages of a user's scrobbles fetched at once
+	Concurrency int
+
+	// failed requests are retried up to MaxRetries times if the failure is temporary, waiting
+	// RetryDelay, doubled after every attempt, in between attempts
+	MaxRetries int
+	RetryDelay time.Duration
+}
+
+// Return a client of the API at DefaultBaseUrl using the given API key and shared secret. The secret
+// may be empty if no signed calls are made.
+func NewClient(apiKey, secret string) *Client {
+	return &Client{
+		ApiKey:      apiKey,
+		Secret:      secret,
+		BaseUrl:     DefaultBaseUrl,
+		AuthUrl:     DefaultAuthUrl,
+		HTTPClient:  &http.Client{Timeout: 30 * time.Second},
+		Concurrency: 4,
+		MaxRetries:  3,
+		RetryDelay:  time.Second,
+	}
+}
+
+// Return a client configured by the MUSICDASH_LASTFM_API_KEY and MUSICDASH_LASTFM_SECRET environment
+// variables, with the endpoints optionally overridden by MUSICDASH_LASTFM_API_URL and
+// MUSICDASH_LASTFM_AUTH_URL, or nil if MUSICDASH_LASTFM_API_KEY isn't set.
+func FromEnv() *Client {
+	apiKey := os.Getenv("MUSICDASH_LASTFM_API_KEY")
+	if apiKey == "" {
+		return nil
+	}
+
+	client := NewClient(apiKey, os.Getenv("MUSICDASH_LASTFM_SECRET"))
+
+	if baseUrl := os.Getenv("MUSICDASH_LASTFM_API_URL"); baseUrl != "" {
+		client.BaseUrl = baseUrl
+	}
+
+	if authUrl := os.Getenv("MUSICDASH_LASTFM_AUTH_URL"); authUrl != "" {
+		client.AuthUrl = authUrl
+	}
+
+	return client
+}
+
+// A single scrobble. MBIDs (MusicBrainz identifiers) are empty if Last.fm doesn't know them.
 type Play struct {
 	Title     string
 	Album     string
 	Artist    string
 	Timestamp time.Time
+
+	TrackMBID  string
+	AlbumMBID  string
+	ArtistMBID string
 }
 
 type recentTracks struct {
@@ -26,16 +133,23 @@ type recentTracks struct {
 		Track []struct {
 			Artist struct {
 				Name string `json:"#text"`
+				Mbid string
 			}
 			Album struct {
 				Name string `json:"#text"`
+				Mbid string
 			}
 
 			Date struct {
 				Uts string
 			}
 
+			Attr struct {
+				NowPlaying string `json:"nowplaying"`
+			} `json:"@attr"`
+
 			Title string `json:"name"`
+			Mbid  string
 		}
 		Attr struct {
 			Total      int `json:"total,string"`
@@ -46,84 +160,224 @@ type recentTracks struct {
 	} `json:"recenttracks"`
 }
 
-func GetAllPlays(api_key, username string) ([]Play, error) {
-	query := url.Values{
-		"method":  {"user.getrecenttracks"},
-		"user":    {url.QueryEscape(username)},
-		"format":  {"json"},
-		"limit":   {strconv.Itoa(apiResponseMaxLimit)},
-		"api_key": {api_key},
-		"page":    {"1"},
+func (data *recentTracks) plays() []Play {
+	plays := make([]Play, 0, len(data.Recenttracks.Track))
+	for _, track := range data.Recenttracks.Track {
+		// the currently playing track, listed first, isn't a scrobble yet
+		if track.Attr.NowPlaying == "true" {
+			continue
+		}
+
+		unixTimestamp, err := strconv.ParseInt(track.Date.Uts, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		plays = append(plays, Play{
+			Title:      track.Title,
+			Artist:     track.Artist.Name,
+			Album:      track.Album.Name,
+			Timestamp:  time.Unix(unixTimestamp, 0),
+			TrackMBID:  track.Mbid,
+			AlbumMBID:  track.Album.Mbid,
+			ArtistMBID: track.Artist.Mbid,
+		})
+	}
+
+	return plays
+}
+
+// Whether a request that failed with err should be retried.
+func retryable(err error) bool {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Temporary()
+	}
+
+	var statusErr httpError
+	if errors.As(err, &statusErr) {
+		return statusErr.status >= 500 || statusErr.status == http.StatusTooManyRequests
+	}
+
+	// network errors, other than cancellation
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
 	}
 
-	req, err := http.NewRequest("GET", apiEndpoint+"?"+query.Encode(), nil)
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// Perform a single GET request of an API method, decoding the response into decodeTo. Error responses
+// are returned as *Error.
+func (client *Client) get(ctx context.Context, query url.Values, decodeTo any) error {
+	query.Set("api_key", client.ApiKey)
+	query.Set("format", "json")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, client.BaseUrl+"?"+query.Encode(), nil)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	response, err := http.DefaultClient.Do(req)
+	return client.do(req, decodeTo)
+}
+
+// Perform a request, decoding the response into decodeTo. Error responses are returned as *Error.
+func (client *Client) do(req *http.Request, decodeTo any) error {
+	response, err := client.HTTPClient.Do(req)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	defer response.Body.Close()
 
-	var apiResponse recentTracks
+	var body json.RawMessage
+	if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+		if response.StatusCode != http.StatusOK {
+			return httpError{response.StatusCode}
+		}
 
-	if err := json.NewDecoder(response.Body).Decode(&apiResponse); err != nil {
-		return nil, err
+		return err
 	}
 
-	fmt.Printf("totalPages: %v, perPage: %v", apiResponse.Recenttracks.Attr.TotalPages, apiResponse.Recenttracks.Attr.PerPage)
-	plays := make([]Play, 0, apiResponse.Recenttracks.Attr.Total)
+	var apiErr struct {
+		Error   int    `json:"error"`
+		Message string `json:"message"`
+	}
 
-	consumePlays := func(data *recentTracks) {
-		for _, track := range data.Recenttracks.Track {
-			unixtimetamp, err := strconv.Atoi(track.Date.Uts)
-			if err != nil {
-				continue
-			}
+	if err := json.Unmarshal(body, &apiErr); err == nil && apiErr.Error != 0 {
+		return &Error{Code: apiErr.Error, Message: apiErr.Message}
+	}
 
-			plays = append(plays, Play{
-				Title:     track.Title,
-				Artist:    track.Artist.Name,
-				Album:     track.Album.Name,
-				Timestamp: time.Unix(int64(unixtimetamp), 0),
-			})
+	if response.StatusCode != http.StatusOK {
+		return httpError{response.StatusCode}
+	}
+
+	return json.Unmarshal(body, decodeTo)
+}
+
+// Call fn, retrying it while it fails with a retryable error, up to client.MaxRetries times.
+func (client *Client) withRetries(ctx context.Context, fn func() error) error {
+	delay := client.RetryDelay
+
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= client.MaxRetries || !retryable(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
 		}
+
+		delay *= 2
+	}
+}
+
+// Get a single page of a user's scrobbles.
+func (client *Client) getPlaysPage(ctx context.Context, query url.Values, page int) (recentTracks, error) {
+	pageQuery := url.Values{}
+	for key, value := range query {
+		pageQuery[key] = value
 	}
 
-	// consume initial first page of results
-	consumePlays(&apiResponse)
+	pageQuery.Set("page", strconv.Itoa(page))
+
+	var data recentTracks
+	err := client.withRetries(ctx, func() error {
+		return client.get(ctx, pageQuery, &data)
+	})
 
-	if apiResponse.Recenttracks.Attr.TotalPages == 1 {
-		// only one page of tracks available on account
-		return plays, nil
+	return data, err
+}
+
+// Get all scrobbles of a Last.fm user scrobbled after from and before to, most recent first. A zero
+// from or to leaves the range unbounded on that side, so that passing the time of the most recent
+// scrobble seen so far as from fetches only newer ones. If to is zero, the range is bounded by the time
+// of the call anyway, so that scrobbles submitted meanwhile don't shift the pages being fetched. Pages
+// are fetched client.Concurrency at a time.
+func (client *Client) GetPlays(ctx context.Context, username string, from, to time.Time) ([]Play, error) {
+	if to.IsZero() {
+		to = time.Now()
 	}
 
-	// consume the rest of the pages
-	for currentPageForRequest := 2; currentPageForRequest <= apiResponse.Recenttracks.Attr.TotalPages; currentPageForRequest++ {
-		query.Set("page", strconv.Itoa(currentPageForRequest))
+	query := url.Values{
+		"method":   {"user.getrecenttracks"},
+		"user":     {username},
+		"limit":    {strconv.Itoa(apiResponseMaxLimit)},
+		"extended": {"0"},
+		"to":       {strconv.FormatInt(to.Unix(), 10)},
+	}
 
-		req, err := http.NewRequest("GET", apiEndpoint+"?"+query.Encode(), nil)
-		if err != nil {
-			return nil, err
+	if !from.IsZero() {
+		query.Set("from", strconv.FormatInt(from.Unix(), 10))
+	}
+
+	first, err := client.getPlaysPage(ctx, query, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	totalPages := first.Recenttracks.Attr.TotalPages
+	pages := make([][]Play, max(totalPages, 1))
+	pages[0] = first.plays()
+
+	if totalPages > 1 {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		var wg sync.WaitGroup
+		var errOnce sync.Once
+		var firstErr error
+
+		pageNumbers := make(chan int)
+		for range max(client.Concurrency, 1) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				for page := range pageNumbers {
+					data, err := client.getPlaysPage(ctx, query, page)
+					if err != nil {
+						errOnce.Do(func() {
+							firstErr = err
+							cancel()
+						})
+
+						continue
+					}
+
+					pages[page-1] = data.plays()
+				}
+			}()
 		}
 
-		response, err := http.DefaultClient.Do(req)
-		if err != nil {
-			return nil, err
+	feed:
+		for page := 2; page <= totalPages; page++ {
+			select {
+			case pageNumbers <- page:
+			case <-ctx.Done():
+				break feed
+			}
 		}
 
-		defer response.Body.Close()
+		close(pageNumbers)
+		wg.Wait()
 
-		var apiResponse recentTracks
+		if firstErr != nil {
+			return nil, firstErr
+		}
 
-		if err := json.NewDecoder(response.Body).Decode(&apiResponse); err != nil {
+		if err := ctx.Err(); err != nil {
 			return nil, err
 		}
+	}
 
-		consumePlays(&apiResponse)
+	plays := make([]Play, 0, first.Recenttracks.Attr.Total)
+	for _, page := range pages {
+		plays = append(plays, page...)
 	}
 
 	return plays, nil
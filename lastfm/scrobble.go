@@ -0,0 +1,194 @@
+package lastfm
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DefaultAuthUrl = "https://www.last.fm/api/auth/"
+
+// maximum number of scrobbles submitted in a single track.scrobble call
+const MaxScrobblesPerRequest = 50
+
+var ErrTooManyScrobbles = errors.New("too many scrobbles in a single request")
+var ErrNoSecret = errors.New("no shared secret configured for signed calls")
+
+// A Last.fm user's session of the application, obtained through the web authentication flow. The
+// session key doesn't expire unless the user revokes the application's access.
+type Session struct {
+	Username string
+	Key      string
+}
+
+// A play to be submitted to a user's Last.fm profile. Album, AlbumArtist, Duration and TrackMBID are
+// optional.
+type Scrobble struct {
+	Artist    string
+	Track     string
+	Timestamp time.Time
+
+	Album       string
+	AlbumArtist string
+	Duration    time.Duration
+	TrackMBID   string
+}
+
+// The outcome of submitting a batch of scrobbles. Ignored scrobbles, e.g. ones too far in the past,
+// were rejected by Last.fm and won't be accepted if submitted again.
+type ScrobbleResult struct {
+	Accepted int
+	Ignored  int
+}
+
+// An integer that the API encodes either as a JSON number or as a string.
+type flexInt int
+
+func (n *flexInt) UnmarshalJSON(data []byte) error {
+	parsed, err := strconv.Atoi(strings.Trim(string(data), `"`))
+	if err != nil {
+		return err
+	}
+
+	*n = flexInt(parsed)
+	return nil
+}
+
+// Compute the signature of a call with the given parameters, which is the md5 hash of all parameters,
+// other than format and callback, concatenated as name and value in order of name, followed by the
+// application's shared secret.
+func (client *Client) sign(params url.Values) string {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		if name != "format" && name != "callback" {
+			names = append(names, name)
+		}
+	}
+
+	slices.Sort(names)
+
+	var builder strings.Builder
+	for _, name := range names {
+		builder.WriteString(name)
+		builder.WriteString(params.Get(name))
+	}
+
+	builder.WriteString(client.Secret)
+
+	hash := md5.Sum([]byte(builder.String()))
+	return hex.EncodeToString(hash[:])
+}
+
+// Perform a signed POST request of an API method, retrying temporary failures, and decode the response
+// into decodeTo.
+func (client *Client) postSigned(ctx context.Context, params url.Values, decodeTo any) error {
+	if client.Secret == "" {
+		return ErrNoSecret
+	}
+
+	params.Set("api_key", client.ApiKey)
+	params.Set("api_sig", client.sign(params))
+	params.Set("format", "json")
+
+	return client.withRetries(ctx, func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, client.BaseUrl, strings.NewReader(params.Encode()))
+		if err != nil {
+			return err
+		}
+
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		return client.do(req, decodeTo)
+	})
+}
+
+// Return the url that a user should be sent to in order to authorize the application. Last.fm then
+// redirects the user to callback with a "token" query parameter appended, to be passed to GetSession().
+func (client *Client) AuthorizationUrl(callback string) string {
+	return client.AuthUrl + "?" + url.Values{
+		"api_key": {client.ApiKey},
+		"cb":      {callback},
+	}.Encode()
+}
+
+// Exchange a token of an authorization, see AuthorizationUrl(), for a session of the authorizing user.
+func (client *Client) GetSession(ctx context.Context, token string) (Session, error) {
+	var response struct {
+		Session struct {
+			Name string `json:"name"`
+			Key  string `json:"key"`
+		} `json:"session"`
+	}
+
+	err := client.postSigned(ctx, url.Values{"method": {"auth.getSession"}, "token": {token}}, &response)
+	if err != nil {
+		return Session{}, err
+	}
+
+	return Session{Username: response.Session.Name, Key: response.Session.Key}, nil
+}
+
+// Submit up to MaxScrobblesPerRequest scrobbles to the profile of the user whose session key is given.
+func (client *Client) Scrobble(ctx context.Context, sessionKey string, scrobbles []Scrobble) (ScrobbleResult, error) {
+	if len(scrobbles) > MaxScrobblesPerRequest {
+		return ScrobbleResult{}, ErrTooManyScrobbles
+	}
+
+	if len(scrobbles) == 0 {
+		return ScrobbleResult{}, nil
+	}
+
+	params := url.Values{
+		"method": {"track.scrobble"},
+		"sk":     {sessionKey},
+	}
+
+	for idx, scrobble := range scrobbles {
+		suffix := "[" + strconv.Itoa(idx) + "]"
+
+		params.Set("artist"+suffix, scrobble.Artist)
+		params.Set("track"+suffix, scrobble.Track)
+		params.Set("timestamp"+suffix, strconv.FormatInt(scrobble.Timestamp.Unix(), 10))
+
+		if scrobble.Album != "" {
+			params.Set("album"+suffix, scrobble.Album)
+		}
+
+		if scrobble.AlbumArtist != "" {
+			params.Set("albumArtist"+suffix, scrobble.AlbumArtist)
+		}
+
+		if scrobble.Duration > 0 {
+			params.Set("duration"+suffix, strconv.Itoa(int(scrobble.Duration.Seconds())))
+		}
+
+		if scrobble.TrackMBID != "" {
+			params.Set("mbid"+suffix, scrobble.TrackMBID)
+		}
+	}
+
+	var response struct {
+		Scrobbles struct {
+			Attr struct {
+				Accepted flexInt `json:"accepted"`
+				Ignored  flexInt `json:"ignored"`
+			} `json:"@attr"`
+		} `json:"scrobbles"`
+	}
+
+	if err := client.postSigned(ctx, params, &response); err != nil {
+		return ScrobbleResult{}, err
+	}
+
+	return ScrobbleResult{
+		Accepted: int(response.Scrobbles.Attr.Accepted),
+		Ignored:  int(response.Scrobbles.Attr.Ignored),
+	}, nil
+}
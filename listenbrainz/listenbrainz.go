@@ -0,0 +1,388 @@
+// The listenbrainz package is a client of the ListenBrainz API, used for importing a user's listens
+// and for submitting new ones on behalf of users that provided their user token. The payload types of
+// the submit-listens API are exported, so that compatible submissions can be accepted as well.
+package listenbrainz
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DefaultBaseUrl = "https://api.listenbrainz.org/"
+
+// maximum number of listens returned by a single request for a user's listens
+const MaxListensPerPage = 1000
+
+// maximum number of listens submitted in a single request
+const MaxListensPerSubmission = 1000
+
+// Types of submissions: a single listen that just finished, the track that started playing, or
+// a batch of past listens.
+const (
+	ListenTypeSingle     = "single"
+	ListenTypePlayingNow = "playing_now"
+	ListenTypeImport     = "import"
+)
+
+var ErrTooManyListens = errors.New("too many listens in a single submission")
+
+// An error returned by the ListenBrainz API.
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf("listenbrainz error %v: %v", err.Code, err.Message)
+}
+
+// Whether the request that failed with the error may succeed if retried.
+func (err *Error) Temporary() bool {
+	return err.Code == http.StatusTooManyRequests || err.Code >= 500
+}
+
+// A client of the ListenBrainz API. The zero value isn't usable, use NewClient().
+type Client struct {
+	// base url of the API, e.g. DefaultBaseUrl or the address of a local stand-in
+	BaseUrl    string
+	HTTPClient *http.Client
+
+	// failed requests are retried up to MaxRetries times if the failure is temporary, waiting
+	// RetryDelay, doubled after every attempt, in between attempts
+	MaxRetries int
+	RetryDelay time.Duration
+}
+
+// Create a new *Client of the API at baseUrl. If baseUrl is empty, DefaultBaseUrl is used.
+func NewClient(baseUrl string) *Client {
+	if baseUrl == "" {
+		baseUrl = DefaultBaseUrl
+	}
+
+	if !strings.HasSuffix(baseUrl, "/") {
+		baseUrl += "/"
+	}
+
+	return &Client{
+		BaseUrl:    baseUrl,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		MaxRetries: 3,
+		RetryDelay: time.Second,
+	}
+}
+
+// Return a client of the API at MUSICDASH_LISTENBRAINZ_API_URL, or at DefaultBaseUrl if it isn't set.
+func FromEnv() *Client {
+	return NewClient(os.Getenv("MUSICDASH_LISTENBRAINZ_API_URL"))
+}
+
+// Additional metadata of a listen. All fields are optional.
+type AdditionalInfo struct {
+	RecordingMBID    string   `json:"recording_mbid,omitempty"`
+	ReleaseMBID      string   `json:"release_mbid,omitempty"`
+	ArtistMBIDs      []string `json:"artist_mbids,omitempty"`
+	Isrc             string   `json:"isrc,omitempty"`
+	DurationMs       int64    `json:"duration_ms,omitempty"`
+	SpotifyId        string   `json:"spotify_id,omitempty"`
+	OriginUrl        string   `json:"origin_url,omitempty"`
+	MediaPlayer      string   `json:"media_player,omitempty"`
+	SubmissionClient string   `json:"submission_client,omitempty"`
+	MusicService     string   `json:"music_service,omitempty"`
+}
+
+type TrackMetadata struct {
+	ArtistName     string          `json:"artist_name"`
+	TrackName      string          `json:"track_name"`
+	ReleaseName    string          `json:"release_name,omitempty"`
+	AdditionalInfo *AdditionalInfo `json:"additional_info,omitempty"`
+}
+
+// A single listen, as submitted and returned by the API. ListenedAt is absent for "playing_now" submissions.
+type ListenPayload struct {
+	ListenedAt    int64         `json:"listened_at,omitempty"`
+	TrackMetadata TrackMetadata `json:"track_metadata"`
+}
+
+// The body of a request of the submit-listens API.
+type SubmitPayload struct {
+	ListenType string          `json:"listen_type"`
+	Payload    []ListenPayload `json:"payload"`
+}
+
+// A listen of a user, converted from its ListenPayload.
+type Listen struct {
+	ListenedAt time.Time
+	Artist     string
+	Release    string
+	Track      string
+
+	// empty if unknown
+	RecordingMBID string
+	ReleaseMBID   string
+	Isrc          string
+	// Spotify id of the track, if the listen was submitted from Spotify
+	SpotifyId string
+	Duration  time.Duration
+}
+
+// Extract the Spotify track id from a Spotify track url or uri, or return the empty string if s isn't one.
+func spotifyTrackId(s string) string {
+	if id, ok := strings.CutPrefix(s, "spotify:track:"); ok {
+		return id
+	}
+
+	parsed, err := url.Parse(s)
+	if err != nil || parsed.Host != "open.spotify.com" {
+		return ""
+	}
+
+	if id, ok := strings.CutPrefix(parsed.Path, "/track/"); ok {
+		return id
+	}
+
+	return ""
+}
+
+// Convert a listen from its payload format.
+func (payload ListenPayload) Listen() Listen {
+	listen := Listen{
+		ListenedAt: time.Unix(payload.ListenedAt, 0),
+		Artist:     payload.TrackMetadata.ArtistName,
+		Release:    payload.TrackMetadata.ReleaseName,
+		Track:      payload.TrackMetadata.TrackName,
+	}
+
+	if info := payload.TrackMetadata.AdditionalInfo; info != nil {
+		listen.RecordingMBID = info.RecordingMBID
+		listen.ReleaseMBID = info.ReleaseMBID
+		listen.Isrc = info.Isrc
+		listen.SpotifyId = spotifyTrackId(info.SpotifyId)
+		listen.Duration = time.Duration(info.DurationMs) * time.Millisecond
+	}
+
+	return listen
+}
+
+// Whether a request that failed with err should be retried.
+func retryable(err error) bool {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Temporary()
+	}
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// Perform a request of an API endpoint, retrying temporary failures, and decode the response into
+// decodeTo, which may be nil. body is JSON-encoded, unless nil. token, if not empty, authenticates
+// the request as its user's. Error responses are returned as *Error.
+func (client *Client) request(ctx context.Context, method, path string, query url.Values, token string, body any, decodeTo any) error {
+	endpoint := client.BaseUrl + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	var encodedBody []byte
+	if body != nil {
+		var err error
+		if encodedBody, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	delay := client.RetryDelay
+	for attempt := 0; ; attempt++ {
+		err := client.do(ctx, method, endpoint, token, encodedBody, decodeTo)
+		if err == nil || attempt >= client.MaxRetries || !retryable(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+	}
+}
+
+func (client *Client) do(ctx context.Context, method, endpoint, token string, body []byte, decodeTo any) error {
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, bodyReader)
+	if err != nil {
+		return err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if token != "" {
+		req.Header.Set("Authorization", "Token "+token)
+	}
+
+	response, err := client.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Code  int    `json:"code"`
+			Error string `json:"error"`
+		}
+
+		if err := json.NewDecoder(response.Body).Decode(&apiErr); err != nil || apiErr.Code == 0 {
+			apiErr.Code = response.StatusCode
+		}
+
+		return &Error{Code: apiErr.Code, Message: apiErr.Error}
+	}
+
+	if decodeTo == nil {
+		return nil
+	}
+
+	return json.NewDecoder(response.Body).Decode(decodeTo)
+}
+
+// Check a user token, returning the name of the user it belongs to, or the empty string if it's invalid.
+func (client *Client) ValidateToken(ctx context.Context, token string) (string, error) {
+	var response struct {
+		Valid    bool   `json:"valid"`
+		UserName string `json:"user_name"`
+	}
+
+	if err := client.request(ctx, http.MethodGet, "1/validate-token", nil, token, nil, &response); err != nil {
+		var apiErr *Error
+		if errors.As(err, &apiErr) && apiErr.Code == http.StatusUnauthorized {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	if !response.Valid {
+		return "", nil
+	}
+
+	return response.UserName, nil
+}
+
+// Get the total number of listens of a user.
+func (client *Client) GetListenCount(ctx context.Context, username string) (int, error) {
+	var response struct {
+		Payload struct {
+			Count int `json:"count"`
+		} `json:"payload"`
+	}
+
+	err := client.request(ctx, http.MethodGet, "1/user/"+url.PathEscape(username)+"/listen-count", nil, "", nil, &response)
+	return response.Payload.Count, err
+}
+
+// Get up to count of a user's listens before maxTs, most recent first. A zero maxTs gets the most
+// recent listens.
+func (client *Client) GetListens(ctx context.Context, username string, maxTs time.Time, count int) ([]Listen, error) {
+	query := url.Values{"count": {strconv.Itoa(count)}}
+	if !maxTs.IsZero() {
+		query.Set("max_ts", strconv.FormatInt(maxTs.Unix(), 10))
+	}
+
+	var response struct {
+		Payload struct {
+			Listens []ListenPayload `json:"listens"`
+		} `json:"payload"`
+	}
+
+	err := client.request(ctx, http.MethodGet, "1/user/"+url.PathEscape(username)+"/listens", query, "", nil, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	listens := make([]Listen, len(response.Payload.Listens))
+	for idx, payload := range response.Payload.Listens {
+		listens[idx] = payload.Listen()
+	}
+
+	return listens, nil
+}
+
+// Call fn with consecutive pages of all of a user's listens, most recent first, paginating by max_ts.
+// Iteration stops at the first error returned by fn, which is then returned.
+func (client *Client) ForEachListenPage(ctx context.Context, username string, fn func([]Listen) error) error {
+	var maxTs time.Time
+
+	for {
+		listens, err := client.GetListens(ctx, username, maxTs, MaxListensPerPage)
+		if err != nil {
+			return err
+		}
+
+		if len(listens) == 0 {
+			return nil
+		}
+
+		// max_ts is exclusive, so continuing before the second of the page's last listen would skip
+		// any listens in that second that didn't fit onto the page. Those are left for the next page
+		// instead, unless the whole page is within a single second.
+		full := len(listens) == MaxListensPerPage
+		if full {
+			cut := len(listens)
+			for cut > 0 && listens[cut-1].ListenedAt.Equal(listens[len(listens)-1].ListenedAt) {
+				cut--
+			}
+
+			if cut > 0 {
+				listens = listens[:cut]
+			}
+		}
+
+		if err := fn(listens); err != nil {
+			return err
+		}
+
+		if !full {
+			return nil
+		}
+
+		maxTs = listens[len(listens)-1].ListenedAt
+	}
+}
+
+// Submit listens of the user whose token is given, with listenType being one of the ListenType* constants.
+func (client *Client) SubmitListens(ctx context.Context, token string, listenType string, listens []ListenPayload) error {
+	if len(listens) > MaxListensPerSubmission {
+		return ErrTooManyListens
+	}
+
+	if len(listens) == 0 {
+		return nil
+	}
+
+	payload := SubmitPayload{ListenType: listenType, Payload: listens}
+	return client.request(ctx, http.MethodPost, "1/submit-listens", nil, token, payload, nil)
+}
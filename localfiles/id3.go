@@ -0,0 +1,482 @@
+package localfiles
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+var errInvalidID3 = errors.New("invalid id3v2 tag")
+
+// ID3v2.2 uses three character frame ids, map the ones of interest to their v2.3/v2.4 counterparts
+var id3v22Frames = map[string]string{
+	"TT2": "TIT2",
+	"TP1": "TPE1",
+	"TP2": "TPE2",
+	"TAL": "TALB",
+	"TRK": "TRCK",
+	"TPA": "TPOS",
+	"TRC": "TSRC",
+	"TYE": "TYER",
+	"TLE": "TLEN",
+	"TCP": "TCMP",
+	"PIC": "APIC",
+}
+
+// Read an MP3 file's ID3v2 tag, if present, and determine its duration from the first MPEG
+// audio frame following the tag.
+func readMP3(file *os.File, withPicture bool) (tags, error) {
+	var fileTags tags
+
+	audioStart, err := readID3v2(file, &fileTags, withPicture)
+	if err != nil {
+		return tags{}, err
+	}
+
+	// TLEN is rarely present and not always accurate, prefer the audio stream itself
+	if duration, err := mp3Duration(file, audioStart); err == nil {
+		fileTags.Duration = duration
+	}
+
+	return fileTags, nil
+}
+
+func synchsafe(b []byte) int {
+	return int(b[0]&0x7f)<<21 | int(b[1]&0x7f)<<14 | int(b[2]&0x7f)<<7 | int(b[3]&0x7f)
+}
+
+// undo the unsynchronisation scheme, which inserts a zero byte after every 0xff
+func removeUnsync(data []byte) []byte {
+	return bytes.ReplaceAll(data, []byte{0xff, 0x00}, []byte{0xff})
+}
+
+// Parse the ID3v2 tag at the start of the reader into fileTags. Returns the offset of the first
+// byte following the tag, which is 0 if the file has no ID3v2 tag.
+func readID3v2(reader io.ReadSeeker, fileTags *tags, withPicture bool) (int64, error) {
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return 0, err
+	}
+
+	if string(header[:3]) != "ID3" {
+		return 0, nil
+	}
+
+	version := header[3]
+	flags := header[5]
+	size := synchsafe(header[6:10])
+
+	tag := make([]byte, size)
+	if _, err := io.ReadFull(reader, tag); err != nil {
+		return 0, err
+	}
+
+	audioStart := int64(10 + size)
+	// a footer duplicates the header at the end of the tag
+	if version == 4 && flags&0x10 != 0 {
+		audioStart += 10
+	}
+
+	if version < 2 || version > 4 {
+		return audioStart, errInvalidID3
+	}
+
+	if version < 4 && flags&0x80 != 0 {
+		tag = removeUnsync(tag)
+	}
+
+	// skip the extended header
+	if version > 2 && flags&0x40 != 0 && len(tag) >= 4 {
+		var extSize int
+		if version == 3 {
+			extSize = int(binary.BigEndian.Uint32(tag[:4])) + 4
+		} else {
+			extSize = synchsafe(tag[:4])
+		}
+
+		if extSize > len(tag) {
+			return audioStart, errInvalidID3
+		}
+
+		tag = tag[extSize:]
+	}
+
+	idLen, headerLen := 4, 10
+	if version == 2 {
+		idLen, headerLen = 3, 6
+	}
+
+	var (
+		pictureType = -1
+		tlen        string
+		year        string
+	)
+
+	for len(tag) >= headerLen {
+		// padding
+		if tag[0] == 0 {
+			break
+		}
+
+		frameId := string(tag[:idLen])
+
+		var frameSize int
+		var frameFlags uint16
+		switch version {
+		case 2:
+			frameSize = int(tag[3])<<16 | int(tag[4])<<8 | int(tag[5])
+			frameId = id3v22Frames[frameId]
+		case 3:
+			frameSize = int(binary.BigEndian.Uint32(tag[4:8]))
+			frameFlags = binary.BigEndian.Uint16(tag[8:10])
+		case 4:
+			frameSize = synchsafe(tag[4:8])
+			frameFlags = binary.BigEndian.Uint16(tag[8:10])
+		}
+
+		if frameSize < 0 || headerLen+frameSize > len(tag) {
+			break
+		}
+
+		frame := tag[headerLen : headerLen+frameSize]
+		tag = tag[headerLen+frameSize:]
+
+		// compressed and encrypted frames aren't supported
+		if (version == 3 && frameFlags&0x00c0 != 0) || (version == 4 && frameFlags&0x000c != 0) {
+			continue
+		}
+
+		if version == 4 {
+			if frameFlags&0x0002 != 0 {
+				frame = removeUnsync(frame)
+			}
+
+			// data length indicator
+			if frameFlags&0x0001 != 0 && len(frame) >= 4 {
+				frame = frame[4:]
+			}
+		}
+
+		if len(frame) == 0 {
+			continue
+		}
+
+		switch frameId {
+		case "TIT2":
+			fileTags.Title = firstOf(decodeTextFrame(frame))
+		case "TPE1":
+			fileTags.Artists = splitID3Artists(decodeTextFrame(frame))
+		case "TPE2":
+			fileTags.AlbumArtist = firstOf(decodeTextFrame(frame))
+		case "TALB":
+			fileTags.Album = firstOf(decodeTextFrame(frame))
+		case "TRCK":
+			fileTags.TrackNum = parseNumber(firstOf(decodeTextFrame(frame)))
+		case "TPOS":
+			fileTags.DiscNum = parseNumber(firstOf(decodeTextFrame(frame)))
+		case "TSRC":
+			fileTags.Isrc = strings.ToUpper(strings.ReplaceAll(firstOf(decodeTextFrame(frame)), "-", ""))
+		case "TDRC", "TDOR":
+			if fileTags.Date == "" || frameId == "TDRC" {
+				fileTags.Date = firstOf(decodeTextFrame(frame))
+			}
+		case "TYER":
+			year = firstOf(decodeTextFrame(frame))
+		case "TLEN":
+			tlen = firstOf(decodeTextFrame(frame))
+		case "TCMP":
+			fileTags.Compilation = parseFlag(firstOf(decodeTextFrame(frame)))
+		case "APIC":
+			fileTags.HasPicture = true
+			if !withPicture {
+				continue
+			}
+
+			mimeType, picType, data, ok := decodePictureFrame(frame, version == 2)
+			if !ok {
+				continue
+			}
+
+			// prefer the front cover (type 3) over any other picture
+			if pictureType == 3 || (pictureType != -1 && picType != 3) {
+				continue
+			}
+
+			pictureType = int(picType)
+			fileTags.Picture = &picture{MimeType: mimeType, Data: data}
+		}
+	}
+
+	if fileTags.Date == "" {
+		fileTags.Date = year
+	}
+
+	if ms, err := strconv.Atoi(strings.TrimSpace(tlen)); err == nil {
+		fileTags.Duration = time.Duration(ms) * time.Millisecond
+	}
+
+	return audioStart, nil
+}
+
+func firstOf(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
+
+// ID3v2.4 separates multiple values with null bytes, older taggers commonly use slashes.
+// Only slashes surrounded by spaces are treated as separators, so that names like "AC/DC" survive.
+func splitID3Artists(values []string) []string {
+	artists := make([]string, 0, len(values))
+	for _, value := range values {
+		for _, artist := range strings.Split(value, " / ") {
+			if artist = strings.TrimSpace(artist); artist != "" {
+				artists = append(artists, artist)
+			}
+		}
+	}
+
+	return artists
+}
+
+// Decode a text information frame into its (possibly multiple) values.
+func decodeTextFrame(frame []byte) []string {
+	text := decodeString(frame[0], frame[1:])
+	values := strings.Split(strings.TrimRight(text, "\x00"), "\x00")
+
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			result = append(result, value)
+		}
+	}
+
+	return result
+}
+
+// Decode a string in one of the four ID3v2 text encodings.
+func decodeString(encoding byte, data []byte) string {
+	switch encoding {
+	case 0:
+		// ISO-8859-1 maps directly onto the first 256 unicode code points
+		runes := make([]rune, len(data))
+		for idx, b := range data {
+			runes[idx] = rune(b)
+		}
+		return string(runes)
+	case 1, 2:
+		bigEndian := encoding == 2
+		if len(data) >= 2 {
+			switch {
+			case data[0] == 0xff && data[1] == 0xfe:
+				bigEndian, data = false, data[2:]
+			case data[0] == 0xfe && data[1] == 0xff:
+				bigEndian, data = true, data[2:]
+			}
+		}
+
+		units := make([]uint16, 0, len(data)/2)
+		for idx := 0; idx+1 < len(data); idx += 2 {
+			if bigEndian {
+				units = append(units, binary.BigEndian.Uint16(data[idx:]))
+			} else {
+				units = append(units, binary.LittleEndian.Uint16(data[idx:]))
+			}
+		}
+
+		// a BOM may follow each null separator of multi-value frames
+		text := string(utf16.Decode(units))
+		return strings.ReplaceAll(text, "\ufeff", "")
+	default:
+		return string(data)
+	}
+}
+
+// Return the length of a null terminated string in the specified encoding, including the terminator.
+func terminatedLen(encoding byte, data []byte) int {
+	if encoding == 1 || encoding == 2 {
+		for idx := 0; idx+1 < len(data); idx += 2 {
+			if data[idx] == 0 && data[idx+1] == 0 {
+				return idx + 2
+			}
+		}
+
+		return len(data)
+	}
+
+	if idx := bytes.IndexByte(data, 0); idx != -1 {
+		return idx + 1
+	}
+
+	return len(data)
+}
+
+// Decode an attached picture frame (APIC, or PIC in ID3v2.2).
+func decodePictureFrame(frame []byte, v22 bool) (mimeType string, pictureType byte, data []byte, ok bool) {
+	encoding := frame[0]
+	frame = frame[1:]
+
+	if v22 {
+		// a three character image format instead of a mime type
+		if len(frame) < 4 {
+			return "", 0, nil, false
+		}
+
+		switch strings.ToUpper(string(frame[:3])) {
+		case "PNG":
+			mimeType = "image/png"
+		default:
+			mimeType = "image/jpeg"
+		}
+
+		frame = frame[3:]
+	} else {
+		mimeLen := terminatedLen(0, frame)
+		mimeType = strings.TrimRight(string(frame[:mimeLen]), "\x00")
+		frame = frame[mimeLen:]
+
+		// "-->" marks a link to an external picture instead of embedded data
+		if mimeType == "-->" {
+			return "", 0, nil, false
+		}
+
+		if mimeType == "" || !strings.Contains(mimeType, "/") {
+			mimeType = "image/" + strings.ToLower(mimeType)
+		}
+	}
+
+	if len(frame) < 1 {
+		return "", 0, nil, false
+	}
+
+	pictureType = frame[0]
+	frame = frame[1:]
+	frame = frame[terminatedLen(encoding, frame):]
+
+	if len(frame) == 0 {
+		return "", 0, nil, false
+	}
+
+	return mimeType, pictureType, frame, true
+}
+
+var mpegBitrates = map[[2]int][]int{
+	// {version (1 = MPEG-1, 2 = MPEG-2 and 2.5), layer}: bitrates in kbps by index
+	{1, 1}: {0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+	{1, 2}: {0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+	{1, 3}: {0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	{2, 1}: {0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+	{2, 2}: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	{2, 3}: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+}
+
+var mpegSampleRates = map[int][]int{
+	// version bits of the frame header: sample rates by index
+	0: {11025, 12000, 8000},  // MPEG-2.5
+	2: {22050, 24000, 16000}, // MPEG-2
+	3: {44100, 48000, 32000}, // MPEG-1
+}
+
+// Determine the duration of an MPEG audio stream starting at audioStart. VBR streams are measured
+// by the frame count of their Xing/Info or VBRI header, CBR streams by their size and bitrate.
+func mp3Duration(file *os.File, audioStart int64) (time.Duration, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := file.Seek(audioStart, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	// look for the first frame sync within the first 64KiB following the tag
+	buf := make([]byte, 64*1024)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return 0, err
+	}
+
+	buf = buf[:n]
+
+	for offset := 0; offset+4 <= len(buf); offset++ {
+		if buf[offset] != 0xff || buf[offset+1]&0xe0 != 0xe0 {
+			continue
+		}
+
+		versionBits := int(buf[offset+1]>>3) & 0x03
+		layerBits := int(buf[offset+1]>>1) & 0x03
+		bitrateIdx := int(buf[offset+2] >> 4)
+		sampleRateIdx := int(buf[offset+2]>>2) & 0x03
+		channelMode := int(buf[offset+3] >> 6)
+
+		if versionBits == 1 || layerBits == 0 || bitrateIdx == 0 || bitrateIdx == 15 || sampleRateIdx == 3 {
+			continue
+		}
+
+		version := 2
+		if versionBits == 3 {
+			version = 1
+		}
+
+		layer := 4 - layerBits
+		bitrate := mpegBitrates[[2]int{version, layer}][bitrateIdx] * 1000
+		sampleRate := mpegSampleRates[versionBits][sampleRateIdx]
+
+		samplesPerFrame := 1152
+		switch {
+		case layer == 1:
+			samplesPerFrame = 384
+		case layer == 3 && version == 2:
+			samplesPerFrame = 576
+		}
+
+		// offset of the Xing/Info header depends on the side information size
+		xingOffset := offset + 4 + 32
+		switch {
+		case version == 1 && channelMode == 3:
+			xingOffset = offset + 4 + 17
+		case version == 2 && channelMode != 3:
+			xingOffset = offset + 4 + 17
+		case version == 2 && channelMode == 3:
+			xingOffset = offset + 4 + 9
+		}
+
+		frames := 0
+		if xingOffset+12 <= len(buf) {
+			tag := string(buf[xingOffset : xingOffset+4])
+			if (tag == "Xing" || tag == "Info") && buf[xingOffset+7]&0x01 != 0 {
+				frames = int(binary.BigEndian.Uint32(buf[xingOffset+8:]))
+			}
+		}
+
+		vbriOffset := offset + 4 + 32
+		if frames == 0 && vbriOffset+18 <= len(buf) && string(buf[vbriOffset:vbriOffset+4]) == "VBRI" {
+			frames = int(binary.BigEndian.Uint32(buf[vbriOffset+14:]))
+		}
+
+		if frames > 0 {
+			return time.Duration(float64(frames) * float64(samplesPerFrame) / float64(sampleRate) * float64(time.Second)), nil
+		}
+
+		audioBytes := info.Size() - audioStart - int64(offset)
+		// an ID3v1 tag occupies the last 128 bytes of the file
+		if info.Size() >= 128 {
+			trailer := make([]byte, 3)
+			if _, err := file.ReadAt(trailer, info.Size()-128); err == nil && string(trailer) == "TAG" {
+				audioBytes -= 128
+			}
+		}
+
+		return time.Duration(float64(audioBytes*8) / float64(bitrate) * float64(time.Second)), nil
+	}
+
+	return 0, errors.New("no mpeg audio frame found")
+}
@@ -0,0 +1,691 @@
+// The localfiles package implements a music.ResourceProvider over a directory of audio files
+// (MP3, FLAC, Ogg Vorbis/Opus and MP4/M4A), built from the metadata in the files' tags.
+//
+// Local resources are identified by ids derived from the file path (tracks), the album artist
+// and title (albums) and the name (artists). The ids are 22 characters long and prefixed with
+// "local:", so that they can be preserved into the catalog alongside Spotify resources without
+// ever colliding with a Spotify id, which never contains a colon.
+package localfiles
+
+import (
+	music "bool3max/musicdash/music"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io/fs"
+	"log"
+	"net/url"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrNotFound = errors.New("resource not found in local library")
+
+const (
+	unknownArtist  = "Unknown Artist"
+	variousArtists = "Various Artists"
+)
+
+// A single audio file of the library, as of the last scan.
+type libraryFile struct {
+	// path relative to the library root, using forward slashes
+	path    string
+	modTime time.Time
+	size    int64
+
+	// tags of the file, without the embedded artwork
+	tags       tags
+	hasPicture bool
+
+	trackId, albumId string
+
+	// whether the file's track has been preserved into the catalog since it was last read
+	preserved bool
+}
+
+// A music.ResourceProvider serving tracks, albums and artists from a directory of audio files.
+// The library is empty until Library.Scan() is called, and reflects the state of the directory
+// as of the last scan.
+type Library struct {
+	root string
+	pool *pgxpool.Pool
+
+	mu sync.RWMutex
+
+	// all files, keyed by their path relative to root
+	files map[string]*libraryFile
+
+	// indices rebuilt after every scan
+	tracks       map[string]*libraryFile
+	albums       map[string][]*libraryFile
+	artists      map[string]string
+	artistAlbums map[string][]string
+
+	// albums on which an artist appears without being the album artist
+	artistAppearances map[string][]string
+}
+
+// Statistics about a single scan of the library directory.
+type ScanResult struct {
+	Added     int
+	Updated   int
+	Removed   int
+	Unchanged int
+	Failed    int
+}
+
+// Create a new Library of the audio files in the directory root (and its subdirectories). If pool
+// is non-nil, tracks that are new or have changed are preserved into the catalog on every scan.
+func NewLibrary(root string, pool *pgxpool.Pool) *Library {
+	return &Library{
+		root:         root,
+		pool:         pool,
+		files:        make(map[string]*libraryFile),
+		tracks:       make(map[string]*libraryFile),
+		albums:       make(map[string][]*libraryFile),
+		artists:      make(map[string]string),
+		artistAlbums: make(map[string][]string),
+
+		artistAppearances: make(map[string][]string),
+	}
+}
+
+// Derive a local id of a resource from the identifying parts, case-insensitively.
+func localId(resourceType music.ResourceType, parts ...string) string {
+	hash := sha1.New()
+	hash.Write([]byte(resourceType))
+
+	for _, part := range parts {
+		hash.Write([]byte{0})
+		hash.Write([]byte(strings.ToLower(strings.TrimSpace(part))))
+	}
+
+	return "local:" + base64.RawURLEncoding.EncodeToString(hash.Sum(nil))[:16]
+}
+
+func (t *tags) albumArtist() string {
+	switch {
+	case t.AlbumArtist != "":
+		return t.AlbumArtist
+	case t.Compilation:
+		return variousArtists
+	case len(t.Artists) > 0:
+		return t.Artists[0]
+	default:
+		return unknownArtist
+	}
+}
+
+func (t *tags) trackArtists() []string {
+	if len(t.Artists) > 0 {
+		return t.Artists
+	}
+
+	return []string{t.albumArtist()}
+}
+
+// Walk the library directory, reading the tags of files that are new or whose size or modification
+// time changed since the last scan, and forgetting files that no longer exist. Files that fail to
+// parse are logged and skipped. Files that fail to be preserved are preserved again by the next scan.
+// Removed files are never removed from the catalog, as recorded plays may still reference them.
+func (lib *Library) Scan(ctx context.Context) (ScanResult, error) {
+	var result ScanResult
+
+	lib.mu.RLock()
+	previous := lib.files
+	lib.mu.RUnlock()
+
+	files := make(map[string]*libraryFile, len(previous))
+	changed := make([]*libraryFile, 0)
+
+	err := filepath.WalkDir(lib.root, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			log.Printf("Library.Scan: error accessing {%s}: %v\n", filePath, err)
+			if entry != nil && entry.IsDir() {
+				return fs.SkipDir
+			}
+
+			return nil
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if entry.IsDir() || !isSupported(filePath) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			log.Printf("Library.Scan: error accessing {%s}: %v\n", filePath, err)
+			return nil
+		}
+
+		relPath, err := filepath.Rel(lib.root, filePath)
+		if err != nil {
+			return err
+		}
+
+		relPath = filepath.ToSlash(relPath)
+
+		existing, existed := previous[relPath]
+		if existed && existing.modTime.Equal(info.ModTime()) && existing.size == info.Size() {
+			files[relPath] = existing
+			result.Unchanged += 1
+
+			// files that failed to be preserved by an earlier scan are retried
+			if lib.pool != nil && !existing.preserved {
+				changed = append(changed, existing)
+			}
+
+			return nil
+		}
+
+		// artwork is only read when needed instead of being kept in memory
+		fileTags, err := readTags(filePath, false)
+		if err != nil {
+			log.Printf("Library.Scan: error reading tags of {%s}: %v\n", filePath, err)
+			result.Failed += 1
+			return nil
+		}
+
+		newFile := &libraryFile{
+			path:       relPath,
+			modTime:    info.ModTime(),
+			size:       info.Size(),
+			tags:       fileTags,
+			hasPicture: fileTags.HasPicture,
+		}
+
+		album := fileTags.Album
+		if album == "" {
+			album = path.Base(path.Dir(relPath))
+		}
+
+		newFile.trackId = localId(music.ResourceTrack, relPath)
+		newFile.albumId = localId(music.ResourceAlbum, fileTags.albumArtist(), album)
+		newFile.tags.Album = album
+
+		files[relPath] = newFile
+		changed = append(changed, newFile)
+
+		if existed {
+			result.Updated += 1
+		} else {
+			result.Added += 1
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return result, err
+	}
+
+	for relPath := range previous {
+		if _, ok := files[relPath]; !ok {
+			result.Removed += 1
+		}
+	}
+
+	lib.mu.Lock()
+	lib.files = files
+	lib.rebuildIndex()
+	lib.mu.Unlock()
+
+	if lib.pool != nil && len(changed) > 0 {
+		if err := lib.preserve(ctx, changed); err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// Rebuild all lookup indices from lib.files. The caller must hold the write lock.
+func (lib *Library) rebuildIndex() {
+	lib.tracks = make(map[string]*libraryFile, len(lib.files))
+	lib.albums = make(map[string][]*libraryFile)
+	lib.artists = make(map[string]string)
+	lib.artistAlbums = make(map[string][]string)
+	lib.artistAppearances = make(map[string][]string)
+
+	for _, file := range lib.files {
+		lib.tracks[file.trackId] = file
+
+		albumArtistId := localId(music.ResourceArtist, file.tags.albumArtist())
+
+		if _, ok := lib.albums[file.albumId]; !ok {
+			lib.artistAlbums[albumArtistId] = append(lib.artistAlbums[albumArtistId], file.albumId)
+		}
+
+		lib.albums[file.albumId] = append(lib.albums[file.albumId], file)
+
+		lib.artists[albumArtistId] = file.tags.albumArtist()
+		for _, name := range file.tags.trackArtists() {
+			artistId := localId(music.ResourceArtist, name)
+			lib.artists[artistId] = name
+
+			if artistId != albumArtistId && !slices.Contains(lib.artistAppearances[artistId], file.albumId) {
+				lib.artistAppearances[artistId] = append(lib.artistAppearances[artistId], file.albumId)
+			}
+		}
+	}
+
+	for _, albumFiles := range lib.albums {
+		slices.SortFunc(albumFiles, func(a, b *libraryFile) int {
+			if a.tags.DiscNum != b.tags.DiscNum {
+				return a.tags.DiscNum - b.tags.DiscNum
+			}
+
+			if a.tags.TrackNum != b.tags.TrackNum {
+				return a.tags.TrackNum - b.tags.TrackNum
+			}
+
+			return strings.Compare(a.path, b.path)
+		})
+	}
+
+	for _, albumIds := range lib.artistAlbums {
+		slices.Sort(albumIds)
+	}
+}
+
+// Preserve the albums and tracks of the specified files into the catalog, marking the files whose tracks
+// were preserved. Preserving stops at the first error, leaving the rest of the files unmarked.
+func (lib *Library) preserve(ctx context.Context, changed []*libraryFile) error {
+	lib.mu.RLock()
+	defer lib.mu.RUnlock()
+
+	preservedAlbums := make(map[string]bool)
+
+	for _, file := range changed {
+		if !preservedAlbums[file.albumId] {
+			album, ok := lib.album(file.albumId, false)
+			if !ok {
+				continue
+			}
+
+			if err := album.Preserve(ctx, lib.pool, false); err != nil {
+				return fmt.Errorf("preserving local album {%s}: %w", file.albumId, err)
+			}
+
+			preservedAlbums[file.albumId] = true
+		}
+
+		track := lib.track(file)
+		if err := track.Preserve(ctx, lib.pool, false); err != nil {
+			return fmt.Errorf("preserving local track {%s}: %w", file.path, err)
+		}
+
+		file.preserved = true
+	}
+
+	return nil
+}
+
+func (lib *Library) artist(name string) music.Artist {
+	artistId := localId(music.ResourceArtist, name)
+
+	return music.Artist{
+		Name:        name,
+		SpotifyId:   artistId,
+		ExternalIds: music.ExternalIds{music.ProviderLocal: artistId},
+	}
+}
+
+// Spotify represents local files using URIs of the form spotify:local:artist:album:title:seconds
+func localURI(artist, album, title string, duration time.Duration) string {
+	return fmt.Sprintf(
+		"spotify:local:%s:%s:%s:%d",
+		url.QueryEscape(artist),
+		url.QueryEscape(album),
+		url.QueryEscape(title),
+		int(duration.Seconds()),
+	)
+}
+
+// Build a music.Track from a library file. The caller must hold the read lock.
+func (lib *Library) track(file *libraryFile) music.Track {
+	artistNames := file.tags.trackArtists()
+	artists := make([]music.Artist, len(artistNames))
+	for idx, name := range artistNames {
+		artists[idx] = lib.artist(name)
+	}
+
+	album, _ := lib.album(file.albumId, false)
+
+	return music.Track{
+		Title:        file.tags.Title,
+		Duration:     file.tags.Duration,
+		TracklistNum: file.tags.TrackNum,
+		DiscNum:      max(file.tags.DiscNum, 1),
+		Album:        album,
+		Artists:      artists,
+		SpotifyId:    file.trackId,
+		SpotifyURI:   localURI(artistNames[0], file.tags.Album, file.tags.Title, file.tags.Duration),
+		Isrc:         file.tags.Isrc,
+		ExternalIds:  music.ExternalIds{music.ProviderLocal: file.trackId},
+	}
+}
+
+func parseReleaseDate(date string) time.Time {
+	for _, layout := range []string{time.DateOnly, "2006-01", "2006"} {
+		if len(date) >= len(layout) {
+			if parsed, err := time.Parse(layout, date[:len(layout)]); err == nil {
+				return parsed
+			}
+		}
+	}
+
+	return time.Time{}
+}
+
+// Build a music.Album from the files belonging to it, optionally along with its tracklist.
+// The caller must hold the read lock.
+func (lib *Library) album(albumId string, withTracks bool) (music.Album, bool) {
+	files, ok := lib.albums[albumId]
+	if !ok || len(files) == 0 {
+		return music.Album{}, false
+	}
+
+	first := files[0].tags
+
+	// follow Spotify: up to three tracks with a total length under 30 minutes make a single
+	var totalDuration time.Duration
+	for _, file := range files {
+		totalDuration += file.tags.Duration
+	}
+
+	albumType := music.AlbumRegular
+	switch {
+	case first.Compilation:
+		albumType = music.AlbumCompilation
+	case len(files) <= 3 && totalDuration < 30*time.Minute:
+		albumType = music.AlbumSingle
+	}
+
+	album := music.Album{
+		Title:       first.Album,
+		CountTracks: len(files),
+		Artists:     []music.Artist{lib.artist(first.albumArtist())},
+		ReleaseDate: parseReleaseDate(first.Date),
+		SpotifyId:   albumId,
+		Type:        albumType,
+		ExternalIds: music.ExternalIds{music.ProviderLocal: albumId},
+	}
+
+	if withTracks {
+		album.Tracks = make([]music.Track, len(files))
+		for idx, file := range files {
+			album.Tracks[idx] = lib.track(file)
+		}
+
+		album.Images = lib.albumImages(files)
+	}
+
+	return album, true
+}
+
+// Read the embedded artwork of the first file of an album that has any.
+func (lib *Library) albumImages(files []*libraryFile) []music.Image {
+	for _, file := range files {
+		if !file.hasPicture {
+			continue
+		}
+
+		absPath := filepath.Join(lib.root, filepath.FromSlash(file.path))
+		fileTags, err := readTags(absPath, true)
+		if err != nil || fileTags.Picture == nil {
+			continue
+		}
+
+		img := music.Image{
+			MimeType:  fileTags.Picture.MimeType,
+			SpotifyId: file.albumId,
+			Url:       (&url.URL{Scheme: "file", Path: filepath.ToSlash(absPath)}).String(),
+			Data:      fileTags.Picture.Data,
+		}
+
+		if config, _, err := image.DecodeConfig(bytes.NewReader(img.Data)); err == nil {
+			img.Width, img.Height = config.Width, config.Height
+		}
+
+		return []music.Image{img}
+	}
+
+	return nil
+}
+
+// Return the local id of a resource obtained from any provider, if it has one.
+func localIdOf(ids music.ExternalIds, spotifyId string) string {
+	if id := ids.Get(music.ProviderLocal); id != "" {
+		return id
+	}
+
+	return spotifyId
+}
+
+// Case-insensitive comparison of a resource's name to a search query, 2 meaning
+// an exact match, 1 a partial match and 0 no match at all.
+func matchScore(name, query string) int {
+	name, query = strings.ToLower(strings.TrimSpace(name)), strings.ToLower(strings.TrimSpace(query))
+
+	switch {
+	case name == query:
+		return 2
+	case query != "" && strings.Contains(name, query):
+		return 1
+	default:
+		return 0
+	}
+}
+
+func (lib *Library) GetTrackById(id string) (*music.Track, error) {
+	lib.mu.RLock()
+	defer lib.mu.RUnlock()
+
+	file, ok := lib.tracks[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	track := lib.track(file)
+	return &track, nil
+}
+
+func (lib *Library) GetSeveralTracksById(ids []string) ([]music.Track, error) {
+	tracks := make([]music.Track, len(ids))
+	for idx, id := range ids {
+		track, err := lib.GetTrackById(id)
+		if err != nil {
+			return nil, err
+		}
+
+		tracks[idx] = *track
+	}
+
+	return tracks, nil
+}
+
+// Return the track whose title, or artist and title, best match iden.
+func (lib *Library) GetTrackByMatch(iden string) (*music.Track, error) {
+	lib.mu.RLock()
+	defer lib.mu.RUnlock()
+
+	var best *libraryFile
+	bestScore := 0
+
+	for _, file := range lib.files {
+		score := matchScore(file.tags.Title, iden)
+		for _, artist := range file.tags.trackArtists() {
+			score = max(score, matchScore(artist+" "+file.tags.Title, iden), matchScore(file.tags.Title+" "+artist, iden))
+		}
+
+		// ties are broken by path, so that results don't depend on map iteration order
+		if score > bestScore || (score == bestScore && score > 0 && file.path < best.path) {
+			best, bestScore = file, score
+		}
+	}
+
+	if best == nil {
+		return nil, ErrNotFound
+	}
+
+	track := lib.track(best)
+	return &track, nil
+}
+
+func (lib *Library) GetAlbumById(id string) (*music.Album, error) {
+	lib.mu.RLock()
+	defer lib.mu.RUnlock()
+
+	album, ok := lib.album(id, true)
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return &album, nil
+}
+
+func (lib *Library) GetSeveralAlbumsById(ids []string) ([]music.Album, error) {
+	albums := make([]music.Album, len(ids))
+	for idx, id := range ids {
+		album, err := lib.GetAlbumById(id)
+		if err != nil {
+			return nil, err
+		}
+
+		albums[idx] = *album
+	}
+
+	return albums, nil
+}
+
+// Return the album whose title, or album artist and title, best match iden.
+func (lib *Library) GetAlbumByMatch(iden string) (*music.Album, error) {
+	lib.mu.RLock()
+
+	bestId := ""
+	bestScore := 0
+
+	for albumId, files := range lib.albums {
+		title, artist := files[0].tags.Album, files[0].tags.albumArtist()
+		score := max(matchScore(title, iden), matchScore(artist+" "+title, iden), matchScore(title+" "+artist, iden))
+
+		if score > bestScore || (score == bestScore && score > 0 && albumId < bestId) {
+			bestId, bestScore = albumId, score
+		}
+	}
+
+	lib.mu.RUnlock()
+
+	if bestId == "" {
+		return nil, ErrNotFound
+	}
+
+	return lib.GetAlbumById(bestId)
+}
+
+func (lib *Library) GetArtistById(id string, discogFillLevel int, albumTypes []music.AlbumType) (*music.Artist, error) {
+	lib.mu.RLock()
+	name, ok := lib.artists[id]
+	lib.mu.RUnlock()
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	artist := lib.artist(name)
+
+	if discogFillLevel > 0 {
+		if err := artist.FillDiscography(lib, albumTypes, discogFillLevel > 1); err != nil {
+			return nil, err
+		}
+	}
+
+	return &artist, nil
+}
+
+func (lib *Library) GetArtistByMatch(iden string, discogFillLevel int, albumTypes []music.AlbumType) (*music.Artist, error) {
+	lib.mu.RLock()
+
+	bestId := ""
+	bestScore := 0
+
+	for artistId, name := range lib.artists {
+		score := matchScore(name, iden)
+		if score > bestScore || (score == bestScore && score > 0 && artistId < bestId) {
+			bestId, bestScore = artistId, score
+		}
+	}
+
+	lib.mu.RUnlock()
+
+	if bestId == "" {
+		return nil, ErrNotFound
+	}
+
+	return lib.GetArtistById(bestId, discogFillLevel, albumTypes)
+}
+
+// Return all albums of which the artist is the album artist, along with the albums they only appear on
+// (the appears_on group), restricted to the specified groups. If includeGroups is nil, {"album"} is assumed.
+// The discography is sorted with music.SortDiscography().
+func (lib *Library) GetArtistDiscography(artist *music.Artist, includeGroups []music.AlbumType) ([]music.Album, error) {
+	if includeGroups == nil {
+		includeGroups = []music.AlbumType{music.AlbumRegular}
+	}
+
+	lib.mu.RLock()
+	defer lib.mu.RUnlock()
+
+	artistId := localIdOf(artist.ExternalIds, artist.SpotifyId)
+
+	discog := make([]music.Album, 0)
+	for _, albumId := range lib.artistAlbums[artistId] {
+		album, ok := lib.album(albumId, false)
+		if ok && slices.Contains(includeGroups, album.Type) {
+			album.Group = album.Type
+			discog = append(discog, album)
+		}
+	}
+
+	if slices.Contains(includeGroups, music.AlbumAppearsOn) {
+		for _, albumId := range lib.artistAppearances[artistId] {
+			if album, ok := lib.album(albumId, false); ok {
+				album.Group = music.AlbumAppearsOn
+				discog = append(discog, album)
+			}
+		}
+	}
+
+	music.SortDiscography(discog)
+
+	return discog, nil
+}
+
+func (lib *Library) GetAlbumTracklist(album *music.Album) ([]music.Track, error) {
+	lib.mu.RLock()
+	defer lib.mu.RUnlock()
+
+	full, ok := lib.album(localIdOf(album.ExternalIds, album.SpotifyId), true)
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return full.Tracks, nil
+}
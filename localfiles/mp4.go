@@ -0,0 +1,231 @@
+package localfiles
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+var errInvalidMP4 = errors.New("invalid mp4 file")
+
+// data type indicators of the "data" atoms in an iTunes-style item list
+const (
+	mp4TypeUTF8 = 1
+	mp4TypeJPEG = 13
+	mp4TypePNG  = 14
+)
+
+type mp4Atom struct {
+	kind string
+	data []byte
+}
+
+// Split a buffer holding consecutive atoms into its individual atoms.
+func splitAtoms(buf []byte) []mp4Atom {
+	atoms := make([]mp4Atom, 0)
+
+	for len(buf) >= 8 {
+		size := int64(binary.BigEndian.Uint32(buf[:4]))
+		kind := string(buf[4:8])
+		headerLen := int64(8)
+
+		switch size {
+		case 0:
+			size = int64(len(buf))
+		case 1:
+			if len(buf) < 16 {
+				return atoms
+			}
+
+			size = int64(binary.BigEndian.Uint64(buf[8:16]))
+			headerLen = 16
+		}
+
+		if size < headerLen || size > int64(len(buf)) {
+			return atoms
+		}
+
+		atoms = append(atoms, mp4Atom{kind: kind, data: buf[headerLen:size]})
+		buf = buf[size:]
+	}
+
+	return atoms
+}
+
+func findAtom(atoms []mp4Atom, kind string) (mp4Atom, bool) {
+	for _, atom := range atoms {
+		if atom.kind == kind {
+			return atom, true
+		}
+	}
+
+	return mp4Atom{}, false
+}
+
+// Read the "moov" atom of an MP4 file into memory. The top-level atoms preceding it, most
+// notably the (potentially huge) "mdat" holding the audio, are skipped over.
+func readMoov(file *os.File) ([]byte, error) {
+	header := make([]byte, 16)
+
+	for {
+		if _, err := io.ReadFull(file, header[:8]); err != nil {
+			return nil, errInvalidMP4
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		kind := string(header[4:8])
+		headerLen := int64(8)
+
+		if size == 1 {
+			if _, err := io.ReadFull(file, header[8:16]); err != nil {
+				return nil, errInvalidMP4
+			}
+
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerLen = 16
+		}
+
+		if size != 0 && size < headerLen {
+			return nil, errInvalidMP4
+		}
+
+		if kind == "moov" {
+			if size == 0 {
+				return io.ReadAll(file)
+			}
+
+			moov := make([]byte, size-headerLen)
+			if _, err := io.ReadFull(file, moov); err != nil {
+				return nil, errInvalidMP4
+			}
+
+			return moov, nil
+		}
+
+		// an atom extending to the end of the file that isn't moov means there is no moov
+		if size == 0 {
+			return nil, errInvalidMP4
+		}
+
+		if _, err := file.Seek(size-headerLen, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Read the iTunes-style metadata item list (moov/udta/meta/ilst) of an MP4 file along with
+// the duration from its movie header.
+func readMP4(file *os.File, withPicture bool) (tags, error) {
+	var fileTags tags
+
+	moov, err := readMoov(file)
+	if err != nil {
+		return tags{}, err
+	}
+
+	moovAtoms := splitAtoms(moov)
+
+	if mvhd, ok := findAtom(moovAtoms, "mvhd"); ok && len(mvhd.data) >= 20 {
+		var timescale, duration uint64
+		if mvhd.data[0] == 1 && len(mvhd.data) >= 32 {
+			timescale = uint64(binary.BigEndian.Uint32(mvhd.data[20:24]))
+			duration = binary.BigEndian.Uint64(mvhd.data[24:32])
+		} else {
+			timescale = uint64(binary.BigEndian.Uint32(mvhd.data[12:16]))
+			duration = uint64(binary.BigEndian.Uint32(mvhd.data[16:20]))
+		}
+
+		if timescale > 0 {
+			fileTags.Duration = time.Duration(duration) * time.Second / time.Duration(timescale)
+		}
+	}
+
+	udta, ok := findAtom(moovAtoms, "udta")
+	if !ok {
+		return fileTags, nil
+	}
+
+	meta, ok := findAtom(splitAtoms(udta.data), "meta")
+	// "meta" is a full atom, its children follow a version and flags
+	if !ok || len(meta.data) < 4 {
+		return fileTags, nil
+	}
+
+	ilst, ok := findAtom(splitAtoms(meta.data[4:]), "ilst")
+	if !ok {
+		return fileTags, nil
+	}
+
+	for _, item := range splitAtoms(ilst.data) {
+		children := splitAtoms(item.data)
+
+		var values [][]byte
+		var dataType uint32
+		for _, child := range children {
+			// data atoms: version and data type, locale, value
+			if child.kind == "data" && len(child.data) >= 8 {
+				dataType = binary.BigEndian.Uint32(child.data[:4]) & 0x00ffffff
+				values = append(values, child.data[8:])
+			}
+		}
+
+		if len(values) == 0 {
+			continue
+		}
+
+		text := func() string {
+			return strings.TrimSpace(string(values[0]))
+		}
+
+		switch item.kind {
+		case "\xa9nam":
+			fileTags.Title = text()
+		case "\xa9ART":
+			for _, value := range values {
+				fileTags.Artists = append(fileTags.Artists, strings.TrimSpace(string(value)))
+			}
+		case "aART":
+			fileTags.AlbumArtist = text()
+		case "\xa9alb":
+			fileTags.Album = text()
+		case "\xa9day":
+			fileTags.Date = text()
+		case "trkn":
+			// reserved, number, total
+			if len(values[0]) >= 4 {
+				fileTags.TrackNum = int(binary.BigEndian.Uint16(values[0][2:4]))
+			}
+		case "disk":
+			if len(values[0]) >= 4 {
+				fileTags.DiscNum = int(binary.BigEndian.Uint16(values[0][2:4]))
+			}
+		case "cpil":
+			fileTags.Compilation = len(values[0]) > 0 && values[0][0] != 0
+		case "covr":
+			fileTags.HasPicture = true
+			if !withPicture {
+				continue
+			}
+
+			mimeType := "image/jpeg"
+			if dataType == mp4TypePNG {
+				mimeType = "image/png"
+			}
+
+			fileTags.Picture = &picture{MimeType: mimeType, Data: values[0]}
+		case "----":
+			// freeform items are identified by a mean and a name, such as com.apple.iTunes:ISRC
+			name, ok := findAtom(children, "name")
+			if !ok || len(name.data) < 4 || dataType != mp4TypeUTF8 {
+				continue
+			}
+
+			fileTags.setVorbisField(string(name.data[4:]), text())
+		}
+	}
+
+	return fileTags, nil
+}
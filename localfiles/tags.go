@@ -0,0 +1,120 @@
+package localfiles
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrUnsupportedFormat = errors.New("unsupported audio file format")
+
+// Metadata read from the tags of a single audio file, regardless of the tagging format.
+type tags struct {
+	Title       string
+	Artists     []string
+	AlbumArtist string
+	Album       string
+	TrackNum    int
+	DiscNum     int
+	Isrc        string
+	Date        string
+	Compilation bool
+	Duration    time.Duration
+
+	// embedded artwork, preferably the front cover, only read if requested
+	Picture *picture
+	// whether the file has any embedded artwork, whether or not it was read
+	HasPicture bool
+}
+
+type picture struct {
+	MimeType string
+	Data     []byte
+}
+
+// Read the tags of the audio file at path, detecting the tagging format by its extension.
+// If withPicture is false, embedded artwork is skipped over instead of being read into memory.
+func readTags(path string, withPicture bool) (tags, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return tags{}, err
+	}
+
+	defer file.Close()
+
+	var fileTags tags
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mp3":
+		fileTags, err = readMP3(file, withPicture)
+	case ".flac":
+		fileTags, err = readFLAC(file, withPicture)
+	case ".ogg", ".oga", ".opus":
+		fileTags, err = readOgg(file, withPicture)
+	case ".m4a", ".mp4", ".m4b":
+		fileTags, err = readMP4(file, withPicture)
+	default:
+		return tags{}, ErrUnsupportedFormat
+	}
+
+	if err != nil {
+		return tags{}, err
+	}
+
+	// fall back to the file name for untitled tracks
+	if fileTags.Title == "" {
+		fileTags.Title = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+
+	return fileTags, nil
+}
+
+// Return true if the file at path has an extension of a supported audio format.
+func isSupported(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mp3", ".flac", ".ogg", ".oga", ".opus", ".m4a", ".mp4", ".m4b":
+		return true
+	default:
+		return false
+	}
+}
+
+// Parse a track or disc number such as "3" or "3/12", ignoring the total.
+func parseNumber(value string) int {
+	value, _, _ = strings.Cut(strings.TrimSpace(value), "/")
+	number, _ := strconv.Atoi(value)
+	return number
+}
+
+// Interpret the value of a boolean flag such as a compilation marker.
+func parseFlag(value string) bool {
+	value = strings.TrimSpace(value)
+	return value != "" && value != "0" && !strings.EqualFold(value, "false")
+}
+
+// Apply a single textual key/value pair using the field names shared by Vorbis comments
+// and the freeform atoms of MP4 files.
+func (t *tags) setVorbisField(key, value string) {
+	switch strings.ToUpper(key) {
+	case "TITLE":
+		t.Title = value
+	case "ARTIST":
+		t.Artists = append(t.Artists, value)
+	case "ALBUMARTIST", "ALBUM ARTIST", "ALBUM_ARTIST":
+		t.AlbumArtist = value
+	case "ALBUM":
+		t.Album = value
+	case "TRACKNUMBER":
+		t.TrackNum = parseNumber(value)
+	case "DISCNUMBER":
+		t.DiscNum = parseNumber(value)
+	case "ISRC":
+		t.Isrc = strings.ToUpper(strings.ReplaceAll(value, "-", ""))
+	case "DATE", "YEAR":
+		t.Date = value
+	case "COMPILATION":
+		t.Compilation = parseFlag(value)
+	}
+}
@@ -0,0 +1,349 @@
+package localfiles
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	errInvalidFLAC = errors.New("invalid flac file")
+	errInvalidOgg  = errors.New("invalid ogg file")
+)
+
+// Read the STREAMINFO, VORBIS_COMMENT and PICTURE metadata blocks of a FLAC file.
+func readFLAC(file *os.File, withPicture bool) (tags, error) {
+	var fileTags tags
+
+	// FLAC files are sometimes prefixed with an ID3v2 tag, which is skipped
+	audioStart, err := readID3v2(file, &tags{}, false)
+	if err != nil {
+		return tags{}, err
+	}
+
+	if _, err := file.Seek(audioStart, io.SeekStart); err != nil {
+		return tags{}, err
+	}
+
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(file, magic); err != nil || string(magic) != "fLaC" {
+		return tags{}, errInvalidFLAC
+	}
+
+	pictureType := -1
+	header := make([]byte, 4)
+
+	for {
+		if _, err := io.ReadFull(file, header); err != nil {
+			return tags{}, errInvalidFLAC
+		}
+
+		isLast := header[0]&0x80 != 0
+		blockType := header[0] & 0x7f
+		blockLen := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+
+		if blockType == 6 {
+			fileTags.HasPicture = true
+		}
+
+		switch {
+		case blockType == 0 || blockType == 4 || (blockType == 6 && withPicture):
+			block := make([]byte, blockLen)
+			if _, err := io.ReadFull(file, block); err != nil {
+				return tags{}, errInvalidFLAC
+			}
+
+			switch blockType {
+			case 0:
+				if len(block) >= 18 {
+					sampleRate := int64(block[10])<<12 | int64(block[11])<<4 | int64(block[12])>>4
+					totalSamples := int64(block[13]&0x0f)<<32 | int64(binary.BigEndian.Uint32(block[14:18]))
+					if sampleRate > 0 {
+						fileTags.Duration = time.Duration(totalSamples) * time.Second / time.Duration(sampleRate)
+					}
+				}
+			case 4:
+				parseVorbisComments(block, &fileTags, withPicture)
+			case 6:
+				picType, pic, ok := parsePictureBlock(block)
+				if ok && (pictureType == -1 || (pictureType != 3 && picType == 3)) {
+					pictureType = int(picType)
+					fileTags.Picture = pic
+				}
+			}
+		default:
+			if _, err := file.Seek(blockLen, io.SeekCurrent); err != nil {
+				return tags{}, err
+			}
+		}
+
+		if isLast {
+			break
+		}
+	}
+
+	return fileTags, nil
+}
+
+// Parse a Vorbis comment block (the little-endian format shared by FLAC, Ogg Vorbis and Opus,
+// without any codec-specific packet prefix).
+func parseVorbisComments(block []byte, fileTags *tags, withPicture bool) {
+	reader := bytes.NewReader(block)
+
+	readString := func() (string, bool) {
+		var length uint32
+		if err := binary.Read(reader, binary.LittleEndian, &length); err != nil || int64(length) > int64(reader.Len()) {
+			return "", false
+		}
+
+		data := make([]byte, length)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return "", false
+		}
+
+		return string(data), true
+	}
+
+	// vendor string
+	if _, ok := readString(); !ok {
+		return
+	}
+
+	var count uint32
+	if err := binary.Read(reader, binary.LittleEndian, &count); err != nil {
+		return
+	}
+
+	pictureType := -1
+
+	for idx := uint32(0); idx < count; idx++ {
+		comment, ok := readString()
+		if !ok {
+			return
+		}
+
+		key, value, found := strings.Cut(comment, "=")
+		if !found {
+			continue
+		}
+
+		// Ogg files carry artwork as base64 encoded FLAC picture blocks
+		if strings.EqualFold(key, "METADATA_BLOCK_PICTURE") {
+			fileTags.HasPicture = true
+			if !withPicture {
+				continue
+			}
+
+			block, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				continue
+			}
+
+			picType, pic, ok := parsePictureBlock(block)
+			if ok && (pictureType == -1 || (pictureType != 3 && picType == 3)) {
+				pictureType = int(picType)
+				fileTags.Picture = pic
+			}
+
+			continue
+		}
+
+		fileTags.setVorbisField(key, strings.TrimSpace(value))
+	}
+}
+
+// Parse a FLAC PICTURE metadata block.
+func parsePictureBlock(block []byte) (uint32, *picture, bool) {
+	reader := bytes.NewReader(block)
+
+	var picType, mimeLen uint32
+	if binary.Read(reader, binary.BigEndian, &picType) != nil || binary.Read(reader, binary.BigEndian, &mimeLen) != nil {
+		return 0, nil, false
+	}
+
+	if int64(mimeLen) > int64(reader.Len()) {
+		return 0, nil, false
+	}
+
+	mimeType := make([]byte, mimeLen)
+	if _, err := io.ReadFull(reader, mimeType); err != nil {
+		return 0, nil, false
+	}
+
+	var descLen uint32
+	if binary.Read(reader, binary.BigEndian, &descLen) != nil || int64(descLen) > int64(reader.Len()) {
+		return 0, nil, false
+	}
+
+	// description, then width, height, color depth and number of colors
+	if _, err := reader.Seek(int64(descLen)+16, io.SeekCurrent); err != nil {
+		return 0, nil, false
+	}
+
+	var dataLen uint32
+	if binary.Read(reader, binary.BigEndian, &dataLen) != nil || int64(dataLen) > int64(reader.Len()) {
+		return 0, nil, false
+	}
+
+	data := make([]byte, dataLen)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return 0, nil, false
+	}
+
+	return picType, &picture{MimeType: string(mimeType), Data: data}, true
+}
+
+// A reader of the logical packets of the first bitstream of an Ogg file.
+type oggReader struct {
+	reader   io.Reader
+	segments []byte
+
+	// serial number of the bitstream being read, taken from the first page
+	serial    uint32
+	hasSerial bool
+}
+
+// Return the next complete packet, which may span multiple pages.
+func (ogg *oggReader) nextPacket() ([]byte, error) {
+	var packet []byte
+
+	for {
+		if len(ogg.segments) == 0 {
+			if err := ogg.nextPage(); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		segmentLen := int(ogg.segments[0])
+		ogg.segments = ogg.segments[1:]
+
+		data := make([]byte, segmentLen)
+		if _, err := io.ReadFull(ogg.reader, data); err != nil {
+			return nil, errInvalidOgg
+		}
+
+		packet = append(packet, data...)
+
+		// a lacing value below 255 terminates the packet
+		if segmentLen < 255 {
+			return packet, nil
+		}
+	}
+}
+
+func (ogg *oggReader) nextPage() error {
+	header := make([]byte, 27)
+	if _, err := io.ReadFull(ogg.reader, header); err != nil || string(header[:4]) != "OggS" {
+		return errInvalidOgg
+	}
+
+	serial := binary.LittleEndian.Uint32(header[14:18])
+	if !ogg.hasSerial {
+		ogg.serial, ogg.hasSerial = serial, true
+	}
+
+	segments := make([]byte, header[26])
+	if _, err := io.ReadFull(ogg.reader, segments); err != nil {
+		return errInvalidOgg
+	}
+
+	// pages of other multiplexed bitstreams are skipped
+	if serial != ogg.serial {
+		total := 0
+		for _, segmentLen := range segments {
+			total += int(segmentLen)
+		}
+
+		_, err := io.CopyN(io.Discard, ogg.reader, int64(total))
+		return err
+	}
+
+	ogg.segments = segments
+	return nil
+}
+
+// Read the comment header of an Ogg Vorbis or Opus file, and determine its duration from the
+// granule position of the last page.
+func readOgg(file *os.File, withPicture bool) (tags, error) {
+	var fileTags tags
+
+	ogg := &oggReader{reader: file}
+
+	identification, err := ogg.nextPacket()
+	if err != nil {
+		return tags{}, err
+	}
+
+	comments, err := ogg.nextPacket()
+	if err != nil {
+		return tags{}, err
+	}
+
+	var sampleRate int64
+	var preSkip int64
+
+	switch {
+	case bytes.HasPrefix(identification, []byte("\x01vorbis")) && len(identification) >= 16:
+		sampleRate = int64(binary.LittleEndian.Uint32(identification[12:16]))
+
+		if !bytes.HasPrefix(comments, []byte("\x03vorbis")) {
+			return tags{}, errInvalidOgg
+		}
+
+		parseVorbisComments(comments[7:], &fileTags, withPicture)
+	case bytes.HasPrefix(identification, []byte("OpusHead")) && len(identification) >= 12:
+		// Opus granule positions always count samples at 48kHz
+		sampleRate = 48000
+		preSkip = int64(binary.LittleEndian.Uint16(identification[10:12]))
+
+		if !bytes.HasPrefix(comments, []byte("OpusTags")) {
+			return tags{}, errInvalidOgg
+		}
+
+		parseVorbisComments(comments[8:], &fileTags, withPicture)
+	default:
+		return tags{}, ErrUnsupportedFormat
+	}
+
+	if granule, err := lastGranulePosition(file, ogg.serial); err == nil && sampleRate > 0 && granule > preSkip {
+		fileTags.Duration = time.Duration(granule-preSkip) * time.Second / time.Duration(sampleRate)
+	}
+
+	return fileTags, nil
+}
+
+// Find the granule position of the last page of the specified bitstream.
+func lastGranulePosition(file *os.File, serial uint32) (int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	// the maximum size of an Ogg page is slightly below 64KiB
+	tailLen := min(info.Size(), 65307)
+	tail := make([]byte, tailLen)
+	if _, err := file.ReadAt(tail, info.Size()-tailLen); err != nil && err != io.EOF {
+		return 0, err
+	}
+
+	for offset := bytes.LastIndex(tail, []byte("OggS")); offset != -1; offset = bytes.LastIndex(tail[:offset], []byte("OggS")) {
+		if offset+27 > len(tail) || binary.LittleEndian.Uint32(tail[offset+14:offset+18]) != serial {
+			continue
+		}
+
+		granule := int64(binary.LittleEndian.Uint64(tail[offset+6 : offset+14]))
+		// -1 marks pages on which no packet ends
+		if granule >= 0 {
+			return granule, nil
+		}
+	}
+
+	return 0, errInvalidOgg
+}
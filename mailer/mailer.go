@@ -0,0 +1,95 @@
+// The mailer package sends e-mails to musicdash users, such as password reset links. The Mailer
+// interface is implemented by SMTP, which delivers through an SMTP server, and by Memory, which
+// only keeps sent messages and is meant for tests and local development.
+package mailer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+)
+
+type Message struct {
+	To      string
+	Subject string
+
+	// plain text body
+	Body string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
+
+var ErrNoRecipient = errors.New("message has no recipient")
+
+// A Mailer that doesn't deliver messages anywhere, but keeps all sent messages in memory.
+type Memory struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+func (m *Memory) Send(ctx context.Context, message Message) error {
+	if message.To == "" {
+		return ErrNoRecipient
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = append(m.messages, message)
+	return nil
+}
+
+// Return all messages sent so far, oldest first.
+func (m *Memory) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	messages := make([]Message, len(m.messages))
+	copy(messages, m.messages)
+	return messages
+}
+
+// Return the most recent message sent to the given address, if any.
+func (m *Memory) LastTo(address string) (Message, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for idx := len(m.messages) - 1; idx >= 0; idx-- {
+		if m.messages[idx].To == address {
+			return m.messages[idx], true
+		}
+	}
+
+	return Message{}, false
+}
+
+// Return an SMTP mailer configured by the MUSICDASH_SMTP_HOST, MUSICDASH_SMTP_PORT (587 if unset),
+// MUSICDASH_SMTP_USERNAME, MUSICDASH_SMTP_PASSWORD and MUSICDASH_SMTP_FROM environment variables,
+// or a Memory mailer if MUSICDASH_SMTP_HOST isn't set.
+func FromEnv() Mailer {
+	host := os.Getenv("MUSICDASH_SMTP_HOST")
+	if host == "" {
+		return NewMemory()
+	}
+
+	port, err := strconv.Atoi(os.Getenv("MUSICDASH_SMTP_PORT"))
+	if err != nil || port <= 0 {
+		port = 587
+	}
+
+	return &SMTP{
+		Host:     host,
+		Port:     port,
+		Username: os.Getenv("MUSICDASH_SMTP_USERNAME"),
+		Password: os.Getenv("MUSICDASH_SMTP_PASSWORD"),
+		From:     os.Getenv("MUSICDASH_SMTP_FROM"),
+	}
+}
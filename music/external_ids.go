@@ -0,0 +1,94 @@
+package music
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// The name of a source of music metadata that identifies resources using its own ids.
+type Provider string
+
+const (
+	ProviderSpotify     Provider = "spotify"
+	ProviderMusicBrainz Provider = "musicbrainz"
+	ProviderLastfm      Provider = "lastfm"
+	ProviderLocal       Provider = "local"
+)
+
+// The kind of a music resource, used to tell apart ids of different resources
+// that are stored alongside each other.
+type ResourceType string
+
+const (
+	ResourceTrack  ResourceType = "track"
+	ResourceAlbum  ResourceType = "album"
+	ResourceArtist ResourceType = "artist"
+)
+
+// The set of ids a resource is known by across different providers, keyed by
+// the name of the provider that issued them. A resource has at most one id per provider.
+// The zero value is an empty set that is ready to use.
+type ExternalIds map[Provider]string
+
+// Return the id issued by provider, or "" if the resource has none.
+func (ids ExternalIds) Get(provider Provider) string {
+	return ids[provider]
+}
+
+// Set the id issued by provider, allocating the set if it is nil. Empty ids are ignored.
+func (ids *ExternalIds) Set(provider Provider, id string) {
+	if id == "" {
+		return
+	}
+
+	if *ids == nil {
+		*ids = make(ExternalIds)
+	}
+
+	(*ids)[provider] = id
+}
+
+// Whether a catalog id was issued by Spotify, rather than being the id that a resource of another
+// provider is preserved under, such as a local file's "local:" id. Spotify ids never contain a colon.
+// Only Spotify ids may be requested from Spotify or used in Spotify URIs and URLs.
+func IsSpotifyId(id string) bool {
+	return id != "" && !strings.Contains(id, ":")
+}
+
+// Preserve all external ids of a preserved resource into spotify.external_id. The Spotify
+// id itself isn't stored there, as every resource in the catalog is already keyed by it.
+// An existing id of the same provider is overwritten.
+func preserveExternalIds(ctx context.Context, pool *pgxpool.Pool, resourceType ResourceType, spotifyId string, ids ExternalIds) error {
+	batch := &pgx.Batch{}
+
+	for provider, id := range ids {
+		if provider == ProviderSpotify || id == "" {
+			continue
+		}
+
+		batch.Queue(
+			`
+				insert into spotify.external_id
+				(resourcetype, spotifyid, provider, externalid)
+				values (@resourceType, @spotifyId, @provider, @externalId)
+				on conflict on constraint external_id_pk do update
+				set externalid = @externalId
+			`,
+			pgx.NamedArgs{
+				"resourceType": resourceType,
+				"spotifyId":    spotifyId,
+				"provider":     provider,
+				"externalId":   id,
+			},
+		)
+	}
+
+	if batch.Len() == 0 {
+		return nil
+	}
+
+	return pool.SendBatch(ctx, batch).Close()
+}
@@ -0,0 +1,272 @@
+package music
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Default thresholds of Matcher: candidates scoring at least DefaultAcceptScore are matched right away,
+// and ones scoring at least DefaultReviewScore are matched tentatively, pending a review by the user.
+const (
+	DefaultAcceptScore = 0.85
+	DefaultReviewScore = 0.5
+)
+
+// Weights of the components of a candidate's score. Components that can't be compared, such as the
+// album when the query doesn't name one, are left out, with the remaining weights scaled up.
+const (
+	matchWeightTitle    = 0.45
+	matchWeightArtist   = 0.35
+	matchWeightAlbum    = 0.1
+	matchWeightDuration = 0.1
+)
+
+// Durations differing by at most matchDurationTolerance are considered the same, and ones differing
+// by matchDurationMaxDiff or more entirely different.
+const (
+	matchDurationTolerance = 2 * time.Second
+	matchDurationMaxDiff   = 30 * time.Second
+)
+
+// A track to be matched to one in the catalog, as described by a source that doesn't know its Spotify
+// id, such as a scrobble. Only Artist and Title are required.
+type MatchQuery struct {
+	Artist string
+	Album  string
+	Title  string
+
+	// zero if unknown
+	Duration time.Duration
+	// empty if unknown
+	Isrc string
+}
+
+// A source of candidate tracks for a MatchQuery, such as the local database or Spotify. Implementations
+// return the tracks most likely to be the queried one, in no particular order, and no error if there
+// are none.
+type TrackSearcher interface {
+	SearchTracks(ctx context.Context, query MatchQuery, limit int) ([]Track, error)
+}
+
+// A track that a query may refer to, with its score between 0 and 1.
+type MatchCandidate struct {
+	Track Track
+	Score float64
+
+	// index of the Matcher's searcher that found the track
+	Searcher int
+}
+
+type MatchStatus string
+
+const (
+	// the best candidate scored at least the accept threshold
+	MatchAccepted MatchStatus = "accepted"
+	// the best candidate scored at least the review threshold, but less than the accept threshold
+	MatchNeedsReview MatchStatus = "review"
+	// no candidate scored at least the review threshold
+	MatchNotFound MatchStatus = "not_found"
+)
+
+type MatchResult struct {
+	Status MatchStatus
+
+	// all scored candidates, best first
+	Candidates []MatchCandidate
+}
+
+// Return the best candidate, or nil if there are none.
+func (result MatchResult) Best() *MatchCandidate {
+	if len(result.Candidates) == 0 {
+		return nil
+	}
+
+	return &result.Candidates[0]
+}
+
+// A Matcher matches queries to tracks by searching its searchers for candidates and scoring them.
+// The zero value isn't usable, use NewMatcher().
+type Matcher struct {
+	// searched in order, stopping at the first one that yields a candidate to be accepted, so cheaper
+	// searchers, such as the local database, should come first
+	Searchers []TrackSearcher
+
+	AcceptScore float64
+	ReviewScore float64
+
+	// number of candidates requested from every searcher
+	Limit int
+}
+
+// Return a Matcher using searchers, in order, with the default thresholds.
+func NewMatcher(searchers ...TrackSearcher) *Matcher {
+	return &Matcher{
+		Searchers:   searchers,
+		AcceptScore: DefaultAcceptScore,
+		ReviewScore: DefaultReviewScore,
+		Limit:       5,
+	}
+}
+
+// Match a query to a track, returning the scored candidates along with the outcome.
+func (matcher *Matcher) Match(ctx context.Context, query MatchQuery) (MatchResult, error) {
+	var result MatchResult
+	seen := make(map[string]bool)
+
+	for idx, searcher := range matcher.Searchers {
+		tracks, err := searcher.SearchTracks(ctx, query, matcher.Limit)
+		if err != nil {
+			return MatchResult{}, err
+		}
+
+		for _, track := range tracks {
+			if track.SpotifyId == "" || seen[track.SpotifyId] {
+				continue
+			}
+
+			seen[track.SpotifyId] = true
+			result.Candidates = append(result.Candidates, MatchCandidate{
+				Track:    track,
+				Score:    ScoreMatch(query, &track),
+				Searcher: idx,
+			})
+		}
+
+		// the candidates of a stable sort stay in the order of searchers, preferring earlier ones on ties
+		slices.SortStableFunc(result.Candidates, func(a, b MatchCandidate) int {
+			switch {
+			case a.Score > b.Score:
+				return -1
+			case a.Score < b.Score:
+				return 1
+			}
+
+			return 0
+		})
+
+		if best := result.Best(); best != nil && best.Score >= matcher.AcceptScore {
+			break
+		}
+	}
+
+	switch best := result.Best(); {
+	case best != nil && best.Score >= matcher.AcceptScore:
+		result.Status = MatchAccepted
+	case best != nil && best.Score >= matcher.ReviewScore:
+		result.Status = MatchNeedsReview
+	default:
+		result.Status = MatchNotFound
+	}
+
+	return result, nil
+}
+
+// Score how likely track is the one that query refers to, between 0 and 1. Matching ISRCs identify
+// the recording, scoring 1 regardless of anything else. Otherwise the score is a weighted combination
+// of the similarities of the titles, ignoring version tags other than those of separate recordings, of
+// the artists, of the albums and of the durations.
+func ScoreMatch(query MatchQuery, track *Track) float64 {
+	if query.Isrc != "" && strings.EqualFold(query.Isrc, track.Isrc) {
+		return 1
+	}
+
+	score := matchWeightTitle * titleSimilarity(query.Title, track.Title)
+	total := matchWeightTitle
+
+	artistScore := 0.0
+	for _, artist := range track.Artists {
+		artistScore = max(artistScore, artistSimilarity(query.Artist, artist.Name))
+	}
+
+	score += matchWeightArtist * artistScore
+	total += matchWeightArtist
+
+	if query.Album != "" && track.Album.Title != "" {
+		score += matchWeightAlbum * wordSimilarity(ParseTitle(query.Album).Base, ParseTitle(track.Album.Title).Base)
+		total += matchWeightAlbum
+	}
+
+	if query.Duration > 0 && track.Duration > 0 {
+		score += matchWeightDuration * durationSimilarity(query.Duration, track.Duration)
+		total += matchWeightDuration
+	}
+
+	return score / total
+}
+
+// Similarity of two track titles: of their base titles, reduced if only one of them is a separate
+// recording, such as a live version, or if they're different kinds of separate recordings.
+func titleSimilarity(a, b string) float64 {
+	parsedA, parsedB := ParseTitle(a), ParseTitle(b)
+	similarity := wordSimilarity(parsedA.Base, parsedB.Base)
+
+	for _, tag := range distinctRecordingTags {
+		if parsedA.Has(tag) != parsedB.Has(tag) {
+			return similarity * 0.6
+		}
+	}
+
+	return similarity
+}
+
+// Similarity of an artist as named by a query to an artist of a track. A query naming several artists,
+// as in "Artist & Other Artist", is similar to each of them.
+func artistSimilarity(queried, name string) float64 {
+	queried, name = NormalizeTitle(queried), NormalizeTitle(name)
+	if queried == name {
+		return 1
+	}
+
+	if name != "" && strings.HasPrefix(queried+" ", name+" ") {
+		return 0.9
+	}
+
+	return wordSimilarity(queried, name)
+}
+
+// Similarity of two strings by their words: 1 if their normalized forms are the same, and otherwise the
+// Dice coefficient of their sets of words.
+func wordSimilarity(a, b string) float64 {
+	wordsA, wordsB := words(strings.ReplaceAll(a, "&", " and ")), words(strings.ReplaceAll(b, "&", " and "))
+	if len(wordsA) == 0 || len(wordsB) == 0 {
+		return 0
+	}
+
+	if slices.Equal(wordsA, wordsB) {
+		return 1
+	}
+
+	setB := make(map[string]bool, len(wordsB))
+	for _, word := range wordsB {
+		setB[word] = true
+	}
+
+	setA := make(map[string]bool, len(wordsA))
+	common := 0
+	for _, word := range wordsA {
+		if !setA[word] && setB[word] {
+			common++
+		}
+
+		setA[word] = true
+	}
+
+	return 2 * float64(common) / float64(len(setA)+len(setB))
+}
+
+// Similarity of two durations, decreasing linearly from 1 within matchDurationTolerance to 0 at
+// matchDurationMaxDiff.
+func durationSimilarity(a, b time.Duration) float64 {
+	diff := (a - b).Abs()
+	if diff <= matchDurationTolerance {
+		return 1
+	}
+
+	if diff >= matchDurationMaxDiff {
+		return 0
+	}
+
+	return 1 - float64(diff-matchDurationTolerance)/float64(matchDurationMaxDiff-matchDurationTolerance)
+}
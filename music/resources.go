@@ -4,9 +4,11 @@ package music
 
 import (
 	"context"
+	"errors"
 	"io"
 	"log"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
@@ -14,14 +16,54 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// The type of an album, or the group of an album in an artist's discography. An album's own type
+// is always one of album, single or compilation. Its group in a discography is the same as its type
+// if the artist is one of the album's artists, or appears_on if the artist only appears on some of
+// its tracks.
 type AlbumType string
 
 const (
 	AlbumRegular     AlbumType = "album"
 	AlbumCompilation AlbumType = "compilation"
 	AlbumSingle      AlbumType = "single"
+	AlbumAppearsOn   AlbumType = "appears_on"
 )
 
+var ErrInvalidAlbumType = errors.New("invalid album type")
+
+// The local catalog is keyed by Spotify ids, so resources obtained from other providers can only be
+// preserved once they've been matched to Spotify resources.
+var ErrNoSpotifyId = errors.New("resource has no spotify id and can't be preserved")
+
+// All album groups, in the order in which discographies list them.
+var AlbumGroups = []AlbumType{AlbumRegular, AlbumSingle, AlbumCompilation, AlbumAppearsOn}
+
+// Parse an album type or group as used by the Spotify Web API.
+func ParseAlbumType(s string) (AlbumType, error) {
+	albumType := AlbumType(strings.ToLower(s))
+	if !slices.Contains(AlbumGroups, albumType) {
+		return "", ErrInvalidAlbumType
+	}
+
+	return albumType, nil
+}
+
+// Sort a discography by group (in the order of AlbumGroups), then by release date, newest first.
+// Albums released on the same day are ordered by Spotify id so that the order is stable across providers.
+func SortDiscography(discog []Album) {
+	slices.SortStableFunc(discog, func(a, b Album) int {
+		if groupA, groupB := slices.Index(AlbumGroups, a.Group), slices.Index(AlbumGroups, b.Group); groupA != groupB {
+			return groupA - groupB
+		}
+
+		if cmp := b.ReleaseDate.Compare(a.ReleaseDate); cmp != 0 {
+			return cmp
+		}
+
+		return strings.Compare(a.SpotifyId, b.SpotifyId)
+	})
+}
+
 func IncludeGroupToString(group []AlbumType) string {
 	as_strings := make([]string, len(group))
 	for idx, g := range group {
@@ -132,6 +174,19 @@ type Track struct {
 	Upc               string
 	SpotifyURI        string
 	SpotifyPopularity int
+	ExternalIds       ExternalIds
+}
+
+// Text that a track or an album is searched by: its title followed by the names of its artists.
+func searchText(title string, artists []Artist) string {
+	parts := make([]string, 0, len(artists)+1)
+	parts = append(parts, title)
+
+	for _, artist := range artists {
+		parts = append(parts, artist.Name)
+	}
+
+	return strings.Join(parts, " ")
 }
 
 // Preserve the track into the local database. Preserving a track performs
@@ -140,7 +195,13 @@ type Track struct {
 //  2. stores the performing artists into public.spotify_track_artist
 //     , properly marking the main performing artist, and preserving
 //     any performing artists that aren't already in the database
+//
+// If the track has no Spotify id, ErrNoSpotifyId is returned.
 func (track *Track) Preserve(ctx context.Context, pool *pgxpool.Pool, recurse bool) error {
+	if track.SpotifyId == "" {
+		return ErrNoSpotifyId
+	}
+
 	// preserve the track's belonging album if it isn't already so
 	if track.Album.SpotifyId != "" {
 		albumIsPreserved, err := track.Album.IsPreserved(ctx, pool)
@@ -157,10 +218,10 @@ func (track *Track) Preserve(ctx context.Context, pool *pgxpool.Pool, recurse bo
 
 	sqlQueryBaseInfo := `
 		insert into spotify.track
-		(spotifyid, title, duration, tracklistnum, discnum, explicit, popularity, spotifyuri, isrc, ean, upc, spotifyidalbum)	
-		values (@spotifyId, @title, @duration, @tracklistNum, @discNum, @explicit, @popularity, @spotifyUri, @isrc, @ean, @upc, @spotifyIdAlbum)
+		(spotifyid, title, duration, tracklistnum, discnum, explicit, popularity, spotifyuri, isrc, ean, upc, spotifyidalbum, searchtext)	
+		values (@spotifyId, @title, @duration, @tracklistNum, @discNum, @explicit, @popularity, @spotifyUri, @isrc, @ean, @upc, @spotifyIdAlbum, @searchText)
 		on conflict on constraint track_pk do update
-		set title = @title, duration = @duration, tracklistnum = @tracklistNum, discnum = @discNum, explicit = @explicit, popularity = @popularity, spotifyuri = @spotifyUri, isrc = @isrc, ean = @ean, upc = @upc, spotifyidalbum=@spotifyIdAlbum
+		set title = @title, duration = @duration, tracklistnum = @tracklistNum, discnum = @discNum, explicit = @explicit, popularity = @popularity, spotifyuri = @spotifyUri, isrc = @isrc, ean = @ean, upc = @upc, spotifyidalbum=@spotifyIdAlbum, searchtext = @searchText
 	`
 
 	_, err := pool.Exec(
@@ -179,6 +240,7 @@ func (track *Track) Preserve(ctx context.Context, pool *pgxpool.Pool, recurse bo
 			"ean":            track.Ean,
 			"upc":            track.Upc,
 			"spotifyIdAlbum": track.Album.SpotifyId,
+			"searchText":     searchText(track.Title, track.Artists),
 		},
 	)
 
@@ -186,6 +248,10 @@ func (track *Track) Preserve(ctx context.Context, pool *pgxpool.Pool, recurse bo
 		return err
 	}
 
+	if err := preserveExternalIds(ctx, pool, ResourceTrack, track.SpotifyId, track.ExternalIds); err != nil {
+		return err
+	}
+
 	// perserve the performing artists
 	sqlQueryPerformingArtist := `
 		insert into spotify.track_artist
@@ -224,11 +290,40 @@ func (track *Track) Preserve(ctx context.Context, pool *pgxpool.Pool, recurse bo
 		if err != nil {
 			return err
 		}
+
+		if track.Album.SpotifyId != "" && !slices.ContainsFunc(track.Album.Artists, func(albumArtist Artist) bool {
+			return albumArtist.SpotifyId == performingArtist.SpotifyId
+		}) {
+			if err := preserveAppearance(ctx, pool, performingArtist.SpotifyId, track.Album.SpotifyId); err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
 }
 
+// Record that an artist appears on an album without being one of its artists, i.e. that the album
+// is in the artist's appears_on group. Existing relations with an album artist are left untouched.
+func preserveAppearance(ctx context.Context, pool *pgxpool.Pool, artistId, albumId string) error {
+	_, err := pool.Exec(
+		ctx,
+		`
+			insert into spotify.album_artist
+			(spotifyidartist, spotifyidalbum, ismain, albumgroup)
+			values (@spotifyIdArtist, @spotifyIdAlbum, false, @albumGroup)
+			on conflict on constraint album_artist_pk do nothing
+		`,
+		pgx.NamedArgs{
+			"spotifyIdArtist": artistId,
+			"spotifyIdAlbum":  albumId,
+			"albumGroup":      AlbumAppearsOn,
+		},
+	)
+
+	return err
+}
+
 func (track *Track) IsPreserved(ctx context.Context, pool *pgxpool.Pool) (bool, error) {
 	row := pool.QueryRow(ctx, "select spotifyid from spotify.track where spotifyid=$1", track.SpotifyId)
 
@@ -252,6 +347,7 @@ type Artist struct {
 	SpotifyId            string
 	SpotifyURI           string
 	SpotifyFollowerCount int
+	ExternalIds          ExternalIds
 }
 
 // Obtain an artist's complete discography using the specified provider
@@ -292,12 +388,16 @@ func (artist *Artist) IsPreserved(ctx context.Context, pool *pgxpool.Pool) (bool
 }
 
 func (artist *Artist) Preserve(ctx context.Context, pool *pgxpool.Pool, recurse bool) error {
+	if artist.SpotifyId == "" {
+		return ErrNoSpotifyId
+	}
+
 	sqlQuery := `
 		insert into spotify.artist
 		(spotifyid, name, spotifyuri, followers) 
 		values (@spotifyId, @name, @spotifyUri, @followers)
 		on conflict on constraint artist_pk do update
-		set name = $2, spotifyuri = $3, followers = $4
+		set name = @name, spotifyuri = @spotifyUri, followers = @followers
 	`
 
 	// insert base info of artist
@@ -316,6 +416,10 @@ func (artist *Artist) Preserve(ctx context.Context, pool *pgxpool.Pool, recurse
 		return err
 	}
 
+	if err := preserveExternalIds(ctx, pool, ResourceArtist, artist.SpotifyId, artist.ExternalIds); err != nil {
+		return err
+	}
+
 	// preserve the Artist's entire discography if told to recurse
 	if recurse {
 		for _, album := range artist.Discography {
@@ -324,13 +428,17 @@ func (artist *Artist) Preserve(ctx context.Context, pool *pgxpool.Pool, recurse
 				return err
 			}
 
-			if albumPreserved {
-				continue
+			if !albumPreserved {
+				if err = album.Preserve(ctx, pool, recurse); err != nil {
+					return err
+				}
 			}
 
-			err = album.Preserve(ctx, pool, recurse)
-			if err != nil {
-				return err
+			// the artist may appear on the album without any of its preserved tracks crediting them
+			if album.Group == AlbumAppearsOn {
+				if err := preserveAppearance(ctx, pool, artist.SpotifyId, album.SpotifyId); err != nil {
+					return err
+				}
 			}
 		}
 	}
@@ -351,15 +459,24 @@ type Album struct {
 	SpotifyId   string
 	SpotifyURI  string
 	Type        AlbumType
+	ExternalIds ExternalIds
+
+	// the group of the album in the discography of the artist it was obtained for, if it was obtained
+	// through ResourceProvider.GetArtistDiscography(). Empty otherwise.
+	Group AlbumType
 }
 
 func (album *Album) Preserve(ctx context.Context, pool *pgxpool.Pool, recurse bool) error {
+	if album.SpotifyId == "" {
+		return ErrNoSpotifyId
+	}
+
 	sqlQueryBaseInfo := `
 		insert into spotify.album
-		(spotifyid, title, counttracks, releasedate, type, spotifyuri, isrc, ean, upc)
-		values (@spotifyId, @title, @countTracks, @releaseDate, @type, @spotifyUri, @isrc, @ean, @upc)
+		(spotifyid, title, counttracks, releasedate, type, spotifyuri, isrc, ean, upc, searchtext)
+		values (@spotifyId, @title, @countTracks, @releaseDate, @type, @spotifyUri, @isrc, @ean, @upc, @searchText)
 		on conflict on constraint album_pk do update
-		set title = @title, counttracks = @countTracks, releasedate = @releaseDate, type = @type, spotifyuri = @spotifyUri, isrc = @isrc, ean = @ean, upc = @upc 
+		set title = @title, counttracks = @countTracks, releasedate = @releaseDate, type = @type, spotifyuri = @spotifyUri, isrc = @isrc, ean = @ean, upc = @upc, searchtext = @searchText
 	`
 
 	_, err := pool.Exec(
@@ -375,6 +492,7 @@ func (album *Album) Preserve(ctx context.Context, pool *pgxpool.Pool, recurse bo
 			"isrc":        album.Isrc,
 			"ean":         album.Ean,
 			"upc":         album.Upc,
+			"searchText":  searchText(album.Title, album.Artists),
 		},
 	)
 
@@ -382,11 +500,18 @@ func (album *Album) Preserve(ctx context.Context, pool *pgxpool.Pool, recurse bo
 		return err
 	}
 
+	if err := preserveExternalIds(ctx, pool, ResourceAlbum, album.SpotifyId, album.ExternalIds); err != nil {
+		return err
+	}
+
+	// the album's own artists are always in the group of the album's type,
+	// even if they've previously been recorded as appearing on it
 	sqlQueryPerformingArtist := `
 		insert into spotify.album_artist
-		(spotifyidartist, spotifyidalbum, ismain)
-		values (@spotifyIdArtist, @spotifyIdAlbum, @isMain)
-		on conflict on constraint album_artist_pk do nothing
+		(spotifyidartist, spotifyidalbum, ismain, albumgroup)
+		values (@spotifyIdArtist, @spotifyIdAlbum, @isMain, @albumGroup)
+		on conflict on constraint album_artist_pk do update
+		set ismain = @isMain, albumgroup = @albumGroup
 	`
 
 	// create a relation in public.spotify_album_artist
@@ -411,6 +536,7 @@ func (album *Album) Preserve(ctx context.Context, pool *pgxpool.Pool, recurse bo
 				"spotifyIdArtist": performingArtist.SpotifyId,
 				"spotifyIdAlbum":  album.SpotifyId,
 				"isMain":          isMain,
+				"albumGroup":      album.Type,
 			},
 		)
 
@@ -0,0 +1,371 @@
+package music
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// A tag describing what makes a particular version of a track differ from the original,
+// as written in its title, e.g. "Song (Live at Wembley)" or "Song - Remastered 2011".
+type VersionTag string
+
+const (
+	VersionRemix        VersionTag = "remix"
+	VersionLive         VersionTag = "live"
+	VersionRemaster     VersionTag = "remaster"
+	VersionEdit         VersionTag = "edit"
+	VersionFeat         VersionTag = "feat"
+	VersionAcoustic     VersionTag = "acoustic"
+	VersionInstrumental VersionTag = "instrumental"
+
+	// any other "version" that is the same recording, such as "Single Version" or "Mono"
+	VersionAlternate VersionTag = "alternate"
+
+	// the original version, written out as in "Original Mix" to tell it apart from remixes
+	VersionOriginal VersionTag = "original"
+)
+
+// Tags of versions that are separate recordings of a song rather than the same recording
+// released again (remastered, edited, released as a single, etc...).
+var distinctRecordingTags = []VersionTag{VersionRemix, VersionLive, VersionAcoustic, VersionInstrumental}
+
+// The result of parsing a track title into the title of the song and the tags of the version.
+type ParsedTitle struct {
+	// title with all version information removed
+	Base string
+
+	// sorted, without duplicates
+	Tags []VersionTag
+
+	// names of featured artists mentioned in the title
+	Featured []string
+}
+
+var (
+	titleBracketsRegex = regexp.MustCompile(`\s*[\(\[]([^\(\)\[\]]*)[\)\]]`)
+	titleDashRegex     = regexp.MustCompile(`\s+[-–—]\s+`)
+	titleFeatRegex     = regexp.MustCompile(`(?i)\s+(feat\.?|ft\.?|featuring)\s+`)
+	featSplitRegex     = regexp.MustCompile(`(?i)\s*(,|&|\band\b)\s*`)
+)
+
+// Split a string into lowercase words consisting of letters and digits only.
+func words(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Extract featured artists from a segment such as "feat. X & Y". The second return
+// value is false if the segment doesn't mention featured artists.
+func parseFeatured(segment string, allowWith bool) ([]string, bool) {
+	lower := strings.ToLower(strings.TrimSpace(segment))
+
+	prefixes := []string{"featuring ", "feat. ", "feat ", "ft. ", "ft "}
+	if allowWith {
+		prefixes = append(prefixes, "with ")
+	}
+
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(lower, prefix) {
+			names := make([]string, 0)
+			for _, name := range featSplitRegex.Split(strings.TrimSpace(segment)[len(prefix):], -1) {
+				if name = strings.TrimSpace(name); name != "" {
+					names = append(names, name)
+				}
+			}
+
+			return names, true
+		}
+	}
+
+	return nil, false
+}
+
+// Words that may follow the version keyword at the start of a version phrase, as in "Live at Wembley".
+var versionPhrasePrepositions = []string{"at", "from", "in", "on", "for", "by", "with"}
+
+// Return the tag of a word of a version phrase, or "" if the word doesn't describe a version.
+func versionWordTag(word string) VersionTag {
+	switch {
+	case word == "remix" || word == "mix" || word == "rework" || word == "bootleg" || word == "dub":
+		return VersionRemix
+	case word == "live" || word == "unplugged":
+		return VersionLive
+	case strings.HasPrefix(word, "remaster"):
+		return VersionRemaster
+	case word == "edit":
+		return VersionEdit
+	case word == "acoustic":
+		return VersionAcoustic
+	case word == "instrumental":
+		return VersionInstrumental
+	case word == "version" || word == "mono" || word == "stereo":
+		return VersionAlternate
+	}
+
+	return ""
+}
+
+func isYear(word string) bool {
+	if len(word) != 4 {
+		return false
+	}
+
+	for _, r := range word {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Classify a bracketed or dash-separated segment of a title. Only segments that are version phrases as a
+// whole yield tags: ones ending in version keywords, optionally followed by a year, as in "Radio Edit" or
+// "Remastered 2011", or starting with one followed by a preposition, as in "Live at Wembley". Segments
+// that merely contain a keyword, such as "Live Forever", or that don't describe a version at all, such
+// as "(Part 2)", yield no tags.
+func classifySegment(segment string) []VersionTag {
+	segmentWords := words(segment)
+	for len(segmentWords) > 0 && isYear(segmentWords[len(segmentWords)-1]) {
+		segmentWords = segmentWords[:len(segmentWords)-1]
+	}
+
+	if len(segmentWords) == 0 {
+		return nil
+	}
+
+	if len(segmentWords) == 2 && segmentWords[0] == "original" && (segmentWords[1] == "mix" || segmentWords[1] == "version") {
+		return []VersionTag{VersionOriginal}
+	}
+
+	// the trailing run of keywords, as in "Live / Remastered", or the leading keyword of "Live at ..."
+	var phrase []string
+	if versionWordTag(segmentWords[len(segmentWords)-1]) != "" {
+		start := len(segmentWords) - 1
+		for start > 0 && versionWordTag(segmentWords[start-1]) != "" {
+			start--
+		}
+
+		phrase = segmentWords[start:]
+	} else if len(segmentWords) > 1 && versionWordTag(segmentWords[0]) != "" && slices.Contains(versionPhrasePrepositions, segmentWords[1]) {
+		phrase = segmentWords[:1]
+	}
+
+	found := make([]VersionTag, 0)
+	for _, word := range phrase {
+		found = append(found, versionWordTag(word))
+	}
+
+	return found
+}
+
+// Parse a track title into the title of the song, the tags of the version and any featured artists.
+// Version information is recognized in brackets ("Song (Radio Edit)") and after dashes
+// ("Song - Live"). Segments that aren't recognized are kept as part of the base title.
+func ParseTitle(title string) ParsedTitle {
+	var parsed ParsedTitle
+
+	addTags := func(tags ...VersionTag) {
+		for _, tag := range tags {
+			if !slices.Contains(parsed.Tags, tag) {
+				parsed.Tags = append(parsed.Tags, tag)
+			}
+		}
+	}
+
+	// bracketed segments
+	base := titleBracketsRegex.ReplaceAllStringFunc(title, func(match string) string {
+		segment := titleBracketsRegex.FindStringSubmatch(match)[1]
+
+		if featured, ok := parseFeatured(segment, true); ok {
+			parsed.Featured = append(parsed.Featured, featured...)
+			addTags(VersionFeat)
+			return ""
+		}
+
+		if tags := classifySegment(segment); len(tags) > 0 {
+			addTags(tags...)
+			return ""
+		}
+
+		return match
+	})
+
+	// dash-separated segments, the first of which is always part of the base title
+	parts := titleDashRegex.Split(base, -1)
+	kept := parts[:1]
+	for _, part := range parts[1:] {
+		if featured, ok := parseFeatured(part, false); ok {
+			parsed.Featured = append(parsed.Featured, featured...)
+			addTags(VersionFeat)
+			continue
+		}
+
+		if tags := classifySegment(part); len(tags) > 0 {
+			addTags(tags...)
+			continue
+		}
+
+		kept = append(kept, part)
+	}
+
+	base = strings.Join(kept, " - ")
+
+	// featured artists written inline, e.g. "Song feat. Someone"
+	if loc := titleFeatRegex.FindStringIndex(base); loc != nil {
+		featured, _ := parseFeatured(strings.TrimSpace(base[loc[0]:]), false)
+		parsed.Featured = append(parsed.Featured, featured...)
+		addTags(VersionFeat)
+		base = base[:loc[0]]
+	}
+
+	parsed.Base = strings.TrimSpace(base)
+	if parsed.Base == "" {
+		parsed.Base = strings.TrimSpace(title)
+	}
+
+	slices.Sort(parsed.Tags)
+
+	return parsed
+}
+
+// Return true if the parsed title has the specified version tag.
+func (title ParsedTitle) Has(tag VersionTag) bool {
+	return slices.Contains(title.Tags, tag)
+}
+
+// Return true if the title describes a separate recording of a song (remix, live, acoustic
+// or instrumental version) rather than the original one.
+func (title ParsedTitle) IsAlternateRecording() bool {
+	for _, tag := range distinctRecordingTags {
+		if title.Has(tag) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Normalize a title or a name for comparison: lowercase, "&" spelled out,
+// punctuation removed and whitespace collapsed.
+func NormalizeTitle(title string) string {
+	title = strings.ReplaceAll(title, "&", " and ")
+	return strings.Join(words(title), " ")
+}
+
+// Return the key identifying the recording of a track by its normalized base title, the
+// name of its main artist and the tags of the version, if it is a separate recording. Tracks
+// without a title, such as ones whose metadata couldn't be obtained, have no key.
+func RecordingKey(track *Track) string {
+	if strings.TrimSpace(track.Title) == "" {
+		return ""
+	}
+
+	parsed := ParseTitle(track.Title)
+
+	artist := ""
+	if len(track.Artists) > 0 {
+		artist = track.Artists[0].Name
+	}
+
+	key := NormalizeTitle(parsed.Base) + "|" + NormalizeTitle(artist)
+	for _, tag := range parsed.Tags {
+		if slices.Contains(distinctRecordingTags, tag) {
+			key += "|" + string(tag)
+		}
+	}
+
+	return key
+}
+
+// A group of tracks that are all releases of the same recording, e.g. the album version of a song,
+// its single release and a remastered reissue.
+type Recording struct {
+	// recording key of the first track of the group
+	Key string
+
+	// base title of the first track of the group
+	Title  string
+	Tracks []Track
+}
+
+// Group tracks into recordings. Tracks are considered the same recording if they share an ISRC,
+// or if they have the same recording key (see RecordingKey()). Tracks without a recording key are
+// recordings of their own, keyed by their Spotify id. Recordings are returned in order of their
+// first track's appearance in tracks.
+func GroupRecordings(tracks []Track) []Recording {
+	// union-find over indices of tracks
+	parent := make([]int, len(tracks))
+	for idx := range parent {
+		parent[idx] = idx
+	}
+
+	var find func(idx int) int
+	find = func(idx int) int {
+		if parent[idx] != idx {
+			parent[idx] = find(parent[idx])
+		}
+
+		return parent[idx]
+	}
+
+	union := func(a, b int) {
+		rootA, rootB := find(a), find(b)
+		// the earlier track always becomes the root, so groups keep the order of appearance
+		if rootA < rootB {
+			parent[rootB] = rootA
+		} else if rootB < rootA {
+			parent[rootA] = rootB
+		}
+	}
+
+	keys := make([]string, len(tracks))
+	byIsrc := make(map[string]int)
+	byKey := make(map[string]int)
+
+	for idx := range tracks {
+		keys[idx] = RecordingKey(&tracks[idx])
+		if keys[idx] == "" {
+			// recording keys always contain a "|", so they never collide with a Spotify id
+			keys[idx] = tracks[idx].SpotifyId
+		}
+
+		if isrc := strings.ToUpper(tracks[idx].Isrc); isrc != "" {
+			if first, ok := byIsrc[isrc]; ok {
+				union(first, idx)
+			} else {
+				byIsrc[isrc] = idx
+			}
+		}
+
+		if first, ok := byKey[keys[idx]]; ok {
+			union(first, idx)
+		} else {
+			byKey[keys[idx]] = idx
+		}
+	}
+
+	recordings := make([]Recording, 0)
+	recordingOf := make(map[int]int)
+
+	for idx := range tracks {
+		root := find(idx)
+
+		recordingIdx, ok := recordingOf[root]
+		if !ok {
+			recordingIdx = len(recordings)
+			recordingOf[root] = recordingIdx
+			recordings = append(recordings, Recording{
+				Key:   keys[root],
+				Title: ParseTitle(tracks[root].Title).Base,
+			})
+		}
+
+		recordings[recordingIdx].Tracks = append(recordings[recordingIdx].Tracks, tracks[idx])
+	}
+
+	return recordings
+}
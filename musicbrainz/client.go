@@ -0,0 +1,480 @@
+// The musicbrainz package implements a music.ResourceProvider backed by the MusicBrainz
+// web service (or any compatible mirror), and is used to attach MusicBrainz identifiers
+// to resources obtained from other providers by their ISRC and UPC codes.
+//
+// Ids accepted and returned by this provider are MusicBrainz ids (MBIDs). Tracks
+// map to MusicBrainz recordings, albums to releases and artists to artists.
+package musicbrainz
+
+import (
+	music "bool3max/musicdash/music"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultBaseUrl = "https://musicbrainz.org/ws/2/"
+
+// The MusicBrainz API allows for an average of one request per second per client.
+const DefaultRateLimit = time.Second
+
+// Maximum value of the "limit" parameter accepted by browse and search requests.
+const apiMaxLimit = 100
+
+var (
+	ErrNotFound    = errors.New("resource not found on musicbrainz")
+	ErrRateLimited = errors.New("status 503: rate limited")
+)
+
+type Client struct {
+	// base url of the web service, e.g. DefaultBaseUrl or the address of a local mirror
+	BaseUrl string
+
+	// MusicBrainz requires every application to identify itself with a meaningful User-Agent
+	UserAgent string
+
+	// minimum amount of time between two consecutive requests, 0 disables rate limiting
+	RateLimit time.Duration
+
+	HTTPClient *http.Client
+
+	mu            sync.Mutex
+	lastRequestAt time.Time
+}
+
+// Create a new *Client talking to the web service at baseUrl. If baseUrl is empty,
+// DefaultBaseUrl is used.
+func NewClient(baseUrl, userAgent string) *Client {
+	if baseUrl == "" {
+		baseUrl = DefaultBaseUrl
+	}
+
+	if !strings.HasSuffix(baseUrl, "/") {
+		baseUrl += "/"
+	}
+
+	return &Client{
+		BaseUrl:    baseUrl,
+		UserAgent:  userAgent,
+		RateLimit:  DefaultRateLimit,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Return a client of the web service at MUSICDASH_MUSICBRAINZ_API_URL, or at DefaultBaseUrl if it isn't set,
+// identifying itself with MUSICDASH_MUSICBRAINZ_USER_AGENT, or nil if MUSICDASH_MUSICBRAINZ_USER_AGENT isn't
+// set, as MusicBrainz requires applications to identify themselves.
+func FromEnv() *Client {
+	userAgent := os.Getenv("MUSICDASH_MUSICBRAINZ_USER_AGENT")
+	if userAgent == "" {
+		return nil
+	}
+
+	return NewClient(os.Getenv("MUSICDASH_MUSICBRAINZ_API_URL"), userAgent)
+}
+
+// block until another request may be performed without exceeding client.RateLimit
+func (client *Client) wait() {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	if client.RateLimit <= 0 {
+		return
+	}
+
+	if since := time.Since(client.lastRequestAt); since < client.RateLimit {
+		time.Sleep(client.RateLimit - since)
+	}
+
+	client.lastRequestAt = time.Now()
+}
+
+// helper function that performs a GET request to the specified path (relative to client.BaseUrl)
+// with the specified query parameters and decodes the JSON body to the specified destination
+func (client *Client) jsonGetHelper(path string, query url.Values, decodeTo any) error {
+	if query == nil {
+		query = url.Values{}
+	}
+
+	query.Set("fmt", "json")
+
+	req, err := http.NewRequest("GET", client.BaseUrl+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("User-Agent", client.UserAgent)
+
+	client.wait()
+
+	response, err := client.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusBadRequest:
+		// MusicBrainz answers lookups of malformed MBIDs with 400
+		return ErrNotFound
+	case http.StatusServiceUnavailable, http.StatusTooManyRequests:
+		return ErrRateLimited
+	default:
+		return fmt.Errorf("musicbrainz status code: %d", response.StatusCode)
+	}
+
+	return json.NewDecoder(response.Body).Decode(decodeTo)
+}
+
+// Escape a string for use as a term in a Lucene search query.
+func luceneEscape(term string) string {
+	replacer := strings.NewReplacer(
+		`\`, `\\`, `+`, `\+`, `-`, `\-`, `&`, `\&`, `|`, `\|`, `!`, `\!`, `(`, `\(`, `)`, `\)`,
+		`{`, `\{`, `}`, `\}`, `[`, `\[`, `]`, `\]`, `^`, `\^`, `"`, `\"`, `~`, `\~`, `*`, `\*`,
+		`?`, `\?`, `:`, `\:`, `/`, `\/`,
+	)
+
+	return replacer.Replace(term)
+}
+
+func (client *Client) GetTrackById(id string) (*music.Track, error) {
+	var recording recording
+	err := client.jsonGetHelper(
+		"recording/"+url.PathEscape(id),
+		url.Values{"inc": {"artist-credits releases release-groups media isrcs"}},
+		&recording,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	dbTrack := recording.toDB()
+
+	return &dbTrack, nil
+}
+
+func (client *Client) GetSeveralTracksById(ids []string) ([]music.Track, error) {
+	tracks := make([]music.Track, len(ids))
+	for idx, id := range ids {
+		track, err := client.GetTrackById(id)
+		if err != nil {
+			return nil, err
+		}
+
+		tracks[idx] = *track
+	}
+
+	return tracks, nil
+}
+
+func (client *Client) GetTrackByMatch(iden string) (*music.Track, error) {
+	var response struct {
+		Recordings []recording `json:"recordings"`
+	}
+
+	err := client.jsonGetHelper(
+		"recording",
+		url.Values{"query": {luceneEscape(iden)}, "limit": {"1"}},
+		&response,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(response.Recordings) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return client.GetTrackById(response.Recordings[0].Id)
+}
+
+func (client *Client) GetAlbumById(id string) (*music.Album, error) {
+	var release release
+	err := client.jsonGetHelper(
+		"release/"+url.PathEscape(id),
+		url.Values{"inc": {"artist-credits recordings release-groups isrcs"}},
+		&release,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	dbAlbum := release.toDB()
+
+	return &dbAlbum, nil
+}
+
+func (client *Client) GetSeveralAlbumsById(ids []string) ([]music.Album, error) {
+	albums := make([]music.Album, len(ids))
+	for idx, id := range ids {
+		album, err := client.GetAlbumById(id)
+		if err != nil {
+			return nil, err
+		}
+
+		albums[idx] = *album
+	}
+
+	return albums, nil
+}
+
+func (client *Client) GetAlbumByMatch(iden string) (*music.Album, error) {
+	var response struct {
+		Releases []release `json:"releases"`
+	}
+
+	err := client.jsonGetHelper(
+		"release",
+		url.Values{"query": {luceneEscape(iden)}, "limit": {"1"}},
+		&response,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(response.Releases) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return client.GetAlbumById(response.Releases[0].Id)
+}
+
+func (client *Client) GetArtistById(id string, discogFillLevel int, albumTypes []music.AlbumType) (*music.Artist, error) {
+	var artist artist
+	if err := client.jsonGetHelper("artist/"+url.PathEscape(id), nil, &artist); err != nil {
+		return nil, err
+	}
+
+	dbArtist := artist.toDB()
+
+	if discogFillLevel > 0 {
+		if err := dbArtist.FillDiscography(client, albumTypes, discogFillLevel > 1); err != nil {
+			return nil, err
+		}
+	}
+
+	return &dbArtist, nil
+}
+
+func (client *Client) GetArtistByMatch(iden string, discogFillLevel int, albumTypes []music.AlbumType) (*music.Artist, error) {
+	var response struct {
+		Artists []artist `json:"artists"`
+	}
+
+	err := client.jsonGetHelper(
+		"artist",
+		url.Values{"query": {luceneEscape(iden)}, "limit": {"1"}},
+		&response,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(response.Artists) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return client.GetArtistById(response.Artists[0].Id, discogFillLevel, albumTypes)
+}
+
+// Browse all official releases matching the query, following the offset until all have been fetched.
+func (client *Client) browseReleases(query url.Values) ([]release, error) {
+	releases := make([]release, 0)
+
+	for offset := 0; ; offset += apiMaxLimit {
+		var response struct {
+			ReleaseCount int       `json:"release-count"`
+			Releases     []release `json:"releases"`
+		}
+
+		query.Set("status", "official")
+		query.Set("inc", "artist-credits release-groups media")
+		query.Set("limit", fmt.Sprint(apiMaxLimit))
+		query.Set("offset", fmt.Sprint(offset))
+
+		if err := client.jsonGetHelper("release", query, &response); err != nil {
+			return nil, err
+		}
+
+		releases = append(releases, response.Releases...)
+
+		if len(response.Releases) == 0 || offset+apiMaxLimit >= response.ReleaseCount {
+			return releases, nil
+		}
+	}
+}
+
+// Return all official releases by the artist whose release group matches one of the includeGroups.
+// Releases credited to other artists on which the artist appears on some tracks make up the
+// appears_on group. If includeGroups is nil, {"album"} is assumed. Returned albums don't have their
+// tracklists filled, and the discography is sorted with music.SortDiscography().
+func (client *Client) GetArtistDiscography(artist *music.Artist, includeGroups []music.AlbumType) ([]music.Album, error) {
+	if includeGroups == nil {
+		includeGroups = []music.AlbumType{music.AlbumRegular}
+	}
+
+	artistId := artist.ExternalIds.Get(music.ProviderMusicBrainz)
+
+	// MusicBrainz calls EPs what Spotify files under singles
+	releaseTypes := make([]string, 0, len(includeGroups))
+	for _, group := range includeGroups {
+		switch group {
+		case music.AlbumRegular:
+			releaseTypes = append(releaseTypes, "album")
+		case music.AlbumSingle:
+			releaseTypes = append(releaseTypes, "single", "ep")
+		case music.AlbumCompilation:
+			releaseTypes = append(releaseTypes, "compilation")
+		}
+	}
+
+	discog := make([]music.Album, 0)
+	seen := make(map[string]bool)
+
+	if len(releaseTypes) > 0 {
+		releases, err := client.browseReleases(url.Values{
+			"artist": {artistId},
+			"type":   {strings.Join(releaseTypes, "|")},
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, release := range releases {
+			album := release.toDB()
+
+			// browsing by type filters on the primary type only, a release group marked
+			// as a compilation is only included if compilations were asked for
+			if seen[release.Id] || !slices.Contains(includeGroups, album.Type) {
+				continue
+			}
+
+			album.Group = album.Type
+			discog = append(discog, album)
+			seen[release.Id] = true
+		}
+	}
+
+	if slices.Contains(includeGroups, music.AlbumAppearsOn) {
+		releases, err := client.browseReleases(url.Values{"track_artist": {artistId}})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, release := range releases {
+			// releases credited to the artist are in one of the other groups
+			credited := slices.ContainsFunc(release.ArtistCredit, func(credit artistCredit) bool {
+				return credit.Artist.Id == artistId
+			})
+
+			if seen[release.Id] || credited {
+				continue
+			}
+
+			album := release.toDB()
+			album.Group = music.AlbumAppearsOn
+			discog = append(discog, album)
+			seen[release.Id] = true
+		}
+	}
+
+	music.SortDiscography(discog)
+
+	return discog, nil
+}
+
+// Return all tracks on the release identified by the album's MusicBrainz id.
+func (client *Client) GetAlbumTracklist(album *music.Album) ([]music.Track, error) {
+	full, err := client.GetAlbumById(album.ExternalIds.Get(music.ProviderMusicBrainz))
+	if err != nil {
+		return nil, err
+	}
+
+	return full.Tracks, nil
+}
+
+// Return all recordings that have been assigned the specified ISRC.
+func (client *Client) GetTracksByIsrc(isrc string) ([]music.Track, error) {
+	var response struct {
+		Recordings []recording `json:"recordings"`
+	}
+
+	err := client.jsonGetHelper(
+		"isrc/"+url.PathEscape(isrc),
+		url.Values{"inc": {"artist-credits releases release-groups"}},
+		&response,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	tracks := make([]music.Track, len(response.Recordings))
+	for idx, recording := range response.Recordings {
+		tracks[idx] = recording.toDB()
+	}
+
+	return tracks, nil
+}
+
+// Whether two barcodes are the same code, ignoring leading zeros, so that the 12-digit UPC-A and the
+// 13-digit EAN-13 forms of a code match.
+func sameBarcode(a, b string) bool {
+	a, b = strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0")
+	return a != "" && a == b
+}
+
+// Return all releases carrying the specified barcode (UPC or EAN), in either its 12-digit UPC-A or
+// its 13-digit EAN-13 form.
+func (client *Client) GetAlbumsByBarcode(barcode string) ([]music.Album, error) {
+	var response struct {
+		Releases []release `json:"releases"`
+	}
+
+	// MusicBrainz stores barcodes as printed, so both forms are searched for
+	terms := make([]string, 0, 2)
+	for _, length := range []int{12, 13} {
+		form := fmt.Sprintf("%0*s", length, strings.TrimLeft(barcode, "0"))
+		if !slices.Contains(terms, "barcode:"+luceneEscape(form)) {
+			terms = append(terms, "barcode:"+luceneEscape(form))
+		}
+	}
+
+	err := client.jsonGetHelper(
+		"release",
+		url.Values{"query": {strings.Join(terms, " OR ")}, "limit": {fmt.Sprint(apiMaxLimit)}},
+		&response,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	albums := make([]music.Album, 0, len(response.Releases))
+	for _, release := range response.Releases {
+		// the search is fuzzy, only exact barcode matches are of interest
+		if !sameBarcode(release.Barcode, barcode) {
+			continue
+		}
+
+		albums = append(albums, release.toDB())
+	}
+
+	return albums, nil
+}
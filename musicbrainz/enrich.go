@@ -0,0 +1,78 @@
+package musicbrainz
+
+import (
+	music "bool3max/musicdash/music"
+)
+
+// Attach MusicBrainz ids to a track (and its performing artists) obtained from a different
+// provider, by looking up the recording that has been assigned the track's ISRC.
+// If more than one recording shares the ISRC, the one whose title matches the track's is
+// preferred. Artists are matched to the recording's artist credits by name. Returns
+// ErrNotFound if the track has no ISRC or no recording carries it.
+func (client *Client) EnrichTrack(track *music.Track) error {
+	if track.Isrc == "" {
+		return ErrNotFound
+	}
+
+	recordings, err := client.GetTracksByIsrc(track.Isrc)
+	if err != nil {
+		return err
+	}
+
+	if len(recordings) == 0 {
+		return ErrNotFound
+	}
+
+	match := recordings[0]
+	for _, recording := range recordings {
+		if namesMatch(recording.Title, track.Title) {
+			match = recording
+			break
+		}
+	}
+
+	track.ExternalIds.Set(music.ProviderMusicBrainz, match.ExternalIds.Get(music.ProviderMusicBrainz))
+	attachArtistIds(track.Artists, match.Artists)
+
+	return nil
+}
+
+// Attach MusicBrainz ids to an album (and its artists) obtained from a different provider,
+// by looking up the release carrying the album's UPC (or EAN, if the album has no UPC).
+// Returns ErrNotFound if the album has no barcode or no release carries it.
+func (client *Client) EnrichAlbum(album *music.Album) error {
+	barcode := album.Upc
+	if barcode == "" {
+		barcode = album.Ean
+	}
+
+	if barcode == "" {
+		return ErrNotFound
+	}
+
+	releases, err := client.GetAlbumsByBarcode(barcode)
+	if err != nil {
+		return err
+	}
+
+	if len(releases) == 0 {
+		return ErrNotFound
+	}
+
+	album.ExternalIds.Set(music.ProviderMusicBrainz, releases[0].ExternalIds.Get(music.ProviderMusicBrainz))
+	attachArtistIds(album.Artists, releases[0].Artists)
+
+	return nil
+}
+
+// Copy MusicBrainz ids from credited artists to artists with the same name.
+func attachArtistIds(artists, credited []music.Artist) {
+	for artistIdx := range artists {
+		for _, creditedArtist := range credited {
+			if namesMatch(artists[artistIdx].Name, creditedArtist.Name) {
+				artists[artistIdx].ExternalIds.Set(music.ProviderMusicBrainz, creditedArtist.ExternalIds.Get(music.ProviderMusicBrainz))
+				break
+			}
+		}
+	}
+}
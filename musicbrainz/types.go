@@ -0,0 +1,180 @@
+package musicbrainz
+
+import (
+	music "bool3max/musicdash/music"
+	"slices"
+	"strings"
+	"time"
+)
+
+type artistCredit struct {
+	Name       string `json:"name"`
+	JoinPhrase string `json:"joinphrase"`
+	Artist     artist `json:"artist"`
+}
+
+type artist struct {
+	Id       string `json:"id"`
+	Name     string `json:"name"`
+	SortName string `json:"sort-name"`
+	Type     string `json:"type"`
+	Country  string `json:"country"`
+}
+
+func (artist artist) toDB() music.Artist {
+	return music.Artist{
+		Name:        artist.Name,
+		ExternalIds: music.ExternalIds{music.ProviderMusicBrainz: artist.Id},
+	}
+}
+
+type recording struct {
+	Id           string         `json:"id"`
+	Title        string         `json:"title"`
+	LengthMS     int            `json:"length"`
+	Isrcs        []string       `json:"isrcs"`
+	ArtistCredit []artistCredit `json:"artist-credit"`
+	Releases     []release      `json:"releases"`
+}
+
+func (recording recording) toDB() music.Track {
+	newTrack := music.Track{
+		Title:       recording.Title,
+		Duration:    time.Duration(recording.LengthMS) * time.Millisecond,
+		Artists:     creditsToDB(recording.ArtistCredit),
+		ExternalIds: music.ExternalIds{music.ProviderMusicBrainz: recording.Id},
+	}
+
+	if len(recording.Isrcs) > 0 {
+		newTrack.Isrc = recording.Isrcs[0]
+	}
+
+	// a recording may appear on many releases, the first one listed is used as the
+	// belonging album
+	if len(recording.Releases) > 0 {
+		newTrack.Album = recording.Releases[0].toDB()
+
+		// the release's media (if present) hold the position of the recording on it
+		for _, medium := range recording.Releases[0].Media {
+			for _, mediumTrack := range medium.Tracks {
+				newTrack.TracklistNum = mediumTrack.Position
+				newTrack.DiscNum = medium.Position
+			}
+		}
+	}
+
+	return newTrack
+}
+
+type releaseGroup struct {
+	Id             string   `json:"id"`
+	Title          string   `json:"title"`
+	PrimaryType    string   `json:"primary-type"`
+	SecondaryTypes []string `json:"secondary-types"`
+}
+
+// Map a MusicBrainz release group's primary and secondary types to a music.AlbumType.
+// Spotify files EPs under singles, so MusicBrainz does the same here.
+func (group releaseGroup) albumType() music.AlbumType {
+	if slices.Contains(group.SecondaryTypes, "Compilation") {
+		return music.AlbumCompilation
+	}
+
+	switch group.PrimaryType {
+	case "Single", "EP":
+		return music.AlbumSingle
+	default:
+		return music.AlbumRegular
+	}
+}
+
+type medium struct {
+	Position   int `json:"position"`
+	TrackCount int `json:"track-count"`
+	Tracks     []struct {
+		Id           string         `json:"id"`
+		Position     int            `json:"position"`
+		Title        string         `json:"title"`
+		LengthMS     int            `json:"length"`
+		ArtistCredit []artistCredit `json:"artist-credit"`
+		Recording    recording      `json:"recording"`
+	} `json:"tracks"`
+}
+
+type release struct {
+	Id           string         `json:"id"`
+	Title        string         `json:"title"`
+	Date         string         `json:"date"`
+	Barcode      string         `json:"barcode"`
+	Status       string         `json:"status"`
+	ArtistCredit []artistCredit `json:"artist-credit"`
+	ReleaseGroup releaseGroup   `json:"release-group"`
+	Media        []medium       `json:"media"`
+	TrackCount   int            `json:"track-count"`
+}
+
+func (release release) toDB() music.Album {
+	newAlbum := music.Album{
+		Title:       release.Title,
+		Artists:     creditsToDB(release.ArtistCredit),
+		ReleaseDate: parseDate(release.Date),
+		Upc:         release.Barcode,
+		Type:        release.ReleaseGroup.albumType(),
+		ExternalIds: music.ExternalIds{music.ProviderMusicBrainz: release.Id},
+		CountTracks: release.TrackCount,
+	}
+
+	// only a release lookup with inc=recordings fills in the tracks of each medium
+	for _, medium := range release.Media {
+		if release.TrackCount == 0 {
+			newAlbum.CountTracks += medium.TrackCount
+		}
+
+		for _, mediumTrack := range medium.Tracks {
+			track := mediumTrack.Recording.toDB()
+			track.TracklistNum = mediumTrack.Position
+			track.DiscNum = medium.Position
+
+			// the track credit may differ from the one of the underlying recording
+			if len(mediumTrack.ArtistCredit) > 0 {
+				track.Artists = creditsToDB(mediumTrack.ArtistCredit)
+			}
+
+			// simple copy of the album, see spotify.Client.GetAlbumById()
+			track.Album = music.Album{
+				Title:       release.Title,
+				ExternalIds: music.ExternalIds{music.ProviderMusicBrainz: release.Id},
+			}
+
+			newAlbum.Tracks = append(newAlbum.Tracks, track)
+		}
+	}
+
+	return newAlbum
+}
+
+func creditsToDB(credits []artistCredit) []music.Artist {
+	dbArtists := make([]music.Artist, len(credits))
+	for idx, credit := range credits {
+		dbArtists[idx] = credit.Artist.toDB()
+	}
+
+	return dbArtists
+}
+
+// MusicBrainz dates may be partial: "2011", "2011-04" or "2011-04-25".
+func parseDate(date string) time.Time {
+	for _, layout := range []string{time.DateOnly, "2006-01", "2006"} {
+		if parsed, err := time.Parse(layout, date); err == nil {
+			return parsed
+		}
+	}
+
+	return time.Time{}
+}
+
+// Case- and whitespace-insensitive comparison of two artist names, used when
+// attaching MusicBrainz ids to artists that were obtained from another provider.
+func namesMatch(a, b string) bool {
+	return strings.EqualFold(strings.TrimSpace(a), strings.TrimSpace(b))
+}
@@ -2,6 +2,7 @@ package spotify
 
 import (
 	music "bool3max/musicdash/music"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -15,6 +16,7 @@ import (
 )
 
 const API_MAX_PER_REQUEST_ALBUM = 20
+const API_MAX_PER_REQUEST_TRACK = 50
 
 const (
 	endpointTrack  = "https://api.spotify.com/v1/tracks/"
@@ -39,6 +41,8 @@ var (
 	ErrRateLimited               = errors.New("status 429: rate limited")
 	ErrUserNotPlaying            = errors.New("user not playing anything")
 	ErrInvalidAuthFlowForRequest = errors.New("invalid client authentication flow for request")
+	// returned by the <Resource>ByMatch methods when the search yields no resource of the type
+	ErrNoSearchResults = errors.New("no search results")
 )
 
 // An authenticated client used to interact with the API
@@ -336,6 +340,22 @@ func (spot *Client) GetTrackById(id string) (*music.Track, error) {
 }
 
 func (spot *Client) GetSeveralTracksById(ids []string) ([]music.Track, error) {
+	// if number of ids requested is more than allowed by api, split slice into
+	// chunks of max allowed size
+	if len(ids) > API_MAX_PER_REQUEST_TRACK {
+		all := make([]music.Track, 0, len(ids))
+		for startIdx := 0; startIdx < len(ids); startIdx += API_MAX_PER_REQUEST_TRACK {
+			batch, err := spot.GetSeveralTracksById(ids[startIdx:min(startIdx+API_MAX_PER_REQUEST_TRACK, len(ids))])
+			if err != nil {
+				return nil, err
+			}
+
+			all = append(all, batch...)
+		}
+
+		return all, nil
+	}
+
 	requestUrl := endpointTrack + "?" + url.Values{"ids": {strings.Join(ids, ",")}}.Encode()
 	var result struct {
 		Tracks []track `json:"tracks"`
@@ -360,11 +380,60 @@ func (spot *Client) GetTrackByMatch(iden string) (*music.Track, error) {
 		return nil, err
 	}
 
+	if len(search.Tracks) == 0 {
+		return nil, ErrNoSearchResults
+	}
+
 	firstResultId := search.Tracks[0].SpotifyId
 
 	return spot.GetTrackById(firstResultId)
 }
 
+// Search for tracks matching a structured query through Spotify's field filters, returning no error if
+// there are none. If the query has an ISRC, tracks with it are searched for first. Implements
+// music.TrackSearcher.
+func (spot *Client) SearchTracks(ctx context.Context, query music.MatchQuery, limit int) ([]music.Track, error) {
+	// quotes can't be escaped within field filters
+	quote := func(s string) string {
+		return `"` + strings.ReplaceAll(s, `"`, "") + `"`
+	}
+
+	queries := make([]string, 0, 2)
+	if query.Isrc != "" {
+		queries = append(queries, "isrc:"+query.Isrc)
+	}
+
+	queries = append(queries, "track:"+quote(music.ParseTitle(query.Title).Base)+" artist:"+quote(query.Artist))
+
+	tracks := make([]music.Track, 0, limit)
+	for _, q := range queries {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		searchQuery := url.Values{
+			"q":     {q},
+			"limit": {strconv.Itoa(limit)},
+			"type":  {"track"},
+		}.Encode()
+
+		var searchResults searchResponse
+		if _, err := spot.jsonGetHelper(endpointSearch+"?"+searchQuery, &searchResults); err != nil {
+			return nil, err
+		}
+
+		for _, track := range searchResults.Tracks.Items {
+			tracks = append(tracks, track.toDB())
+		}
+
+		if len(tracks) > 0 {
+			break
+		}
+	}
+
+	return tracks, nil
+}
+
 func (spot *Client) GetArtistById(id string, discogFillLevel int, albumTypes []music.AlbumType) (*music.Artist, error) {
 	var artist artist
 	if _, err := spot.jsonGetHelper(endpointArtist+id, &artist); err != nil {
@@ -388,6 +457,10 @@ func (spot *Client) GetArtistByMatch(iden string, discogFillLevel int, albumType
 		return nil, err
 	}
 
+	if len(search.Artists) == 0 {
+		return nil, ErrNoSearchResults
+	}
+
 	firstResultId := search.Artists[0].SpotifyId
 	artist, err := spot.GetArtistById(firstResultId, discogFillLevel, albumTypes)
 	if err != nil {
@@ -466,6 +539,10 @@ func (spot *Client) GetAlbumByMatch(iden string) (*music.Album, error) {
 		return nil, fmt.Errorf("error searching for album: %w", err)
 	}
 
+	if len(search.Albums) == 0 {
+		return nil, ErrNoSearchResults
+	}
+
 	firstResultId := search.Albums[0].SpotifyId
 
 	return spot.GetAlbumById(firstResultId)
@@ -475,38 +552,72 @@ func (spot *Client) GetAlbumByMatch(iden string) (*music.Album, error) {
 // that api.GetArtistBy<IdentifierType> does not return any discography information.
 // includeGroups specifies types of albums to include in the response and can contain
 // any of the following, at most once: album, single, appears_on, compilation
-// If includeGroups is nil, {"album"} is assumed
+// If includeGroups is nil, {"album"} is assumed. Every returned album has its Group set,
+// and the discography is sorted with music.SortDiscography().
 func (spot *Client) GetArtistDiscography(artist *music.Artist, includeGroups []music.AlbumType) ([]music.Album, error) {
 	if includeGroups == nil {
 		includeGroups = []music.AlbumType{music.AlbumRegular}
 	}
 
-	var response struct {
-		Items []album
+	var albumIds []string
+	albumGroups := make(map[string]music.AlbumType)
 
-		Href   string `json:"href"`
-		Next   string `json:"next"`
-		Offset int    `json:"offset"`
-		Limit  int    `json:"limit"`
-	}
-
-	queryParams := url.Values{
+	requestUrl := endpointArtist + artist.SpotifyId + "/albums?" + url.Values{
 		"limit":          {"50"},
 		"include_groups": {music.IncludeGroupToString(includeGroups)},
 	}.Encode()
 
-	_, err := spot.jsonGetHelper(endpointArtist+artist.SpotifyId+"/albums?"+queryParams, &response)
+	// follow "next" until all pages of the discography have been fetched
+	for requestUrl != "" {
+		var response struct {
+			Items []album
+
+			Href   string `json:"href"`
+			Next   string `json:"next"`
+			Offset int    `json:"offset"`
+			Limit  int    `json:"limit"`
+		}
+
+		_, err := spot.jsonGetHelper(requestUrl, &response)
+		if err != nil {
+			return nil, err
+		}
+
+		// collect ids of all albums in discography to slice
+		for _, album := range response.Items {
+			if _, ok := albumGroups[album.Id]; ok {
+				continue
+			}
+
+			group, err := music.ParseAlbumType(album.Group)
+			if err != nil {
+				return nil, fmt.Errorf("unexpected album group {%s} of album {%s}: %w", album.Group, album.Id, err)
+			}
+
+			albumIds = append(albumIds, album.Id)
+			albumGroups[album.Id] = group
+		}
+
+		requestUrl = response.Next
+	}
+
+	if len(albumIds) == 0 {
+		return []music.Album{}, nil
+	}
+
+	discog, err := spot.GetSeveralAlbumsById(albumIds)
 	if err != nil {
 		return nil, err
 	}
 
-	// collect ids of all albums in discography to slice
-	albumIds := make([]string, len(response.Items))
-	for albumIdx, album := range response.Items {
-		albumIds[albumIdx] = album.Id
+	// full album objects don't carry the album group
+	for idx := range discog {
+		discog[idx].Group = albumGroups[discog[idx].SpotifyId]
 	}
 
-	return spot.GetSeveralAlbumsById(albumIds)
+	music.SortDiscography(discog)
+
+	return discog, nil
 }
 
 // Given an Album, return all of its tracks. This method is necessary
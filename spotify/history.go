@@ -0,0 +1,174 @@
+package spotify
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Spotify only counts a stream as a play once it's been listened to for at least this long
+const MinHistoryPlayDuration = 30 * time.Second
+
+var ErrInvalidStreamingHistory = errors.New("invalid streaming history file")
+
+// names of the files of Spotify's privacy export containing the extended streaming history: the current
+// Streaming_History_Audio_*.json, and the older endsong_*.json
+var historyFileRegex = regexp.MustCompile(`^(Streaming_History_Audio_.*|endsong_\d+)\.json$`)
+
+// A single stream from Spotify's Extended Streaming History, part of the data export requested through
+// the account's privacy settings. Streams of podcast episodes and audiobooks have no TrackId.
+type HistoryEntry struct {
+	// when the stream ended
+	At time.Time
+	// for how long the stream was listened to
+	Played time.Duration
+
+	TrackId    string
+	TrackName  string
+	ArtistName string
+	AlbumName  string
+
+	// why the stream started and ended, e.g. "clickrow", "fwdbtn", "trackdone"
+	ReasonStart string
+	ReasonEnd   string
+
+	Skipped   bool
+	Shuffle   bool
+	Offline   bool
+	Incognito bool
+}
+
+// Whether the stream counts as a play of a track: it's a track that was listened to for at least
+// minPlayed, and, unless includeSkipped is true, wasn't skipped.
+func (entry HistoryEntry) IsPlay(minPlayed time.Duration, includeSkipped bool) bool {
+	if entry.TrackId == "" || entry.Played < minPlayed {
+		return false
+	}
+
+	return includeSkipped || !entry.Skipped
+}
+
+// API format of a single history entry. Most fields may be null, and "skipped" is null in older exports.
+type historyEntry struct {
+	Ts          string  `json:"ts"`
+	MsPlayed    int64   `json:"ms_played"`
+	TrackName   *string `json:"master_metadata_track_name"`
+	ArtistName  *string `json:"master_metadata_album_artist_name"`
+	AlbumName   *string `json:"master_metadata_album_album_name"`
+	TrackUri    *string `json:"spotify_track_uri"`
+	ReasonStart *string `json:"reason_start"`
+	ReasonEnd   *string `json:"reason_end"`
+	Shuffle     *bool   `json:"shuffle"`
+	Skipped     *bool   `json:"skipped"`
+	Offline     *bool   `json:"offline"`
+	Incognito   *bool   `json:"incognito_mode"`
+}
+
+func deref[T any](ptr *T) T {
+	var zero T
+	if ptr == nil {
+		return zero
+	}
+
+	return *ptr
+}
+
+func (entry historyEntry) toHistoryEntry() (HistoryEntry, error) {
+	at, err := time.Parse(time.RFC3339, entry.Ts)
+	if err != nil {
+		return HistoryEntry{}, fmt.Errorf("%w: invalid timestamp %q", ErrInvalidStreamingHistory, entry.Ts)
+	}
+
+	return HistoryEntry{
+		At:          at,
+		Played:      time.Duration(entry.MsPlayed) * time.Millisecond,
+		TrackId:     strings.TrimPrefix(deref(entry.TrackUri), "spotify:track:"),
+		TrackName:   deref(entry.TrackName),
+		ArtistName:  deref(entry.ArtistName),
+		AlbumName:   deref(entry.AlbumName),
+		ReasonStart: deref(entry.ReasonStart),
+		ReasonEnd:   deref(entry.ReasonEnd),
+		Skipped:     deref(entry.Skipped),
+		Shuffle:     deref(entry.Shuffle),
+		Offline:     deref(entry.Offline),
+		Incognito:   deref(entry.Incognito),
+	}, nil
+}
+
+// Whether name is the name of a streaming history file, i.e. Streaming_History_Audio_*.json or
+// endsong_*.json. Directories leading up to the file are ignored.
+func IsStreamingHistoryFile(name string) bool {
+	return historyFileRegex.MatchString(path.Base(name))
+}
+
+// Parse a single streaming history file, calling fn with every entry in the order they appear in the
+// file. The file is decoded as a stream, so that it doesn't have to be loaded into memory at once.
+// Parsing stops at the first error returned by fn, which is then returned.
+func ParseStreamingHistory(r io.Reader, fn func(HistoryEntry) error) error {
+	decoder := json.NewDecoder(r)
+
+	if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+		return ErrInvalidStreamingHistory
+	}
+
+	for decoder.More() {
+		var raw historyEntry
+		if err := decoder.Decode(&raw); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidStreamingHistory, err)
+		}
+
+		entry, err := raw.toHistoryEntry()
+		if err != nil {
+			return err
+		}
+
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+
+	if _, err := decoder.Token(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidStreamingHistory, err)
+	}
+
+	return nil
+}
+
+// Parse all streaming history files contained in a zip archive, such as the one the privacy export is
+// delivered as, calling fn with every entry. Other files in the archive are ignored. The number of
+// parsed history files is returned.
+func ParseStreamingHistoryZip(r io.ReaderAt, size int64, fn func(HistoryEntry) error) (int, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidStreamingHistory, err)
+	}
+
+	parsed := 0
+	for _, file := range archive.File {
+		if file.FileInfo().IsDir() || !IsStreamingHistoryFile(file.Name) {
+			continue
+		}
+
+		contents, err := file.Open()
+		if err != nil {
+			return parsed, fmt.Errorf("%w: %v", ErrInvalidStreamingHistory, err)
+		}
+
+		err = ParseStreamingHistory(contents, fn)
+		contents.Close()
+
+		if err != nil {
+			return parsed, fmt.Errorf("%v: %w", file.Name, err)
+		}
+
+		parsed++
+	}
+
+	return parsed, nil
+}
@@ -45,6 +45,7 @@ func (track track) toDB() music.Track {
 		Upc:               track.ExternalIds.Upc,
 		SpotifyURI:        track.SpotifyURI,
 		SpotifyPopularity: track.Popularity,
+		ExternalIds:       music.ExternalIds{music.ProviderSpotify: track.Id},
 	}
 }
 
@@ -71,11 +72,13 @@ func (artist artist) toDB() music.Artist {
 		SpotifyId:            artist.Id,
 		SpotifyURI:           artist.SpotifyURI,
 		SpotifyFollowerCount: artist.Followers.Total,
+		ExternalIds:          music.ExternalIds{music.ProviderSpotify: artist.Id},
 	}
 }
 
 type album struct {
 	Type        string `json:"album_type"`
+	Group       string `json:"album_group"`
 	CountTracks int    `json:"total_tracks"`
 	Id          string
 	Name        string
@@ -108,17 +111,15 @@ func (album album) toDB() music.Album {
 
 	releaseDate, _ := time.Parse(time.DateOnly, album.ReleaseDate)
 
-	// parse album type from string to db.AlbumType
-	var albumType music.AlbumType
-	switch album.Type {
-	case "album":
+	// parse album type from string to db.AlbumType, treating unknown types as regular albums
+	albumType, err := music.ParseAlbumType(album.Type)
+	if err != nil || albumType == music.AlbumAppearsOn {
 		albumType = music.AlbumRegular
-	case "compilation":
-		albumType = music.AlbumCompilation
-	case "single":
-		albumType = music.AlbumSingle
 	}
 
+	// album_group is only present in artists' discographies
+	albumGroup, _ := music.ParseAlbumType(album.Group)
+
 	return music.Album{
 		Title:       album.Name,
 		CountTracks: album.CountTracks,
@@ -132,6 +133,8 @@ func (album album) toDB() music.Album {
 		Ean:         album.ExternalIds.Ean,
 		Upc:         album.ExternalIds.Upc,
 		Type:        albumType,
+		ExternalIds: music.ExternalIds{music.ProviderSpotify: album.Id},
+		Group:       albumGroup,
 	}
 }
 
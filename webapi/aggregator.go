@@ -2,6 +2,9 @@ package webapi
 
 import (
 	"bool3max/musicdash/db"
+	"bool3max/musicdash/lastfm"
+	"bool3max/musicdash/listenbrainz"
+	"bool3max/musicdash/musicbrainz"
 	"bool3max/musicdash/spotify"
 	"context"
 	"log"
@@ -12,25 +15,71 @@ import (
 
 const AGGREGATOR_SLEEP_TIME = 30 * 50 * time.Second
 
+// maximum number of tracks, and of albums, that MusicBrainz ids are attached to on every run, keeping a run
+// short given MusicBrainz's rate limit
+const musicbrainzEnrichBatchSize = 25
+
 // An Aggregator is an object that's used to periodically aggregate registered users'
 // track plays from Spotify and preserve them to the database
 type Aggregator struct {
 	db *db.Db
+
+	// plays are scrobbled to users' linked Last.fm accounts through this client, if not nil
+	lastfm *lastfm.Client
+
+	// plays are submitted to users' linked ListenBrainz accounts through this client, if not nil
+	listenbrainz *listenbrainz.Client
+
+	// preserved tracks and albums are looked up by their ISRC and UPC through this client, if not nil,
+	// to attach MusicBrainz ids to them
+	musicbrainz *musicbrainz.Client
 }
 
-// Return a new Aggregator associated with a particular db.Db database.
-func NewAggregator(database *db.Db) *Aggregator {
+// Return a new Aggregator associated with a particular db.Db database. If lastfmClient or listenbrainzClient
+// isn't nil, newly saved plays are also submitted to the Last.fm or ListenBrainz accounts that users have linked.
+// If musicbrainzClient isn't nil, MusicBrainz ids are attached to the preserved catalog a batch per run.
+func NewAggregator(database *db.Db, lastfmClient *lastfm.Client, listenbrainzClient *listenbrainz.Client, musicbrainzClient *musicbrainz.Client) *Aggregator {
 	return &Aggregator{
-		db: database,
+		db:           database,
+		lastfm:       lastfmClient,
+		listenbrainz: listenbrainzClient,
+		musicbrainz:  musicbrainzClient,
 	}
 }
 
 // Run the aggregator, blocking the current goroutine and periodically fetching recent track plays for all
-// musicdash users that have a linked Spotify account.
+// musicdash users that have a linked Spotify account. The aggregator runs alongside the router in the
+// server process, so on start it also fails import jobs left unfinished by the process last exiting.
 func (ag *Aggregator) Run() {
+	if failed, err := ag.db.FailInterruptedImportJobs(context.Background()); err != nil {
+		log.Println("aggregator: error failing interrupted import jobs: ", err)
+	} else if failed > 0 {
+		log.Printf("aggregator: marked {%v} interrupted import jobs as failed\n", failed)
+	}
+
 	for {
 		log.Println("aggregator: performing run...")
 
+		if purged, err := ag.db.PurgeDeletedAccounts(context.Background()); err != nil {
+			log.Println("aggregator: error purging deleted accounts: ", err)
+		} else if purged > 0 {
+			log.Printf("aggregator: purged {%v} deleted accounts\n", purged)
+		}
+
+		if deleted, err := ag.db.DeleteExpiredSessions(context.Background()); err != nil {
+			log.Println("aggregator: error deleting expired sessions: ", err)
+		} else if deleted > 0 {
+			log.Printf("aggregator: deleted {%v} expired sessions\n", deleted)
+		}
+
+		if ag.musicbrainz != nil {
+			if enriched, err := ag.db.AttachMusicBrainzIds(context.Background(), ag.musicbrainz, musicbrainzEnrichBatchSize); err != nil {
+				log.Println("aggregator: error attaching musicbrainz ids: ", err)
+			} else if enriched > 0 {
+				log.Printf("aggregator: attached musicbrainz ids to {%v} tracks and albums\n", enriched)
+			}
+		}
+
 		users, err := ag.db.GetUsersWithSpotifyLinked()
 		if err != nil {
 			log.Println("aggregator: fatal: error getting list of users: ", err)
@@ -40,7 +89,7 @@ func (ag *Aggregator) Run() {
 		for _, user := range users {
 			log.Printf("aggregator: processing user {%v}={%v}\n", user.Username, user.Id.String())
 			// obtain spotify client
-			if err := user.AttachSpotifyAuth(context.Background()); err != nil {
+			if err := user.AttachSpotifyAuth(context.Background(), ag.db); err != nil {
 				log.Printf("error obtaining spotify client for user: {%v}: %v\n", user.Id.String(), err)
 				continue
 			}
@@ -64,8 +113,9 @@ func (ag *Aggregator) Run() {
 
 			log.Printf("last refresh: %+v\n", refreshedAt)
 
-			// get most recent saved play from database
-			playsDb, err := user.GetRecentPlaysFromDB(1, user.Spotify)
+			// get most recent play aggregated from Spotify from database. plays from other sources, such as
+			// ones submitted through the ingest API, don't tell which Spotify plays were already saved
+			playsDb, err := user.GetPlays(context.Background(), ag.db, db.PlayFilter{Source: db.PlaySourceSpotify}, db.PlayCursor{}, 1, user.Spotify)
 			if err != nil {
 				log.Printf("aggregator: error getting recently played tracks from db for user: {%v}: %v\n", user.Id.String(), err)
 				continue
@@ -112,11 +162,31 @@ func (ag *Aggregator) Run() {
 			// save all recent plays from the response that are newer than the most
 			// recent play recorded in the database
 			log.Printf("saving total of {%v} new plays...\n", len(playsNew[:upperBoundIndex]))
-			if err := user.SavePlays(playsNew[:upperBoundIndex]); err != nil {
+			savedCount, err := user.SavePlays(ag.db, playsNew[:upperBoundIndex])
+			if err != nil {
 				log.Printf("aggregator: error saving new plays for user {%v}: %v\n", user.Id.String(), err)
 				continue
 			}
 
+			log.Printf("aggregator: saved {%v} new plays\n", savedCount)
+
+			// plays that failed to be scrobbled on earlier runs are retried here as well
+			if ag.lastfm != nil {
+				if scrobbled, err := scrobblePlays(context.Background(), ag.db, ag.lastfm, &user, user.Spotify); err != nil {
+					log.Printf("aggregator: error scrobbling plays of user {%v}: %v\n", user.Id.String(), err)
+				} else if scrobbled > 0 {
+					log.Printf("aggregator: scrobbled {%v} plays\n", scrobbled)
+				}
+			}
+
+			if ag.listenbrainz != nil {
+				if submitted, err := submitListens(context.Background(), ag.db, ag.listenbrainz, &user, user.Spotify); err != nil {
+					log.Printf("aggregator: error submitting listens of user {%v}: %v\n", user.Id.String(), err)
+				} else if submitted > 0 {
+					log.Printf("aggregator: submitted {%v} listens\n", submitted)
+				}
+			}
+
 			// update user's refreshedat..
 			_, err = ag.db.Pool().Exec(
 				context.Background(),
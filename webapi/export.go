@@ -0,0 +1,555 @@
+package webapi
+
+import (
+	"archive/zip"
+	"bool3max/musicdash/db"
+	"bool3max/musicdash/listenbrainz"
+	"bool3max/musicdash/music"
+	"bool3max/musicdash/spotify"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// number of plays loaded from the database at once while exporting
+const exportPageSize = 500
+
+// The types below define the stable JSON format of exported data, independent of the internal types.
+
+type exportArtist struct {
+	SpotifyId string `json:"spotify_id"`
+	Name      string `json:"name"`
+}
+
+type exportAlbum struct {
+	SpotifyId   string         `json:"spotify_id"`
+	Title       string         `json:"title"`
+	Type        string         `json:"type"`
+	ReleaseDate string         `json:"release_date,omitempty"`
+	Upc         string         `json:"upc,omitempty"`
+	Artists     []exportArtist `json:"artists"`
+}
+
+type exportTrack struct {
+	SpotifyId  string         `json:"spotify_id"`
+	Title      string         `json:"title"`
+	DurationMs int64          `json:"duration_ms"`
+	Explicit   bool           `json:"explicit"`
+	Isrc       string         `json:"isrc,omitempty"`
+	Album      exportAlbum    `json:"album"`
+	Artists    []exportArtist `json:"artists"`
+}
+
+type exportPlay struct {
+	At    time.Time   `json:"at"`
+	Track exportTrack `json:"track"`
+}
+
+func newExportArtists(artists []music.Artist) []exportArtist {
+	exported := make([]exportArtist, len(artists))
+	for idx, artist := range artists {
+		exported[idx] = exportArtist{SpotifyId: artist.SpotifyId, Name: artist.Name}
+	}
+
+	return exported
+}
+
+func newExportPlay(play spotify.Play) exportPlay {
+	track := play.Track
+
+	album := exportAlbum{
+		SpotifyId: track.Album.SpotifyId,
+		Title:     track.Album.Title,
+		Type:      string(track.Album.Type),
+		Upc:       track.Album.Upc,
+		Artists:   newExportArtists(track.Album.Artists),
+	}
+
+	if !track.Album.ReleaseDate.IsZero() {
+		album.ReleaseDate = track.Album.ReleaseDate.Format(time.DateOnly)
+	}
+
+	return exportPlay{
+		At: play.At,
+		Track: exportTrack{
+			SpotifyId:  track.SpotifyId,
+			Title:      track.Title,
+			DurationMs: track.Duration.Milliseconds(),
+			Explicit:   track.IsExplicit,
+			Isrc:       track.Isrc,
+			Album:      album,
+			Artists:    newExportArtists(track.Artists),
+		},
+	}
+}
+
+var exportPlaysCSVHeader = []string{"played_at", "track_spotify_id", "track_title", "artists", "album_spotify_id", "album_title", "duration_ms", "explicit", "isrc"}
+
+func exportPlayCSVRecord(play exportPlay) []string {
+	artistNames := make([]string, len(play.Track.Artists))
+	for idx, artist := range play.Track.Artists {
+		artistNames[idx] = artist.Name
+	}
+
+	return []string{
+		play.At.UTC().Format(time.RFC3339),
+		play.Track.SpotifyId,
+		play.Track.Title,
+		strings.Join(artistNames, "; "),
+		play.Track.Album.SpotifyId,
+		play.Track.Album.Title,
+		strconv.FormatInt(play.Track.DurationMs, 10),
+		strconv.FormatBool(play.Track.Explicit),
+		play.Track.Isrc,
+	}
+}
+
+// Write v as indented JSON into a new file of the archive.
+func writeZipJSON(archive *zip.Writer, name string, v any) error {
+	file, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// Write the user's complete play history into the archive, both as plays.json and plays.csv. Plays
+// are processed a page at a time: the JSON array is streamed straight into the archive, whereas the
+// CSV, as only one archive file can be written at once, is buffered in a temporary file.
+func writeZipPlays(c *gin.Context, database *db.Db, archive *zip.Writer, user *db.User, spotifyProvider music.ResourceProvider) error {
+	csvFile, err := os.CreateTemp("", "musicdash-export-*.csv")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(csvFile.Name())
+	defer csvFile.Close()
+
+	csvWriter := csv.NewWriter(csvFile)
+	if err := csvWriter.Write(exportPlaysCSVHeader); err != nil {
+		return err
+	}
+
+	jsonFile, err := archive.Create("plays.json")
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(jsonFile, "[\n"); err != nil {
+		return err
+	}
+
+	first := true
+	err = user.ForEachPlayPage(c, database, db.PlayFilter{}, exportPageSize, spotifyProvider, func(plays []spotify.Play) error {
+		for _, play := range plays {
+			exported := newExportPlay(play)
+
+			encoded, err := json.Marshal(exported)
+			if err != nil {
+				return err
+			}
+
+			if !first {
+				if _, err := io.WriteString(jsonFile, ",\n"); err != nil {
+					return err
+				}
+			}
+
+			first = false
+
+			if _, err := jsonFile.Write(encoded); err != nil {
+				return err
+			}
+
+			if err := csvWriter.Write(exportPlayCSVRecord(exported)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(jsonFile, "\n]\n"); err != nil {
+		return err
+	}
+
+	csvWriter.Flush()
+	if err := csvWriter.Error(); err != nil {
+		return err
+	}
+
+	if _, err := csvFile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	csvArchiveFile, err := archive.Create("plays.csv")
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(csvArchiveFile, csvFile)
+	return err
+}
+
+// Respond with a zip archive of all of the current user's data: account info, the linked Spotify
+// profile, the profile image and the complete play history. The archive is streamed as it's generated.
+func HandlerExport(database *db.Db) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("current_user").(*db.User)
+
+		spotifyProvider := optionalSpotifyProvider(c, database, user)
+
+		spotifyProfile, err := user.GetLinkedSpotifyProfile(c, database)
+		if err != nil && err != db.ErrSpotifyProfileNotLinked {
+			log.Printf("HandlerExport: error getting spotify profile of {%v}: %v\n", user.Id.String(), err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
+			return
+		}
+
+		spotifyLinked := err == nil
+
+		profileImage, err := database.GetUserProfileImage(c, user.Id)
+		if err != nil && err != db.ErrNoProfileImageSet {
+			log.Printf("HandlerExport: error getting profile image of {%v}: %v\n", user.Id.String(), err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
+			return
+		}
+
+		profileImageSet := err == nil
+
+		filename := "musicdash-export-" + user.Username + "-" + time.Now().UTC().Format("20060102") + ".zip"
+		c.Header("Content-Type", "application/zip")
+		c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+		c.Status(http.StatusOK)
+
+		// from this point on the response has started, so errors can only be logged and the
+		// archive left incomplete
+		archive := zip.NewWriter(c.Writer)
+
+		err = writeZipJSON(archive, "account.json", gin.H{
+			"id":             user.Id,
+			"username":       user.Username,
+			"email":          user.Email,
+			"email_verified": user.EmailVerified,
+			"registered_at":  user.RegisteredAt,
+		})
+
+		if err == nil && spotifyLinked {
+			err = writeZipJSON(archive, "spotify_profile.json", gin.H{
+				"spotify_id":   spotifyProfile.SpotifyId,
+				"display_name": spotifyProfile.DisplayName,
+				"email":        spotifyProfile.Email,
+				"followers":    spotifyProfile.FollowerCount,
+				"uri":          spotifyProfile.ProfileUri,
+				"url":          spotifyProfile.ProfileUrl,
+				"country":      spotifyProfile.Country,
+			})
+		}
+
+		if err == nil && profileImageSet {
+			var file io.Writer
+			if file, err = archive.Create("profile_image.webp"); err == nil {
+				_, err = file.Write(profileImage.Data)
+			}
+		}
+
+		if err == nil {
+			err = writeZipPlays(c, database, archive, user, spotifyProvider)
+		}
+
+		if err != nil {
+			log.Printf("HandlerExport: error exporting data of {%v}: %v\n", user.Id.String(), err)
+			return
+		}
+
+		if err := archive.Close(); err != nil {
+			log.Printf("HandlerExport: error finishing export of {%v}: %v\n", user.Id.String(), err)
+		}
+	}
+}
+
+// Formats that the play history can be exported in, for moving it to other tools.
+const (
+	// CSV of scrobbles, in the format of the commonly used Last.fm export tools
+	HistoryFormatLastfm = "lastfm"
+	// JSON array of listens, as returned by the ListenBrainz API and accepted by its submit-listens API
+	HistoryFormatListenBrainz = "listenbrainz"
+	// JSON array of streams, in the format of Spotify's Extended Streaming History
+	HistoryFormatSpotify = "spotify"
+)
+
+// Writes exported plays into an underlying writer, one at a time. Close must be called after the last play
+// to finish the output, but doesn't close the underlying writer.
+type historyWriter interface {
+	WritePlay(play spotify.Play) error
+	Close() error
+}
+
+type historyExportFormat struct {
+	contentType string
+	extension   string
+	newWriter   func(w io.Writer) (historyWriter, error)
+
+	// whether plays are identified by their artist and title alone, so that plays whose track's metadata
+	// couldn't be obtained have to be left out
+	needsMetadata bool
+}
+
+var historyExportFormats = map[string]historyExportFormat{
+	HistoryFormatLastfm: {
+		contentType:   "text/csv; charset=utf-8",
+		extension:     "csv",
+		newWriter:     newLastfmHistoryWriter,
+		needsMetadata: true,
+	},
+	HistoryFormatListenBrainz: {
+		contentType: "application/json; charset=utf-8",
+		extension:   "json",
+		newWriter: func(w io.Writer) (historyWriter, error) {
+			return newJSONHistoryWriter(w, newExportListen)
+		},
+		needsMetadata: true,
+	},
+	HistoryFormatSpotify: {
+		contentType: "application/json; charset=utf-8",
+		extension:   "json",
+		newWriter: func(w io.Writer) (historyWriter, error) {
+			return newJSONHistoryWriter(w, newExportStream)
+		},
+	},
+}
+
+var lastfmHistoryCSVHeader = []string{"uts", "utc_time", "artist", "artist_mbid", "album", "album_mbid", "track", "track_mbid"}
+
+type lastfmHistoryWriter struct {
+	csv *csv.Writer
+}
+
+func newLastfmHistoryWriter(w io.Writer) (historyWriter, error) {
+	writer := &lastfmHistoryWriter{csv: csv.NewWriter(w)}
+	if err := writer.csv.Write(lastfmHistoryCSVHeader); err != nil {
+		return nil, err
+	}
+
+	return writer, nil
+}
+
+// Scrobbles name only the track's first artist, as the ones scrobbled by the aggregator do.
+func (writer *lastfmHistoryWriter) WritePlay(play spotify.Play) error {
+	track := play.Track
+
+	var artist music.Artist
+	if len(track.Artists) > 0 {
+		artist = track.Artists[0]
+	}
+
+	return writer.csv.Write([]string{
+		strconv.FormatInt(play.At.Unix(), 10),
+		play.At.UTC().Format("02 Jan 2006, 15:04"),
+		artist.Name,
+		artist.ExternalIds.Get(music.ProviderMusicBrainz),
+		track.Album.Title,
+		track.Album.ExternalIds.Get(music.ProviderMusicBrainz),
+		track.Title,
+		track.ExternalIds.Get(music.ProviderMusicBrainz),
+	})
+}
+
+func (writer *lastfmHistoryWriter) Close() error {
+	writer.csv.Flush()
+	return writer.csv.Error()
+}
+
+// Writes plays as the elements of a JSON array, one per line, converted by convert.
+type jsonHistoryWriter struct {
+	w       io.Writer
+	convert func(play spotify.Play) any
+	first   bool
+}
+
+func newJSONHistoryWriter[T any](w io.Writer, convert func(play spotify.Play) T) (historyWriter, error) {
+	if _, err := io.WriteString(w, "["); err != nil {
+		return nil, err
+	}
+
+	return &jsonHistoryWriter{
+		w:       w,
+		convert: func(play spotify.Play) any { return convert(play) },
+		first:   true,
+	}, nil
+}
+
+func (writer *jsonHistoryWriter) WritePlay(play spotify.Play) error {
+	encoded, err := json.Marshal(writer.convert(play))
+	if err != nil {
+		return err
+	}
+
+	separator := ",\n"
+	if writer.first {
+		separator = "\n"
+	}
+
+	writer.first = false
+
+	if _, err := io.WriteString(writer.w, separator); err != nil {
+		return err
+	}
+
+	_, err = writer.w.Write(encoded)
+	return err
+}
+
+func (writer *jsonHistoryWriter) Close() error {
+	_, err := io.WriteString(writer.w, "\n]\n")
+	return err
+}
+
+// Convert a play to a ListenBrainz listen, as submitted to linked accounts, along with the MusicBrainz
+// ids of the track, if known.
+func newExportListen(play spotify.Play) listenbrainz.ListenPayload {
+	track := &play.Track
+	listen := newListenPayload(play.At, track)
+
+	info := listen.TrackMetadata.AdditionalInfo
+	info.RecordingMBID = track.ExternalIds.Get(music.ProviderMusicBrainz)
+	info.ReleaseMBID = track.Album.ExternalIds.Get(music.ProviderMusicBrainz)
+
+	for _, artist := range track.Artists {
+		if mbid := artist.ExternalIds.Get(music.ProviderMusicBrainz); mbid != "" {
+			info.ArtistMBIDs = append(info.ArtistMBIDs, mbid)
+		}
+	}
+
+	return listen
+}
+
+// A stream of Spotify's Extended Streaming History. Fields that musicdash doesn't know about, such as the
+// platform or whether the stream was shuffled, are left null.
+type exportStream struct {
+	Ts          string  `json:"ts"`
+	MsPlayed    int64   `json:"ms_played"`
+	TrackName   string  `json:"master_metadata_track_name"`
+	ArtistName  string  `json:"master_metadata_album_artist_name"`
+	AlbumName   string  `json:"master_metadata_album_album_name"`
+	TrackUri    *string `json:"spotify_track_uri"`
+	ReasonStart *string `json:"reason_start"`
+	ReasonEnd   *string `json:"reason_end"`
+	Shuffle     *bool   `json:"shuffle"`
+	Skipped     bool    `json:"skipped"`
+	Offline     *bool   `json:"offline"`
+	Incognito   *bool   `json:"incognito_mode"`
+}
+
+// Convert a play to a stream of the Extended Streaming History. Plays are recorded once a track has
+// been listened to, and not how much of it, so the stream is taken to have lasted for the whole track.
+func newExportStream(play spotify.Play) exportStream {
+	track := play.Track
+
+	stream := exportStream{
+		Ts:        play.At.UTC().Format(time.RFC3339),
+		MsPlayed:  track.Duration.Milliseconds(),
+		TrackName: track.Title,
+		AlbumName: track.Album.Title,
+	}
+
+	// as in Spotify's own history, streams of local files have no track uri
+	if music.IsSpotifyId(track.SpotifyId) {
+		uri := "spotify:track:" + track.SpotifyId
+		stream.TrackUri = &uri
+	}
+
+	if len(track.Artists) > 0 {
+		stream.ArtistName = track.Artists[0].Name
+	}
+
+	return stream
+}
+
+// Respond with the current user's play history in the format given by the "format" URL parameter, one of
+// the HistoryFormat* constants, most recent play first. The history can be limited to plays made at or
+// after the "from" and before the "to" RFC 3339 URL query parameters. The response is streamed as it's
+// generated, without the history being loaded into memory at once. Formats that identify plays by their
+// metadata leave out plays whose track's metadata couldn't be obtained, i.e. ones of tracks that aren't
+// preserved while the user has no Spotify account linked, and report their number in the
+// X-Skipped-Plays trailer.
+func HandlerExportHistory(database *db.Db) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("current_user").(*db.User)
+
+		format, ok := historyExportFormats[c.Param("format")]
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"ERROR": "UNKNOWN_FORMAT"})
+			return
+		}
+
+		var filter db.PlayFilter
+		for param, dest := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+			if value := c.Query(param); value != "" {
+				parsed, err := time.Parse(time.RFC3339, value)
+				if err != nil {
+					c.AbortWithStatusJSON(http.StatusBadRequest, responseBadRequest)
+					return
+				}
+
+				*dest = parsed
+			}
+		}
+
+		spotifyProvider := optionalSpotifyProvider(c, database, user)
+
+		filename := "musicdash-" + c.Param("format") + "-" + user.Username + "-" + time.Now().UTC().Format("20060102") + "." + format.extension
+		c.Header("Content-Type", format.contentType)
+		c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+		c.Header("Trailer", "X-Skipped-Plays")
+		c.Status(http.StatusOK)
+
+		// the output is written as plays are loaded, so a failure midway leaves it truncated, with
+		// the error only logged
+		skipped := 0
+		writer, err := format.newWriter(c.Writer)
+		if err == nil {
+			err = user.ForEachPlayPage(c, database, filter, exportPageSize, spotifyProvider, func(plays []spotify.Play) error {
+				for _, play := range plays {
+					if format.needsMetadata && (play.Track.Title == "" || len(play.Track.Artists) == 0 || play.Track.Artists[0].Name == "") {
+						skipped++
+						continue
+					}
+
+					if err := writer.WritePlay(play); err != nil {
+						return err
+					}
+				}
+
+				c.Writer.Flush()
+				return nil
+			})
+		}
+
+		if err == nil {
+			err = writer.Close()
+		}
+
+		if err != nil {
+			log.Printf("HandlerExportHistory: error exporting history of {%v}: %v\n", user.Id.String(), err)
+			return
+		}
+
+		c.Writer.Header().Set("X-Skipped-Plays", strconv.Itoa(skipped))
+	}
+}
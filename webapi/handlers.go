@@ -2,8 +2,10 @@ package webapi
 
 import (
 	"bool3max/musicdash/db"
+	"bool3max/musicdash/mailer"
 	"bool3max/musicdash/music"
 	"bool3max/musicdash/spotify"
+	"context"
 	"io"
 	"log"
 	"math/rand"
@@ -33,11 +35,62 @@ type LoginCredRequestData struct {
 	Password string `binding:"required"`
 }
 
+type ChangePasswordRequestData struct {
+	CurrentPassword string `binding:"required"`
+	NewPassword     string `binding:"required"`
+}
+
+type ForgotPasswordRequestData struct {
+	Email string `binding:"required"`
+}
+
+// The account is confirmed to be the user's with either its password or, for accounts without one,
+// by re-authorizing with the linked Spotify account using the "confirm" flow.
+type DeleteAccountRequestData struct {
+	Password     string
+	SpotifyCode  string
+	SpotifyState string
+
+	// skip the grace period, purging the account right away
+	Immediately bool
+}
+
+type VerifyEmailRequestData struct {
+	Token string `binding:"required"`
+}
+
+type ChangeEmailRequestData struct {
+	CurrentPassword string `binding:"required"`
+	Email           string `binding:"required"`
+}
+
+type LastfmImportRequestData struct {
+	Username string `binding:"required"`
+}
+
+type ListenBrainzImportRequestData struct {
+	Username string `binding:"required"`
+}
+
+type ListenBrainzLinkRequestData struct {
+	Token string `binding:"required"`
+}
+
+type CorrectMatchReviewRequestData struct {
+	SpotifyId string `binding:"required"`
+}
+
+type ResetPasswordRequestData struct {
+	Token       string `binding:"required"`
+	NewPassword string `binding:"required"`
+}
+
 var (
 	responseInternalServerError = gin.H{"error": "ERROR_INTERNAL_SERVER"}
 	responseBadRequest          = gin.H{"error": "ERROR_BAD_RQUEST"}
 	responseNotLoggedIn         = gin.H{"error": "ERROR_NOT_LOGGED_IN"}
 	responseInvalidLogin        = gin.H{"error": "ERROR_INVALID_LOGIN"}
+	responseInvalidPassword     = gin.H{"message": "Password length must be at least 8 characters and no more than 56 characters."}
 )
 
 // Returns a Gin handler middleware that ensures that the user is logged-in into a valid
@@ -54,7 +107,7 @@ func AuthNeeded(database *db.Db) gin.HandlerFunc {
 			return
 		}
 
-		user, err := database.GetUserFromAuthToken(c, db.UserAuthToken(authToken))
+		user, session, err := database.GetUserFromAuthToken(c, db.UserAuthToken(authToken), sessionDevice(c))
 		if err != nil {
 			if err == db.ErrInvalidAuthToken {
 				c.AbortWithStatusJSON(http.StatusUnauthorized, responseInvalidLogin)
@@ -67,10 +120,19 @@ func AuthNeeded(database *db.Db) gin.HandlerFunc {
 
 		// save the auth token used and the User into the gin context for future handlers to make use of
 		c.Set("current_auth_token", authToken)
+		c.Set("current_session", &session)
 		c.Set("current_user", &user) // the user instance is saved as a pointer
 	}
 }
 
+// Return the device the current request is made from.
+func sessionDevice(c *gin.Context) db.SessionDevice {
+	return db.SessionDevice{
+		UserAgent: c.Request.UserAgent(),
+		Ip:        c.ClientIP(),
+	}
+}
+
 // Returns a Gin handler middleware that ensures that the user performing the current request
 // has a connected Spotify account that is currently properly authenticated. As such, this middleware
 // must be preceeded by the AuthNeeded middleware. If the user has a connected Spotify account
@@ -81,7 +143,7 @@ func SpotifyAuthNeeded(database *db.Db) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		user := c.MustGet("current_user").(*db.User)
 
-		err := user.AttachSpotifyAuth(c)
+		err := user.AttachSpotifyAuth(c, database)
 		if err != nil {
 			// User has no Spotify authentication
 			if err == db.ErrSpotifyProfileNotLinked {
@@ -96,8 +158,9 @@ func SpotifyAuthNeeded(database *db.Db) gin.HandlerFunc {
 	}
 }
 
-// Gin handler for signing up using an email and password.
-func HandlerSignupCred(database *db.Db) gin.HandlerFunc {
+// Gin handler for signing up using an email and password. The account is created with an unverified
+// e-mail address, and a verification link is e-mailed to it.
+func HandlerSignupCred(database *db.Db, sender mailer.Mailer) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var data SignupCredRequestData
 		if err := c.ShouldBindJSON(&data); err != nil {
@@ -131,12 +194,8 @@ func HandlerSignupCred(database *db.Db) gin.HandlerFunc {
 			return
 		}
 
-		// bcrypt max password byte length is 72 bytes and we salt it with a uuidv4 which is 16 bytes
-		if len(data.Password) < 8 || len(data.Password) > (72-16) {
-			c.AbortWithStatusJSON(
-				http.StatusBadRequest,
-				gin.H{"message": "Password length must be at least 8 characters and no more than 56 characters."},
-			)
+		if !PasswordIsValid(data.Password) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, responseInvalidPassword)
 			return
 		}
 
@@ -149,12 +208,25 @@ func HandlerSignupCred(database *db.Db) gin.HandlerFunc {
 			return
 		}
 
-		if _, err = database.UserInsert(data.Username, data.Password, data.Email); err != nil {
+		newUserId, err := database.UserInsert(data.Username, data.Password, data.Email)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
+			return
+		}
+
+		newUser, err := database.GetUserFromId(c, newUserId)
+		if err != nil {
 			c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
 			return
 		}
 
-		c.JSON(http.StatusCreated, gin.H{"message": "Account created successfully."})
+		// the account is usable regardless, so failing to send the verification only gets logged,
+		// and the user can request it to be resent
+		if err := sendEmailVerification(c, database, sender, &newUser, newUser.Email); err != nil {
+			log.Printf("HandlerSignupCred: error issuing e-mail verification for {%v}: %v\n", newUserId.String(), err)
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"message": "Account created successfully. A verification link has been sent to your e-mail address."})
 	}
 }
 
@@ -187,14 +259,14 @@ func HandlerLoginCred(database *db.Db) gin.HandlerFunc {
 
 		// login credentials valid, obtain auth token of requested user
 		var authToken db.UserAuthToken
-		authToken, err = database.UserNewAuthToken(c, userId)
+		authToken, err = database.UserNewAuthToken(c, userId, sessionDevice(c))
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, responseInternalServerError)
 			return
 		}
 
 		c.SetSameSite(http.SameSiteLaxMode)
-		c.SetCookie("auth_token", string(authToken), int((time.Hour * 24 * 30).Seconds()), "/", "", true, true)
+		c.SetCookie("auth_token", string(authToken), int(db.SessionLifetime.Seconds()), "/", "", true, true)
 		c.JSON(http.StatusOK, gin.H{"token": authToken})
 	}
 }
@@ -206,7 +278,7 @@ func HandlerLogout(database *db.Db, everywhere bool) gin.HandlerFunc {
 		if everywhere {
 			// log out everywhere
 			user := c.MustGet("current_user").(*db.User)
-			err := user.RevokeAllTokens(c)
+			err := user.RevokeAllTokens(c, database)
 
 			if err != nil {
 				c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
@@ -232,16 +304,452 @@ func HandlerLogout(database *db.Db, everywhere bool) gin.HandlerFunc {
 		// clear login auth info from context
 		c.Set("current_user", nil)
 		c.Set("current_auth_token", nil)
+		c.Set("current_session", nil)
 
 		c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully."})
 	}
 }
 
+// Change the current user's password, logging out all of the user's other sessions.
+func HandlerChangePassword(database *db.Db) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("current_user").(*db.User)
+		currentSession := c.MustGet("current_session").(*db.Session)
+
+		var data ChangePasswordRequestData
+		if err := c.ShouldBindJSON(&data); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, responseBadRequest)
+			return
+		}
+
+		if !PasswordIsValid(data.NewPassword) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, responseInvalidPassword)
+			return
+		}
+
+		if err := user.ChangePassword(c, database, data.CurrentPassword, data.NewPassword, currentSession.Id); err != nil {
+			if err == db.ErrPasswordIncorrect {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Incorrect current password."})
+				return
+			}
+
+			if err == db.ErrNoPasswordSet {
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"ERROR": "NO_PASSWORD_SET"})
+				return
+			}
+
+			log.Printf("HandlerChangePassword: error changing password of {%v}: %v\n", user.Id.String(), err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Password changed successfully."})
+	}
+}
+
+// Start the password reset flow, e-mailing a password reset link to the given address. The handler
+// responds the same whether or not an account is registered with the address, so that it can't be
+// used to find out which addresses are registered.
+func HandlerForgotPassword(database *db.Db, sender mailer.Mailer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var data ForgotPasswordRequestData
+		if err := c.ShouldBindJSON(&data); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, responseBadRequest)
+			return
+		}
+
+		// resets are only sent to registered, verified addresses
+		user, token, err := database.NewPasswordReset(c, data.Email)
+		if err != nil && err != db.ErrEmailNotRegistered && err != db.ErrEmailNotVerified {
+			log.Printf("HandlerForgotPassword: error issuing password reset token: %v\n", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
+			return
+		}
+
+		if err == nil {
+			sendInBackground(sender, mailer.Message{
+				To:      user.Email,
+				Subject: "Reset your musicdash password",
+				Body: "Hi " + user.Username + ",\n\n" +
+					"someone requested a password reset for your musicdash account. If it was you, follow the link below to set a new password. " +
+					"The link is valid for " + db.PasswordResetLifetime.String() + ".\n\n" +
+					"http://localhost:7070/#reset_password?token=" + url.QueryEscape(token) + "\n\n" +
+					"If you didn't request a password reset, you can ignore this e-mail.\n",
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "If an account with that e-mail address exists, a password reset link has been sent to it."})
+	}
+}
+
+// Send an e-mail without waiting for it to be delivered, logging any error. This way the response time
+// of handlers that send e-mails doesn't reveal anything about whether one was sent.
+func sendInBackground(sender mailer.Mailer, message mailer.Message) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if err := sender.Send(ctx, message); err != nil {
+			log.Printf("error sending e-mail {%s}: %v\n", message.Subject, err)
+		}
+	}()
+}
+
+// Issue a verification of the given e-mail address for the user and e-mail a verification link to it.
+func sendEmailVerification(c *gin.Context, database *db.Db, sender mailer.Mailer, user *db.User, email string) error {
+	token, err := user.NewEmailVerification(c, database, email)
+	if err != nil {
+		return err
+	}
+
+	purpose := "verify the e-mail address of your new musicdash account"
+	if !strings.EqualFold(email, user.Email) {
+		purpose = "change the e-mail address of your musicdash account to this one"
+	}
+
+	sendInBackground(sender, mailer.Message{
+		To:      email,
+		Subject: "Verify your musicdash e-mail address",
+		Body: "Hi " + user.Username + ",\n\n" +
+			"follow the link below to " + purpose + ". " +
+			"The link is valid for " + db.EmailVerificationLifetime.String() + ".\n\n" +
+			"http://localhost:7070/#verify_email?token=" + url.QueryEscape(token) + "\n\n" +
+			"If you didn't request this, you can ignore this e-mail.\n",
+	})
+
+	return nil
+}
+
+// Confirm an e-mail address using the token from a verification link. Depending on what the link
+// was sent for, this either verifies the address the user signed up with or changes it to a new one.
+func HandlerVerifyEmail(database *db.Db) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var data VerifyEmailRequestData
+		if err := c.ShouldBindJSON(&data); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, responseBadRequest)
+			return
+		}
+
+		user, err := database.VerifyEmail(c, data.Token)
+		if err != nil {
+			if err == db.ErrInvalidVerificationToken {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"ERROR": "INVALID_VERIFICATION_TOKEN"})
+				return
+			}
+
+			if err == db.ErrEmailTaken {
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"ERROR": "EMAIL_TAKEN"})
+				return
+			}
+
+			log.Printf("HandlerVerifyEmail: error verifying e-mail: %v\n", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "E-mail address verified successfully.", "email": user.Email})
+	}
+}
+
+// Resend the verification link of the current user's unverified e-mail address.
+func HandlerResendEmailVerification(database *db.Db, sender mailer.Mailer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("current_user").(*db.User)
+
+		if err := sendEmailVerification(c, database, sender, user, user.Email); err != nil {
+			if err == db.ErrEmailAlreadyVerified {
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"ERROR": "EMAIL_ALREADY_VERIFIED"})
+				return
+			}
+
+			log.Printf("HandlerResendEmailVerification: error issuing e-mail verification for {%v}: %v\n", user.Id.String(), err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "A verification link has been sent to your e-mail address."})
+	}
+}
+
+// Start changing the current user's e-mail address, after confirming the user's current password. The
+// address is only changed once the user confirms it through the verification link sent to the new address.
+func HandlerChangeEmail(database *db.Db, sender mailer.Mailer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("current_user").(*db.User)
+
+		var data ChangeEmailRequestData
+		if err := c.ShouldBindJSON(&data); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, responseBadRequest)
+			return
+		}
+
+		if _, err := mail.ParseAddress(data.Email); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Invalid e-mail address."})
+			return
+		}
+
+		if err := user.CheckPassword(c, database, data.CurrentPassword); err != nil {
+			if err == db.ErrPasswordIncorrect {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Incorrect current password."})
+				return
+			}
+
+			if err == db.ErrNoPasswordSet {
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"ERROR": "NO_PASSWORD_SET"})
+				return
+			}
+
+			log.Printf("HandlerChangeEmail: error checking password of {%v}: %v\n", user.Id.String(), err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
+			return
+		}
+
+		if err := sendEmailVerification(c, database, sender, user, data.Email); err != nil {
+			if err == db.ErrEmailAlreadyVerified {
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"ERROR": "EMAIL_ALREADY_VERIFIED"})
+				return
+			}
+
+			if err == db.ErrEmailTaken {
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"ERROR": "EMAIL_TAKEN"})
+				return
+			}
+
+			log.Printf("HandlerChangeEmail: error issuing e-mail verification for {%v}: %v\n", user.Id.String(), err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "A verification link has been sent to the new e-mail address."})
+	}
+}
+
+// Set a new password using a token from a password reset link, logging out all of the user's sessions.
+func HandlerResetPassword(database *db.Db) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var data ResetPasswordRequestData
+		if err := c.ShouldBindJSON(&data); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, responseBadRequest)
+			return
+		}
+
+		if !PasswordIsValid(data.NewPassword) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, responseInvalidPassword)
+			return
+		}
+
+		if err := database.ResetPassword(c, data.Token, data.NewPassword); err != nil {
+			if err == db.ErrInvalidResetToken {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"ERROR": "INVALID_RESET_TOKEN"})
+				return
+			}
+
+			log.Printf("HandlerResetPassword: error resetting password: %v\n", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Password reset successfully."})
+	}
+}
+
+// Delete the current user's account, after confirming that it's the user's. Unless deletion is requested
+// to happen immediately, the account is only purged once db.AccountDeletionGracePeriod elapses, and
+// can be restored through HandlerRestoreAccount until then. Either way, all sessions are logged out.
+func HandlerDeleteAccount(database *db.Db) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("current_user").(*db.User)
+
+		var data DeleteAccountRequestData
+		if err := c.ShouldBindJSON(&data); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, responseBadRequest)
+			return
+		}
+
+		switch {
+		case data.Password != "":
+			if err := user.CheckPassword(c, database, data.Password); err != nil {
+				if err == db.ErrPasswordIncorrect || err == db.ErrNoPasswordSet {
+					c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Incorrect password."})
+					return
+				}
+
+				log.Printf("HandlerDeleteAccount: error checking password of {%v}: %v\n", user.Id.String(), err)
+				c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
+				return
+			}
+		case data.SpotifyCode != "":
+			clientState, err := c.Cookie("spotify_connect_state")
+			if err != nil || data.SpotifyState != clientState {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "States don't match."})
+				return
+			}
+
+			linkedProfile, err := user.GetLinkedSpotifyProfile(c, database)
+			if err != nil {
+				if err == db.ErrSpotifyProfileNotLinked {
+					c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"ERROR": "ERROR_SPOTIFY_NOT_LINKED"})
+					return
+				}
+
+				c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
+				return
+			}
+
+			spotifyClient, err := spotify.NewAuthorizationCode(
+				db.MUSICDASH_SPOTIFY_CLIENT_ID,
+				db.MUSICDASH_SPOTIFY_SECRET,
+				data.SpotifyCode,
+				"http://localhost:7070/#spotify_confirm",
+			)
+
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "ERROR_SPOTIFY_AUTHORIZATION"})
+				return
+			}
+
+			spotifyProfile, err := spotifyClient.GetCurrentUserProfile()
+			if err != nil {
+				log.Println("error getting profile, ", err)
+				c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
+				return
+			}
+
+			// the user must have re-authorized with the very Spotify account linked to the musicdash account
+			if spotifyProfile.SpotifyId != linkedProfile.SpotifyId {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "ERROR_SPOTIFY_ACCOUNT_MISMATCH"})
+				return
+			}
+		default:
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"ERROR": "CONFIRMATION_REQUIRED"})
+			return
+		}
+
+		gracePeriod := db.AccountDeletionGracePeriod
+		if data.Immediately {
+			gracePeriod = 0
+		}
+
+		purgeAt, err := user.ScheduleDeletion(c, database, gracePeriod)
+		if err != nil {
+			log.Printf("HandlerDeleteAccount: error deleting account {%v}: %v\n", user.Id.String(), err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
+			return
+		}
+
+		// instruct client to clear cookie
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie("auth_token", "", -1, "/", "", true, true)
+
+		c.Set("current_user", nil)
+		c.Set("current_auth_token", nil)
+		c.Set("current_session", nil)
+
+		if data.Immediately {
+			c.JSON(http.StatusOK, gin.H{"message": "Account deleted."})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":  "Account scheduled for deletion. Until it's purged, it can be restored by logging back in and requesting its restoration.",
+			"purge_at": purgeAt,
+		})
+	}
+}
+
+// Restore the current user's account that's scheduled for deletion.
+func HandlerRestoreAccount(database *db.Db) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("current_user").(*db.User)
+
+		if err := user.CancelDeletion(c, database); err != nil {
+			if err == db.ErrAccountNotScheduledForDeletion {
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"ERROR": "ACCOUNT_NOT_SCHEDULED_FOR_DELETION"})
+				return
+			}
+
+			log.Printf("HandlerRestoreAccount: error restoring account {%v}: %v\n", user.Id.String(), err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Account restored successfully."})
+	}
+}
+
+// List the current user's active sessions, most recently used first.
+func HandlerSessions(database *db.Db) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("current_user").(*db.User)
+		currentSession := c.MustGet("current_session").(*db.Session)
+
+		sessions, err := user.GetSessions(c, database)
+		if err != nil {
+			log.Printf("HandlerSessions: error getting sessions for {%v}: %v\n", user.Id.String(), err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
+			return
+		}
+
+		response := make([]gin.H, len(sessions))
+		for idx, session := range sessions {
+			response[idx] = gin.H{
+				"id":           session.Id,
+				"granted_at":   session.GrantedAt,
+				"last_seen_at": session.LastSeenAt,
+				"expires_at":   session.Expiry(),
+				"user_agent":   session.Device.UserAgent,
+				"ip":           session.Device.Ip,
+				"current":      session.Id == currentSession.Id,
+			}
+		}
+
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// Log out a single session of the current user, identified by the "sessionId" URL parameter.
+// Revoking the current session is the same as logging out.
+func HandlerRevokeSession(database *db.Db) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("current_user").(*db.User)
+		currentSession := c.MustGet("current_session").(*db.Session)
+
+		sessionId, err := uuid.Parse(c.Param("sessionId"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, responseBadRequest)
+			return
+		}
+
+		if err := user.RevokeSession(c, database, sessionId); err != nil {
+			if err == db.ErrSessionNotFound {
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"ERROR": "SESSION_NOT_FOUND"})
+				return
+			}
+
+			log.Printf("HandlerRevokeSession: error revoking session {%v} of {%v}: %v\n", sessionId.String(), user.Id.String(), err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
+			return
+		}
+
+		if sessionId == currentSession.Id {
+			// instruct client to clear cookie
+			c.SetSameSite(http.SameSiteLaxMode)
+			c.SetCookie("auth_token", "", -1, "/", "", true, true)
+
+			c.Set("current_user", nil)
+			c.Set("current_auth_token", nil)
+			c.Set("current_session", nil)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Session revoked successfully."})
+	}
+}
+
 // This API endpoint returns a new Spotify auth redirect URL that the user's frontend is redirected to
 // in order to perform authorization with spotify.
 func HandlerSpotifyAuthUrl(database *db.Db) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// flow_type must be one of "connect" or "continue_with"
+		// flow_type must be one of "connect", "continue_with" or "confirm"
 
 		flowType := c.Query("flow_type")
 		if flowType == "" {
@@ -249,13 +757,7 @@ func HandlerSpotifyAuthUrl(database *db.Db) gin.HandlerFunc {
 			return
 		}
 
-		// generate random string for state parameter
-		var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
-
-		state := make([]rune, 32)
-		for i := range state {
-			state[i] = letters[rand.Intn(len(letters))]
-		}
+		state := randomState()
 
 		var spotifyRedirectUri = "http://localhost:7070/"
 		switch flowType {
@@ -263,6 +765,8 @@ func HandlerSpotifyAuthUrl(database *db.Db) gin.HandlerFunc {
 			spotifyRedirectUri += "#spotify_connect_account"
 		case "continue_with":
 			spotifyRedirectUri += "#spotify_continue_with"
+		case "confirm":
+			spotifyRedirectUri += "#spotify_confirm"
 		default:
 			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "ERROR_INVALID_FLOW_TYPE"})
 			return
@@ -274,14 +778,14 @@ func HandlerSpotifyAuthUrl(database *db.Db) gin.HandlerFunc {
 			"response_type": {"code"},
 			"redirect_uri":  {spotifyRedirectUri},
 			"scope":         {"user-read-playback-position user-top-read user-read-recently-played user-library-read user-read-playback-state user-modify-playback-state user-read-currently-playing user-read-email user-read-private"},
-			"state":         {string(state)},
+			"state":         {state},
 		}
 
 		final := endpoint + "?" + params.Encode()
 
 		// save the generated random state on the client
 		c.SetSameSite(http.SameSiteLaxMode)
-		c.SetCookie("spotify_connect_state", string(state), 300, "/", "", true, true)
+		c.SetCookie("spotify_connect_state", state, 300, "/", "", true, true)
 
 		c.JSON(http.StatusOK, gin.H{"redirect_url": final})
 	}
@@ -339,7 +843,7 @@ func HandlerSpotifyLinkAccount(database *db.Db) gin.HandlerFunc {
 			return
 		}
 
-		if err = user.LinkSpotifyProfile(c, spotifyProfile); err != nil {
+		if err = user.LinkSpotifyProfile(c, database, spotifyProfile); err != nil {
 			log.Println("error linking profile: ", err)
 			c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
 			return
@@ -347,7 +851,7 @@ func HandlerSpotifyLinkAccount(database *db.Db) gin.HandlerFunc {
 
 		// Preserve the spotify auth params into the database. If linking spotify acc fails
 		// we don't want to store auth credentials.
-		if err := user.SaveSpotifyAuthDB(c); err != nil {
+		if err := user.SaveSpotifyAuthDB(c, database); err != nil {
 			log.Println("error saving auth params: ", err)
 			c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
 			return
@@ -467,21 +971,21 @@ func HandlerSpotifyContinueWith(database *db.Db) gin.HandlerFunc {
 			newUser.Spotify = userSpotifyClient // save authenticated spotify client to user
 
 			// link new account to spotify account
-			if err = newUser.LinkSpotifyProfile(c, spotifyProfile); err != nil {
+			if err = newUser.LinkSpotifyProfile(c, database, spotifyProfile); err != nil {
 				log.Println(err)
 				c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
 				return
 			}
 
 			// save spotify auth parameters to database as the user is now logged in and has a connected spotify account
-			if err = newUser.SaveSpotifyAuthDB(c); err != nil {
+			if err = newUser.SaveSpotifyAuthDB(c, database); err != nil {
 				log.Println(err)
 				c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
 				return
 			}
 
 			// log user into newly created account
-			eventualAuthToken, err = database.UserNewAuthToken(c, newUserId)
+			eventualAuthToken, err = database.UserNewAuthToken(c, newUserId, sessionDevice(c))
 			if err != nil {
 				log.Println(err)
 				c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
@@ -489,7 +993,7 @@ func HandlerSpotifyContinueWith(database *db.Db) gin.HandlerFunc {
 			}
 		} else {
 			// existing account found, simply log into it
-			eventualAuthToken, err = database.UserNewAuthToken(c, existingUserId)
+			eventualAuthToken, err = database.UserNewAuthToken(c, existingUserId, sessionDevice(c))
 			if err != nil {
 				log.Println(err)
 				c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
@@ -499,7 +1003,7 @@ func HandlerSpotifyContinueWith(database *db.Db) gin.HandlerFunc {
 
 		// save token as cookie on client
 		c.SetSameSite(http.SameSiteLaxMode)
-		c.SetCookie("auth_token", string(eventualAuthToken), int((time.Hour * 24 * 30).Seconds()), "/", "", true, true)
+		c.SetCookie("auth_token", string(eventualAuthToken), int(db.SessionLifetime.Seconds()), "/", "", true, true)
 		c.JSON(http.StatusOK, gin.H{"token": eventualAuthToken})
 	}
 }
@@ -564,6 +1068,7 @@ func HandlerUploadProfileImage(database *db.Db) gin.HandlerFunc {
 
 		err = currentUser.SetProfileImage(
 			c,
+			database,
 			metadata.Size.Width,
 			metadata.Size.Height,
 			imageDataFinal,
@@ -581,7 +1086,7 @@ func HandlerUploadProfileImage(database *db.Db) gin.HandlerFunc {
 func HandlerUploadProfileImageFromSpotify(database *db.Db) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		currentUser := c.MustGet("current_user").(*db.User)
-		spotifyProfile, err := currentUser.GetLinkedSpotifyProfile(c)
+		spotifyProfile, err := currentUser.GetLinkedSpotifyProfile(c, database)
 		if err != nil {
 			c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
 			return
@@ -607,6 +1112,7 @@ func HandlerUploadProfileImageFromSpotify(database *db.Db) gin.HandlerFunc {
 
 		err = currentUser.SetProfileImage(
 			c,
+			database,
 			spotifyProfile.ProfileImages[0].Width,
 			spotifyProfile.ProfileImages[0].Height,
 			finalImageData,
@@ -723,17 +1229,11 @@ func HandlerUpdateUsername(database *db.Db) gin.HandlerFunc {
 }
 
 func HandlerRandomQueuer(database *db.Db) gin.HandlerFunc {
+	// besides separate recordings, other named versions of a track, such as "Radio Version", are
+	// protected as well
 	isRemix := func(title string) bool {
-		protectionKeywords := [...]string{"remix", "version"}
-		title = strings.ToLower(title)
-
-		for _, kw := range protectionKeywords {
-			if strings.Contains(title, kw) {
-				return true
-			}
-		}
-
-		return false
+		parsed := music.ParseTitle(title)
+		return parsed.IsAlternateRecording() || parsed.Has(music.VersionAlternate)
 	}
 
 	return func(c *gin.Context) {
@@ -827,3 +1327,171 @@ func HandlerRandomQueuer(database *db.Db) gin.HandlerFunc {
 		c.JSON(http.StatusCreated, queuedURIs)
 	}
 }
+
+// Return the user's Spotify client, which metadata of tracks that aren't preserved is obtained from, or
+// nil if the user has no Spotify account linked or it can't be used, in which case those tracks are
+// returned without it.
+func optionalSpotifyProvider(c *gin.Context, database *db.Db, user *db.User) music.ResourceProvider {
+	if err := user.AttachSpotifyAuth(c, database); err != nil {
+		if err != db.ErrSpotifyProfileNotLinked {
+			log.Printf("optionalSpotifyProvider: error attaching spotify auth for {%v}, continuing without it: %v\n", user.Id.String(), err)
+		}
+
+		return nil
+	}
+
+	return user.Spotify
+}
+
+// maximum value of the "limit" URL parameter of HandlerTopTracks and HandlerPlays
+const maxListLimit = 200
+
+// Respond with the current user's most played tracks. The optional "mode" query parameter is one of
+// "track" (default) or "recording", the latter counting plays of all versions of the same recording together.
+// The optional "from" and "to" parameters are RFC 3339 timestamps bounding the range of plays.
+func HandlerTopTracks(database *db.Db) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("current_user").(*db.User)
+
+		mode := db.StatsMode(c.DefaultQuery("mode", string(db.StatsByTrack)))
+
+		limit := 50
+		if requestedLimit := c.Query("limit"); requestedLimit != "" {
+			var err error
+			if limit, err = strconv.Atoi(requestedLimit); err != nil || limit < 1 || limit > maxListLimit {
+				c.AbortWithStatusJSON(http.StatusBadRequest, responseBadRequest)
+				return
+			}
+		}
+
+		var from, to time.Time
+		for param, dest := range map[string]*time.Time{"from": &from, "to": &to} {
+			if value := c.Query(param); value != "" {
+				parsed, err := time.Parse(time.RFC3339, value)
+				if err != nil {
+					c.AbortWithStatusJSON(http.StatusBadRequest, responseBadRequest)
+					return
+				}
+
+				*dest = parsed
+			}
+		}
+
+		stats, err := user.GetTopTracks(c, database, from, to, limit, mode, user.Spotify)
+		if err != nil {
+			if err == db.ErrInvalidStatsMode {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"ERROR": "INVALID_STATS_MODE"})
+				return
+			}
+
+			log.Printf("HandlerTopTracks: error getting top tracks for {%v}: %v\n", user.Id.String(), err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
+			return
+		}
+
+		response := make([]gin.H, len(stats))
+		for idx, stat := range stats {
+			trackIds := make([]string, len(stat.Tracks))
+			for trackIdx, track := range stat.Tracks {
+				trackIds[trackIdx] = track.SpotifyId
+			}
+
+			response[idx] = gin.H{
+				"plays":         stat.Plays,
+				"track":         stat.Track,
+				"recording_key": stat.RecordingKey,
+				"track_ids":     trackIds,
+			}
+		}
+
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// Respond with a page of the current user's play history, most recent first. Optional URL parameters:
+// "limit", "before" (the cursor returned as "next" by the previous page), "from" and "to" (RFC3339),
+// "artist", "album" and "track" (Spotify ids) and "explicit" (true or false).
+func HandlerPlays(database *db.Db) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("current_user").(*db.User)
+
+		limit := 50
+		if requestedLimit := c.Query("limit"); requestedLimit != "" {
+			var err error
+			if limit, err = strconv.Atoi(requestedLimit); err != nil || limit < 1 || limit > maxListLimit {
+				c.AbortWithStatusJSON(http.StatusBadRequest, responseBadRequest)
+				return
+			}
+		}
+
+		// cursors are the time and the track id of the last play of the previous page, separated by a comma
+		var before db.PlayCursor
+		if cursor := c.Query("before"); cursor != "" {
+			at, trackId, _ := strings.Cut(cursor, ",")
+
+			var err error
+			if before.At, err = time.Parse(time.RFC3339Nano, at); err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, responseBadRequest)
+				return
+			}
+
+			before.TrackId = trackId
+		}
+
+		filter := db.PlayFilter{
+			ArtistId: c.Query("artist"),
+			AlbumId:  c.Query("album"),
+			TrackId:  c.Query("track"),
+		}
+
+		for param, dest := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+			if value := c.Query(param); value != "" {
+				parsed, err := time.Parse(time.RFC3339, value)
+				if err != nil {
+					c.AbortWithStatusJSON(http.StatusBadRequest, responseBadRequest)
+					return
+				}
+
+				*dest = parsed
+			}
+		}
+
+		if value := c.Query("explicit"); value != "" {
+			explicit, err := strconv.ParseBool(value)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, responseBadRequest)
+				return
+			}
+
+			filter.Explicit = &explicit
+		}
+
+		plays, err := user.GetPlays(c, database, filter, before, limit, optionalSpotifyProvider(c, database, user))
+		if err != nil {
+			log.Printf("HandlerPlays: error getting plays for {%v}: %v\n", user.Id.String(), err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
+			return
+		}
+
+		response := make([]gin.H, len(plays))
+		for idx, play := range plays {
+			response[idx] = gin.H{
+				"at":    play.At,
+				"track": play.Track,
+			}
+		}
+
+		// a full page may be followed by more plays
+		var next *string
+		if len(plays) == limit {
+			last := db.PlayCursorAfter(plays[len(plays)-1])
+			cursor := last.At.Format(time.RFC3339Nano) + "," + last.TrackId
+			next = &cursor
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"plays": response,
+			"next":  next,
+		})
+	}
+}
@@ -0,0 +1,781 @@
+package webapi
+
+import (
+	"bool3max/musicdash/db"
+	"bool3max/musicdash/lastfm"
+	"bool3max/musicdash/listenbrainz"
+	"bool3max/musicdash/music"
+	"bool3max/musicdash/spotify"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// maximum total size of uploaded streaming history files. A complete privacy export, zipped, is
+	// usually only a few dozen megabytes.
+	maxImportUploadSize = 256 << 20
+
+	// number of plays imported at once, after which the job's progress is saved
+	importBatchSize = 500
+)
+
+func importJobResponse(job db.ImportJob) gin.H {
+	return gin.H{
+		"id":          job.Id,
+		"source":      job.Source,
+		"status":      job.Status,
+		"error":       job.Error,
+		"total":       job.Total,
+		"processed":   job.Processed,
+		"imported":    job.Imported,
+		"duplicates":  job.Duplicates,
+		"skipped":     job.Skipped,
+		"created_at":  job.CreatedAt,
+		"updated_at":  job.UpdatedAt,
+		"finished_at": job.FinishedAt,
+	}
+}
+
+// Make sure that all of the given tracks are preserved, obtaining the ones that aren't from provider, and
+// return the set of ids whose tracks are preserved afterwards. Tracks that can't be obtained, such as
+// ones removed from Spotify, are left out. provider may be nil, in which case only preserved tracks are.
+func resolveImportTracks(ctx context.Context, database *db.Db, ids []string, provider music.ResourceProvider) (map[string]bool, error) {
+	missingIds, err := database.UnpreservedTrackIds(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	missing := make(map[string]bool, len(missingIds))
+	for _, id := range missingIds {
+		missing[id] = true
+	}
+
+	// only tracks with Spotify ids can be obtained from Spotify
+	fetchIds := make([]string, 0, len(missingIds))
+	for _, id := range missingIds {
+		if music.IsSpotifyId(id) {
+			fetchIds = append(fetchIds, id)
+		}
+	}
+
+	for start := 0; provider != nil && start < len(fetchIds); start += spotify.API_MAX_PER_REQUEST_TRACK {
+		chunk := fetchIds[start:min(start+spotify.API_MAX_PER_REQUEST_TRACK, len(fetchIds))]
+
+		tracks, err := provider.GetSeveralTracksById(chunk)
+		if err != nil {
+			return nil, err
+		}
+
+		for idx, track := range tracks {
+			// unknown ids are returned as empty tracks
+			if track.SpotifyId != chunk[idx] {
+				continue
+			}
+
+			if err := track.Preserve(ctx, database.Pool(), false); err != nil {
+				log.Printf("resolveImportTracks: error preserving track {%v}: %v\n", track.SpotifyId, err)
+				continue
+			}
+
+			delete(missing, track.SpotifyId)
+		}
+	}
+
+	resolved := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if !missing[id] {
+			resolved[id] = true
+		}
+	}
+
+	return resolved, nil
+}
+
+// An error failing an import job, whose reason is shown to the user. Other errors are only logged,
+// with the job failing for an "internal error".
+type importJobError struct {
+	reason string
+	err    error
+}
+
+func (err importJobError) Error() string {
+	return err.reason + ": " + err.err.Error()
+}
+
+func (err importJobError) Unwrap() error {
+	return err.err
+}
+
+// Run an import job of the user in the background. fn performs the import, updating the progress in job
+// and saving it as it goes, after which the job is marked as done, or as failed if fn returns an error.
+// The outcome can only be recorded in job, as the request that started it has long been responded to.
+func runImportJob(database *db.Db, user *db.User, job db.ImportJob, fn func(ctx context.Context, job *db.ImportJob) error) {
+	ctx := context.Background()
+
+	job.Status = db.ImportJobRunning
+	err := database.SaveImportJob(ctx, &job)
+	if err == nil {
+		err = fn(ctx, &job)
+	}
+
+	if err != nil {
+		log.Printf("runImportJob: job {%v} of {%v} failed: %v\n", job.Id.String(), user.Id.String(), err)
+
+		job.Status = db.ImportJobFailed
+		job.Error = "internal error"
+
+		var jobErr importJobError
+		if errors.As(err, &jobErr) {
+			job.Error = jobErr.reason
+		}
+	} else {
+		job.Status = db.ImportJobDone
+	}
+
+	if err := database.SaveImportJob(ctx, &job); err != nil {
+		log.Printf("runImportJob: error finishing job {%v}: %v\n", job.Id.String(), err)
+	}
+}
+
+// Save the progress of a running import job, logging, rather than failing the job, if that doesn't succeed.
+func saveImportProgress(ctx context.Context, database *db.Db, job *db.ImportJob) {
+	if err := database.SaveImportJob(ctx, job); err != nil {
+		log.Printf("saveImportProgress: error saving progress of job {%v}: %v\n", job.Id.String(), err)
+	}
+}
+
+// Import plays, of which only the track ids are known, into the user's history as part of job, resolving
+// and preserving their tracks through provider. Plays whose tracks can't be resolved are skipped.
+func importPlaysByTrackId(ctx context.Context, database *db.Db, user *db.User, job *db.ImportJob, plays []spotify.Play, provider music.ResourceProvider) error {
+	for start := 0; start < len(plays); start += importBatchSize {
+		batch := plays[start:min(start+importBatchSize, len(plays))]
+
+		ids := make([]string, len(batch))
+		for idx, play := range batch {
+			ids[idx] = play.Track.SpotifyId
+		}
+
+		resolved, err := resolveImportTracks(ctx, database, ids, provider)
+		if err != nil {
+			return importJobError{"error resolving tracks", err}
+		}
+
+		toImport := make([]spotify.Play, 0, len(batch))
+		for _, play := range batch {
+			if resolved[play.Track.SpotifyId] {
+				toImport = append(toImport, play)
+			}
+		}
+
+		imported, duplicates, err := user.ImportPlays(ctx, database, job.Source, toImport)
+		if err != nil {
+			return err
+		}
+
+		job.Imported += imported
+		job.Duplicates += duplicates
+		job.Skipped += len(batch) - len(toImport)
+		job.Processed += len(batch)
+
+		saveImportProgress(ctx, database, job)
+	}
+
+	return nil
+}
+
+// The outcome of matching plays with some metadata to a track.
+type trackMatch struct {
+	// empty if the plays couldn't be matched
+	trackId string
+
+	// review of the match if it's pending, otherwise uuid.Nil
+	reviewId uuid.UUID
+}
+
+// A trackMatcher matches plays of a user, known only by their metadata, to tracks through a music.Matcher
+// searching the database and then provider, if it's a music.TrackSearcher. Low-confidence matches are
+// put up for review by the user, and matches the user already reviewed are reused. The outcome for every
+// distinct artist, album and title is remembered, so that every distinct track is only matched once.
+type trackMatcher struct {
+	database *db.Db
+	user     *db.User
+	provider music.ResourceProvider
+	matcher  *music.Matcher
+
+	// keyed by the lowercased metadata
+	matched map[[3]string]trackMatch
+}
+
+// provider may be nil, in which case only preserved tracks are matched.
+func newTrackMatcher(database *db.Db, user *db.User, provider music.ResourceProvider) *trackMatcher {
+	searchers := []music.TrackSearcher{database}
+	if searcher, ok := provider.(music.TrackSearcher); ok {
+		searchers = append(searchers, searcher)
+	}
+
+	return &trackMatcher{
+		database: database,
+		user:     user,
+		provider: provider,
+		matcher:  music.NewMatcher(searchers...),
+		matched:  make(map[[3]string]trackMatch),
+	}
+}
+
+// Convert a candidate to its stored form.
+func newMatchReviewCandidate(candidate music.MatchCandidate) db.MatchReviewCandidate {
+	artists := make([]string, len(candidate.Track.Artists))
+	for idx, artist := range candidate.Track.Artists {
+		artists[idx] = artist.Name
+	}
+
+	return db.MatchReviewCandidate{
+		SpotifyId:  candidate.Track.SpotifyId,
+		Title:      candidate.Track.Title,
+		Artists:    artists,
+		Album:      candidate.Track.Album.Title,
+		DurationMs: candidate.Track.Duration.Milliseconds(),
+		Score:      candidate.Score,
+	}
+}
+
+// Match a play to a track. Tracks matched through provider are preserved.
+func (matcher *trackMatcher) match(ctx context.Context, play importedPlay) (trackMatch, error) {
+	key := [3]string{strings.ToLower(play.Artist), strings.ToLower(play.Album), strings.ToLower(play.Title)}
+
+	if match, ok := matcher.matched[key]; ok {
+		return match, nil
+	}
+
+	match, err := matcher.matchUncached(ctx, play)
+	if err != nil {
+		return trackMatch{}, err
+	}
+
+	matcher.matched[key] = match
+	return match, nil
+}
+
+func (matcher *trackMatcher) matchUncached(ctx context.Context, play importedPlay) (trackMatch, error) {
+	review, err := matcher.user.GetMatchReviewByMetadata(ctx, matcher.database, play.Artist, play.Album, play.Title)
+	if err == nil {
+		if review.Status == db.MatchReviewPending {
+			return trackMatch{trackId: review.SpotifyId, reviewId: review.Id}, nil
+		}
+
+		return trackMatch{trackId: review.SpotifyId}, nil
+	} else if err != db.ErrMatchReviewNotFound {
+		return trackMatch{}, err
+	}
+
+	result, err := matcher.matcher.Match(ctx, music.MatchQuery{
+		Artist:   play.Artist,
+		Album:    play.Album,
+		Title:    play.Title,
+		Duration: play.Duration,
+		Isrc:     play.Isrc,
+	})
+
+	if err != nil || result.Status == music.MatchNotFound {
+		return trackMatch{}, err
+	}
+
+	best := result.Best()
+
+	// candidates found through provider are preserved as obtained by their id, as search results lack details
+	if best.Searcher > 0 {
+		resolved, err := resolveImportTracks(ctx, matcher.database, []string{best.Track.SpotifyId}, matcher.provider)
+		if err != nil || !resolved[best.Track.SpotifyId] {
+			return trackMatch{}, err
+		}
+	}
+
+	if result.Status == music.MatchAccepted {
+		return trackMatch{trackId: best.Track.SpotifyId}, nil
+	}
+
+	candidates := make([]db.MatchReviewCandidate, len(result.Candidates))
+	for idx, candidate := range result.Candidates {
+		candidates[idx] = newMatchReviewCandidate(candidate)
+	}
+
+	review, err = matcher.user.NewMatchReview(ctx, matcher.database, db.MatchReview{
+		Artist:     play.Artist,
+		Album:      play.Album,
+		Title:      play.Title,
+		SpotifyId:  best.Track.SpotifyId,
+		Score:      best.Score,
+		Candidates: candidates,
+	})
+
+	if err != nil {
+		return trackMatch{}, err
+	}
+
+	return trackMatch{trackId: review.SpotifyId, reviewId: review.Id}, nil
+}
+
+// A play to be imported, as described by the service it's imported from.
+type importedPlay struct {
+	At     time.Time
+	Artist string
+	Album  string
+	Title  string
+
+	// zero if unknown
+	Duration time.Duration
+	// empty if unknown
+	Isrc string
+	// Spotify id of the track, if known
+	SpotifyId string
+}
+
+// Plays matched to tracks by matchImportedPlays().
+type matchedPlays struct {
+	plays []spotify.Play
+	// ids of the reviews of the plays' matches, in order of plays, see trackMatch
+	reviewIds []uuid.UUID
+
+	unmatched []db.UnmatchedPlay
+}
+
+// Match plays to tracks, returning the matched plays along with the ones that couldn't be matched. Plays
+// with a known Spotify id are resolved through resolveImportTracks(), and the rest, or ones whose id can't
+// be resolved, are matched through matcher.
+func matchImportedPlays(ctx context.Context, database *db.Db, plays []importedPlay, matcher *trackMatcher) (matchedPlays, error) {
+	ids := make([]string, 0, len(plays))
+	for _, play := range plays {
+		if play.SpotifyId != "" {
+			ids = append(ids, play.SpotifyId)
+		}
+	}
+
+	resolved, err := resolveImportTracks(ctx, database, ids, matcher.provider)
+	if err != nil {
+		return matchedPlays{}, importJobError{"error resolving tracks", err}
+	}
+
+	result := matchedPlays{
+		plays:     make([]spotify.Play, 0, len(plays)),
+		reviewIds: make([]uuid.UUID, 0, len(plays)),
+		unmatched: make([]db.UnmatchedPlay, 0),
+	}
+
+	for _, play := range plays {
+		match := trackMatch{trackId: play.SpotifyId}
+		if !resolved[play.SpotifyId] {
+			if match, err = matcher.match(ctx, play); err != nil {
+				return matchedPlays{}, importJobError{"error matching plays", err}
+			}
+		}
+
+		if match.trackId == "" {
+			result.unmatched = append(result.unmatched, db.UnmatchedPlay{
+				At:     play.At,
+				Artist: play.Artist,
+				Album:  play.Album,
+				Title:  play.Title,
+			})
+
+			continue
+		}
+
+		result.plays = append(result.plays, spotify.Play{
+			At:    play.At,
+			Track: music.Track{SpotifyId: match.trackId},
+		})
+
+		result.reviewIds = append(result.reviewIds, match.reviewId)
+	}
+
+	return result, nil
+}
+
+// Record matched plays in the user's history, linking the ones matched with low confidence to their
+// reviews. Returns the number of recorded plays and of duplicates, as User.ImportPlays() does.
+func recordMatchedPlays(ctx context.Context, database *db.Db, user *db.User, source db.PlaySource, matched matchedPlays) (int, int, error) {
+	imported, duplicates, err := user.ImportPlays(ctx, database, source, matched.plays)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if err := user.LinkPlaysToReviews(ctx, database, source, matched.plays, matched.reviewIds); err != nil {
+		return 0, 0, err
+	}
+
+	return imported, duplicates, nil
+}
+
+// Import plays into the user's history as part of job, matching them to tracks through
+// matchImportedPlays(). Plays that can't be matched are recorded as unmatched plays of the job.
+func importMatchedPlays(ctx context.Context, database *db.Db, user *db.User, job *db.ImportJob, plays []importedPlay, matcher *trackMatcher) error {
+	for start := 0; start < len(plays); start += importBatchSize {
+		batch := plays[start:min(start+importBatchSize, len(plays))]
+
+		matched, err := matchImportedPlays(ctx, database, batch, matcher)
+		if err != nil {
+			return err
+		}
+
+		imported, duplicates, err := recordMatchedPlays(ctx, database, user, job.Source, matched)
+		if err != nil {
+			return err
+		}
+
+		if err := database.SaveUnmatchedPlays(ctx, job.Id, matched.unmatched); err != nil {
+			return err
+		}
+
+		job.Imported += imported
+		job.Duplicates += duplicates
+		job.Skipped += len(matched.unmatched)
+		job.Processed += len(batch)
+
+		saveImportProgress(ctx, database, job)
+	}
+
+	return nil
+}
+
+// Import all scrobbles of a Last.fm user into the user's history as part of job, matching each one to a
+// track by its metadata through importMatchedPlays().
+func importLastfmScrobbles(ctx context.Context, database *db.Db, lastfmClient *lastfm.Client, user *db.User, job *db.ImportJob, username string, provider music.ResourceProvider) error {
+	scrobbles, err := lastfmClient.GetPlays(ctx, username, time.Time{}, time.Time{})
+	if err != nil {
+		var apiErr *lastfm.Error
+		if errors.As(err, &apiErr) && apiErr.Code == lastfm.ErrorCodeInvalidParameters {
+			return importJobError{"Last.fm user not found", err}
+		}
+
+		return importJobError{"error fetching scrobbles from Last.fm", err}
+	}
+
+	job.Total = len(scrobbles)
+	saveImportProgress(ctx, database, job)
+
+	plays := make([]importedPlay, len(scrobbles))
+	for idx, scrobble := range scrobbles {
+		plays[idx] = importedPlay{
+			At:     scrobble.Timestamp,
+			Artist: scrobble.Artist,
+			Album:  scrobble.Album,
+			Title:  scrobble.Title,
+		}
+	}
+
+	return importMatchedPlays(ctx, database, user, job, plays, newTrackMatcher(database, user, provider))
+}
+
+// Import all listens of a ListenBrainz user into the user's history as part of job, a page at a time.
+// Listens submitted from Spotify are imported by their track ids, and the rest are matched to tracks by
+// their metadata, see importMatchedPlays().
+func importListenBrainzListens(ctx context.Context, database *db.Db, listenbrainzClient *listenbrainz.Client, user *db.User, job *db.ImportJob, username string, provider music.ResourceProvider) error {
+	total, err := listenbrainzClient.GetListenCount(ctx, username)
+	if err != nil {
+		var apiErr *listenbrainz.Error
+		if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+			return importJobError{"ListenBrainz user not found", err}
+		}
+
+		return importJobError{"error fetching listens from ListenBrainz", err}
+	}
+
+	job.Total = total
+	saveImportProgress(ctx, database, job)
+
+	matcher := newTrackMatcher(database, user, provider)
+
+	var pageErr error
+	err = listenbrainzClient.ForEachListenPage(ctx, username, func(listens []listenbrainz.Listen) error {
+		plays := make([]importedPlay, len(listens))
+		for idx, listen := range listens {
+			plays[idx] = importedPlay{
+				At:        listen.ListenedAt,
+				Artist:    listen.Artist,
+				Album:     listen.Release,
+				Title:     listen.Track,
+				Duration:  listen.Duration,
+				Isrc:      listen.Isrc,
+				SpotifyId: listen.SpotifyId,
+			}
+		}
+
+		pageErr = importMatchedPlays(ctx, database, user, job, plays, matcher)
+		return pageErr
+	})
+
+	// errors of importing a page are returned as they are, and the rest are of fetching listens
+	if pageErr != nil {
+		return pageErr
+	} else if err != nil {
+		return importJobError{"error fetching listens from ListenBrainz", err}
+	}
+
+	return nil
+}
+
+// Start importing the current user's Spotify Extended Streaming History, uploaded as one or more
+// multipart "files": Streaming_History_Audio_*.json or endsong_*.json files, or zip archives containing
+// them, such as the privacy export itself. Streams that don't count as plays are ignored, as are skipped
+// ones unless the "include_skipped" URL parameter is true. The files are parsed right away, whereas
+// importing the plays runs in the background: the created job is responded with, and its progress can
+// be followed through HandlerImportJob.
+func HandlerImportSpotifyHistory(database *db.Db) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("current_user").(*db.User)
+
+		includeSkipped := false
+		if value := c.Query("include_skipped"); value != "" {
+			var err error
+			if includeSkipped, err = strconv.ParseBool(value); err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, responseBadRequest)
+				return
+			}
+		}
+
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportUploadSize)
+
+		form, err := c.MultipartForm()
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"ERROR": "UPLOAD_TOO_LARGE"})
+				return
+			}
+
+			c.AbortWithStatusJSON(http.StatusBadRequest, responseBadRequest)
+			return
+		}
+
+		files := form.File["files"]
+		if len(files) == 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, responseBadRequest)
+			return
+		}
+
+		plays := make([]spotify.Play, 0)
+		ignored := 0
+		collect := func(entry spotify.HistoryEntry) error {
+			if !entry.IsPlay(spotify.MinHistoryPlayDuration, includeSkipped) {
+				ignored++
+				return nil
+			}
+
+			plays = append(plays, spotify.Play{
+				At:    entry.At,
+				Track: music.Track{SpotifyId: entry.TrackId},
+			})
+
+			return nil
+		}
+
+		for _, header := range files {
+			isZip := strings.EqualFold(path.Ext(header.Filename), ".zip")
+			if !isZip && !spotify.IsStreamingHistoryFile(header.Filename) {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"ERROR": "UNSUPPORTED_FILE", "file": header.Filename})
+				return
+			}
+
+			file, err := header.Open()
+			if err != nil {
+				log.Printf("HandlerImportSpotifyHistory: error opening uploaded file: %v\n", err)
+				c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
+				return
+			}
+
+			if isZip {
+				var parsed int
+				if parsed, err = spotify.ParseStreamingHistoryZip(file, header.Size, collect); err == nil && parsed == 0 {
+					err = spotify.ErrInvalidStreamingHistory
+				}
+			} else {
+				err = spotify.ParseStreamingHistory(file, collect)
+			}
+
+			file.Close()
+
+			if err != nil {
+				if errors.Is(err, spotify.ErrInvalidStreamingHistory) {
+					c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"ERROR": "INVALID_STREAMING_HISTORY", "file": header.Filename})
+					return
+				}
+
+				log.Printf("HandlerImportSpotifyHistory: error parsing {%v} for {%v}: %v\n", header.Filename, user.Id.String(), err)
+				c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
+				return
+			}
+		}
+
+		job, err := user.NewImportJob(c, database, db.PlaySourceSpotifyHistory, len(plays))
+		if err != nil {
+			log.Printf("HandlerImportSpotifyHistory: error creating import job for {%v}: %v\n", user.Id.String(), err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
+			return
+		}
+
+		go runImportJob(database, user, job, func(ctx context.Context, job *db.ImportJob) error {
+			return importPlaysByTrackId(ctx, database, user, job, plays, user.Spotify)
+		})
+
+		response := importJobResponse(job)
+		response["ignored"] = ignored
+		c.JSON(http.StatusAccepted, response)
+	}
+}
+
+// Start importing all scrobbles of the Last.fm user in the request body into the current user's history.
+// Scrobbles are matched to tracks by their metadata, and the ones that can't be matched are reported
+// through HandlerImportUnmatched. The import runs in the background, as with HandlerImportSpotifyHistory.
+func HandlerImportLastfm(database *db.Db, lastfmClient *lastfm.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("current_user").(*db.User)
+
+		if lastfmClient == nil {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, responseLastfmNotConfigured)
+			return
+		}
+
+		var requestData LastfmImportRequestData
+		if err := c.ShouldBindJSON(&requestData); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, responseBadRequest)
+			return
+		}
+
+		job, err := user.NewImportJob(c, database, db.PlaySourceLastfm, 0)
+		if err != nil {
+			log.Printf("HandlerImportLastfm: error creating import job for {%v}: %v\n", user.Id.String(), err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
+			return
+		}
+
+		go runImportJob(database, user, job, func(ctx context.Context, job *db.ImportJob) error {
+			return importLastfmScrobbles(ctx, database, lastfmClient, user, job, requestData.Username, user.Spotify)
+		})
+
+		c.JSON(http.StatusAccepted, importJobResponse(job))
+	}
+}
+
+// Start importing all listens of the ListenBrainz user in the request body into the current user's
+// history. Listens that can't be matched to tracks are reported through HandlerImportUnmatched. The
+// import runs in the background, as with HandlerImportSpotifyHistory.
+func HandlerImportListenBrainz(database *db.Db, listenbrainzClient *listenbrainz.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("current_user").(*db.User)
+
+		var requestData ListenBrainzImportRequestData
+		if err := c.ShouldBindJSON(&requestData); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, responseBadRequest)
+			return
+		}
+
+		job, err := user.NewImportJob(c, database, db.PlaySourceListenBrainz, 0)
+		if err != nil {
+			log.Printf("HandlerImportListenBrainz: error creating import job for {%v}: %v\n", user.Id.String(), err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
+			return
+		}
+
+		go runImportJob(database, user, job, func(ctx context.Context, job *db.ImportJob) error {
+			return importListenBrainzListens(ctx, database, listenbrainzClient, user, job, requestData.Username, user.Spotify)
+		})
+
+		c.JSON(http.StatusAccepted, importJobResponse(job))
+	}
+}
+
+// Respond with all of the current user's import jobs, most recent first.
+func HandlerImportJobs(database *db.Db) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("current_user").(*db.User)
+
+		jobs, err := user.GetImportJobs(c, database)
+		if err != nil {
+			log.Printf("HandlerImportJobs: error getting import jobs of {%v}: %v\n", user.Id.String(), err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
+			return
+		}
+
+		response := make([]gin.H, len(jobs))
+		for idx, job := range jobs {
+			response[idx] = importJobResponse(job)
+		}
+
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// Respond with the status and progress of one of the current user's import jobs, identified by the
+// "jobId" URL parameter.
+func HandlerImportJob(database *db.Db) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("current_user").(*db.User)
+
+		jobId, err := uuid.Parse(c.Param("jobId"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, responseBadRequest)
+			return
+		}
+
+		job, err := user.GetImportJob(c, database, jobId)
+		if err != nil {
+			if err == db.ErrImportJobNotFound {
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"ERROR": "IMPORT_JOB_NOT_FOUND"})
+				return
+			}
+
+			log.Printf("HandlerImportJob: error getting import job {%v} of {%v}: %v\n", jobId.String(), user.Id.String(), err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
+			return
+		}
+
+		c.JSON(http.StatusOK, importJobResponse(job))
+	}
+}
+
+// Respond with the plays of one of the current user's import jobs, identified by the "jobId" URL
+// parameter, that couldn't be matched to tracks and so weren't imported.
+func HandlerImportUnmatched(database *db.Db) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("current_user").(*db.User)
+
+		jobId, err := uuid.Parse(c.Param("jobId"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, responseBadRequest)
+			return
+		}
+
+		unmatched, err := user.GetUnmatchedPlays(c, database, jobId)
+		if err != nil {
+			if err == db.ErrImportJobNotFound {
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"ERROR": "IMPORT_JOB_NOT_FOUND"})
+				return
+			}
+
+			log.Printf("HandlerImportUnmatched: error getting unmatched plays of job {%v}: %v\n", jobId.String(), err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
+			return
+		}
+
+		response := make([]gin.H, len(unmatched))
+		for idx, play := range unmatched {
+			response[idx] = gin.H{
+				"at":     play.At,
+				"artist": play.Artist,
+				"album":  play.Album,
+				"title":  play.Title,
+			}
+		}
+
+		c.JSON(http.StatusOK, response)
+	}
+}
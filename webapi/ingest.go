@@ -0,0 +1,482 @@
+package webapi
+
+import (
+	"bool3max/musicdash/db"
+	"bool3max/musicdash/listenbrainz"
+	"bool3max/musicdash/music"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maximum size of a submission to the ingest API
+	maxIngestRequestSize = 4 << 20
+
+	// maximum number of scrobbles in a single Audioscrobbler track.scrobble call, as with Last.fm
+	maxIngestScrobbles = 50
+
+	// ListenBrainz "import" submissions of more listens than this are matched in the background as an
+	// import job, rather than while the client waits for the response
+	maxIngestSyncListens = 50
+
+	// submitted plays further than this in the future are rejected, allowing for clients' clocks being off
+	maxIngestClockSkew = 10 * time.Minute
+
+	// number of unmatched submitted plays responded with by HandlerIngestUnmatched
+	ingestUnmatchedLimit = 500
+)
+
+// Match plays submitted through the ingest API to tracks, recording them in the user's history and
+// recording the ones that can't be matched as unmatched. Returns the number of recorded plays, and of
+// ignored ones: duplicates of already recorded plays and ones that couldn't be matched.
+func ingestPlays(ctx context.Context, database *db.Db, user *db.User, source db.PlaySource, plays []importedPlay, provider music.ResourceProvider) (int, int, error) {
+	matched, err := matchImportedPlays(ctx, database, plays, newTrackMatcher(database, user, provider))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	imported, duplicates, err := recordMatchedPlays(ctx, database, user, source, matched)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if err := user.SaveUnmatchedIngestedPlays(ctx, database, source, matched.unmatched); err != nil {
+		return 0, 0, err
+	}
+
+	return imported, duplicates + len(matched.unmatched), nil
+}
+
+// Issue a new ingest token for the current user, replacing any previous one, and respond with it. The
+// token is only ever responded with here.
+func HandlerNewIngestToken(database *db.Db) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("current_user").(*db.User)
+
+		token, err := user.NewIngestToken(c, database)
+		if err != nil {
+			log.Printf("HandlerNewIngestToken: error issuing ingest token for {%v}: %v\n", user.Id.String(), err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"token": token})
+	}
+}
+
+// Respond with when the current user's ingest token was issued and last used.
+func HandlerIngestToken(database *db.Db) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("current_user").(*db.User)
+
+		token, err := user.GetIngestToken(c, database)
+		if err != nil {
+			if err == db.ErrIngestTokenNotFound {
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"ERROR": "INGEST_TOKEN_NOT_FOUND"})
+				return
+			}
+
+			log.Printf("HandlerIngestToken: error getting ingest token of {%v}: %v\n", user.Id.String(), err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"created_at":   token.CreatedAt,
+			"last_used_at": token.LastUsedAt,
+		})
+	}
+}
+
+// Revoke the current user's ingest token.
+func HandlerRevokeIngestToken(database *db.Db) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("current_user").(*db.User)
+
+		if err := user.RevokeIngestToken(c, database); err != nil {
+			if err == db.ErrIngestTokenNotFound {
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"ERROR": "INGEST_TOKEN_NOT_FOUND"})
+				return
+			}
+
+			log.Printf("HandlerRevokeIngestToken: error revoking ingest token of {%v}: %v\n", user.Id.String(), err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
+			return
+		}
+
+		c.Status(http.StatusOK)
+	}
+}
+
+// Respond with the current user's most recent submitted plays that couldn't be matched to tracks.
+func HandlerIngestUnmatched(database *db.Db) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("current_user").(*db.User)
+
+		unmatched, err := user.GetUnmatchedIngestedPlays(c, database, ingestUnmatchedLimit)
+		if err != nil {
+			log.Printf("HandlerIngestUnmatched: error getting unmatched plays of {%v}: %v\n", user.Id.String(), err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
+			return
+		}
+
+		response := make([]gin.H, len(unmatched))
+		for idx, play := range unmatched {
+			response[idx] = gin.H{
+				"at":     play.At,
+				"source": play.Source,
+				"artist": play.Artist,
+				"album":  play.Album,
+				"title":  play.Title,
+			}
+		}
+
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// Respond with an error in the format of the ListenBrainz API.
+func listenbrainzError(c *gin.Context, status int, message string) {
+	c.AbortWithStatusJSON(status, gin.H{"code": status, "error": message})
+}
+
+// Return the user whose ingest token is given in the "Authorization: Token <token>" header of a
+// ListenBrainz API request, responding with an error and returning nil if there's none.
+func listenbrainzIngestUser(c *gin.Context, database *db.Db) *db.User {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Token ")
+	if !ok || token == "" {
+		listenbrainzError(c, http.StatusUnauthorized, "You need to provide an Authorization header.")
+		return nil
+	}
+
+	user, err := database.GetUserFromIngestToken(c, strings.TrimSpace(token))
+	if err != nil {
+		if err == db.ErrInvalidIngestToken {
+			listenbrainzError(c, http.StatusUnauthorized, "Invalid authorization token.")
+			return nil
+		}
+
+		log.Printf("listenbrainzIngestUser: error getting user from ingest token: %v\n", err)
+		listenbrainzError(c, http.StatusInternalServerError, "Internal server error.")
+		return nil
+	}
+
+	return &user
+}
+
+// Validate an ingest token as the ListenBrainz validate-token endpoint does, so that ListenBrainz clients
+// can be pointed at the ingest API.
+func HandlerIngestListenBrainzValidateToken(database *db.Db) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := listenbrainzIngestUser(c, database)
+		if user == nil {
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"code":      http.StatusOK,
+			"message":   "Token valid.",
+			"valid":     true,
+			"user_name": user.Username,
+		})
+	}
+}
+
+// Record plays submitted in the format of the ListenBrainz submit-listens endpoint, authenticated by an
+// ingest token. Submitted listens are matched to tracks by their Spotify id, if the client provides one,
+// or by their metadata otherwise. "playing_now" submissions are accepted, but not recorded. Submissions of
+// more than maxIngestSyncListens listens are accepted right away and recorded by an import job, whose
+// unmatched listens are reported through HandlerImportUnmatched.
+func HandlerIngestListenBrainzSubmit(database *db.Db, spotifyProvider music.ResourceProvider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := listenbrainzIngestUser(c, database)
+		if user == nil {
+			return
+		}
+
+		var submission listenbrainz.SubmitPayload
+		if err := json.NewDecoder(http.MaxBytesReader(c.Writer, c.Request.Body, maxIngestRequestSize)).Decode(&submission); err != nil {
+			listenbrainzError(c, http.StatusBadRequest, "Cannot parse JSON document.")
+			return
+		}
+
+		switch submission.ListenType {
+		case listenbrainz.ListenTypePlayingNow:
+			c.JSON(http.StatusOK, gin.H{"status": "ok"})
+			return
+		case listenbrainz.ListenTypeSingle:
+			if len(submission.Payload) != 1 {
+				listenbrainzError(c, http.StatusBadRequest, "JSON document should contain exactly one listen for listen_type single.")
+				return
+			}
+		case listenbrainz.ListenTypeImport:
+			if len(submission.Payload) > listenbrainz.MaxListensPerSubmission {
+				listenbrainzError(c, http.StatusBadRequest, "JSON document contains too many listens.")
+				return
+			}
+		default:
+			listenbrainzError(c, http.StatusBadRequest, "JSON document requires a valid listen_type key.")
+			return
+		}
+
+		latest := time.Now().Add(maxIngestClockSkew)
+
+		plays := make([]importedPlay, len(submission.Payload))
+		for idx, payload := range submission.Payload {
+			listen := payload.Listen()
+			if payload.ListenedAt <= 0 || listen.ListenedAt.After(latest) || listen.Artist == "" || listen.Track == "" {
+				listenbrainzError(c, http.StatusBadRequest, "Listens require a valid listened_at, artist_name and track_name.")
+				return
+			}
+
+			plays[idx] = importedPlay{
+				At:        listen.ListenedAt,
+				Artist:    listen.Artist,
+				Album:     listen.Release,
+				Title:     listen.Track,
+				Duration:  listen.Duration,
+				Isrc:      listen.Isrc,
+				SpotifyId: listen.SpotifyId,
+			}
+		}
+
+		if len(plays) > maxIngestSyncListens {
+			job, err := user.NewImportJob(c, database, db.PlaySourceIngestListenBrainz, len(plays))
+			if err != nil {
+				log.Printf("HandlerIngestListenBrainzSubmit: error creating import job for {%v}: %v\n", user.Id.String(), err)
+				listenbrainzError(c, http.StatusInternalServerError, "Internal server error.")
+				return
+			}
+
+			go runImportJob(database, user, job, func(ctx context.Context, job *db.ImportJob) error {
+				return importMatchedPlays(ctx, database, user, job, plays, newTrackMatcher(database, user, spotifyProvider))
+			})
+
+			c.JSON(http.StatusOK, gin.H{"status": "ok"})
+			return
+		}
+
+		if _, _, err := ingestPlays(c, database, user, db.PlaySourceIngestListenBrainz, plays, spotifyProvider); err != nil {
+			log.Printf("HandlerIngestListenBrainzSubmit: error ingesting listens of {%v}: %v\n", user.Id.String(), err)
+			listenbrainzError(c, http.StatusInternalServerError, "Internal server error.")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	}
+}
+
+// Codes of errors returned by the Audioscrobbler API
+const (
+	audioscrobblerErrorInvalidMethod     = 3
+	audioscrobblerErrorAuthentication    = 4
+	audioscrobblerErrorInvalidParameters = 6
+	audioscrobblerErrorInvalidSessionKey = 9
+	audioscrobblerErrorTemporary         = 16
+)
+
+type audioscrobblerErrorElement struct {
+	Code    int    `xml:"code,attr"`
+	Message string `xml:",chardata"`
+}
+
+type audioscrobblerSession struct {
+	Name       string `xml:"name" json:"name"`
+	Key        string `xml:"key" json:"key"`
+	Subscriber int    `xml:"subscriber" json:"subscriber"`
+}
+
+type audioscrobblerScrobbles struct {
+	Accepted int `xml:"accepted,attr"`
+	Ignored  int `xml:"ignored,attr"`
+}
+
+// The XML response format of the Audioscrobbler API, which is the default one. Only one of the optional
+// elements is present in a response.
+type audioscrobblerResponse struct {
+	XMLName xml.Name `xml:"lfm"`
+	Status  string   `xml:"status,attr"`
+
+	Error      *audioscrobblerErrorElement `xml:"error"`
+	Session    *audioscrobblerSession      `xml:"session"`
+	Scrobbles  *audioscrobblerScrobbles    `xml:"scrobbles"`
+	NowPlaying *struct{}                   `xml:"nowplaying"`
+}
+
+// Respond with the outcome of an Audioscrobbler API call, in JSON if the "format" parameter asks for it
+// and in XML otherwise.
+func respondAudioscrobbler(c *gin.Context, status int, response audioscrobblerResponse) {
+	if c.Request.Form.Get("format") != "json" {
+		c.XML(status, response)
+		return
+	}
+
+	body := gin.H{}
+	switch {
+	case response.Error != nil:
+		body["error"] = response.Error.Code
+		body["message"] = response.Error.Message
+	case response.Session != nil:
+		body["session"] = response.Session
+	case response.Scrobbles != nil:
+		body["scrobbles"] = gin.H{"@attr": gin.H{"accepted": response.Scrobbles.Accepted, "ignored": response.Scrobbles.Ignored}}
+	case response.NowPlaying != nil:
+		body["nowplaying"] = gin.H{}
+	}
+
+	c.JSON(status, body)
+}
+
+func audioscrobblerError(c *gin.Context, status, code int, message string) {
+	respondAudioscrobbler(c, status, audioscrobblerResponse{
+		Status: "failed",
+		Error:  &audioscrobblerErrorElement{Code: code, Message: message},
+	})
+	c.Abort()
+}
+
+// Parse the scrobbles of a track.scrobble call, given as "artist[i]", "track[i]", "timestamp[i]" and
+// optionally "album[i]" and "duration[i]" parameters, or without the indices for a single scrobble. Scrobbles that are
+// missing a parameter, are from the future, or exceed maxIngestScrobbles, are returned as ignored.
+func parseIngestScrobbles(params map[string][]string) ([]importedPlay, int) {
+	get := func(name string) string {
+		if values := params[name]; len(values) > 0 {
+			return values[0]
+		}
+
+		return ""
+	}
+
+	ignored := 0
+
+	suffixes := []string{""}
+	if get("artist") == "" {
+		suffixes = suffixes[:0]
+		for idx := 0; ; idx++ {
+			suffix := "[" + strconv.Itoa(idx) + "]"
+			if _, ok := params["artist"+suffix]; !ok {
+				break
+			}
+
+			if idx >= maxIngestScrobbles {
+				ignored++
+				continue
+			}
+
+			suffixes = append(suffixes, suffix)
+		}
+	}
+
+	latest := time.Now().Add(maxIngestClockSkew)
+
+	plays := make([]importedPlay, 0, len(suffixes))
+	for _, suffix := range suffixes {
+		timestamp, err := strconv.ParseInt(get("timestamp"+suffix), 10, 64)
+		artist, title := get("artist"+suffix), get("track"+suffix)
+
+		if err != nil || timestamp <= 0 || time.Unix(timestamp, 0).After(latest) || artist == "" || title == "" {
+			ignored++
+			continue
+		}
+
+		// the duration is optional, in seconds
+		duration, _ := strconv.Atoi(get("duration" + suffix))
+
+		plays = append(plays, importedPlay{
+			At:       time.Unix(timestamp, 0),
+			Artist:   artist,
+			Album:    get("album" + suffix),
+			Title:    title,
+			Duration: time.Duration(max(duration, 0)) * time.Second,
+		})
+	}
+
+	return plays, ignored
+}
+
+// A subset of the Audioscrobbler 2.0 API, as implemented by Last.fm, for players that can scrobble to a
+// custom Last.fm-compatible server. Calls are authenticated by an ingest token in place of a session key.
+// Supported methods are auth.getMobileSession, which takes the ingest token as the password and returns
+// it as the session key, track.updateNowPlaying, which is accepted but not recorded, and track.scrobble.
+// Signatures aren't verified, as ingest tokens aren't tied to an API key.
+func HandlerIngestAudioscrobbler(database *db.Db, spotifyProvider music.ResourceProvider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxIngestRequestSize)
+		if err := c.Request.ParseForm(); err != nil {
+			c.Request.Form = nil
+			audioscrobblerError(c, http.StatusBadRequest, audioscrobblerErrorInvalidParameters, "Invalid parameters")
+			return
+		}
+
+		params := c.Request.Form
+		method := strings.ToLower(params.Get("method"))
+
+		if method == "auth.getmobilesession" {
+			user, err := database.GetUserFromIngestToken(c, params.Get("password"))
+			if err != nil && err != db.ErrInvalidIngestToken {
+				log.Printf("HandlerIngestAudioscrobbler: error getting user from ingest token: %v\n", err)
+				audioscrobblerError(c, http.StatusInternalServerError, audioscrobblerErrorTemporary, "Temporary error")
+				return
+			}
+
+			if err == db.ErrInvalidIngestToken || !strings.EqualFold(user.Username, params.Get("username")) {
+				audioscrobblerError(c, http.StatusForbidden, audioscrobblerErrorAuthentication, "Authentication Failed")
+				return
+			}
+
+			respondAudioscrobbler(c, http.StatusOK, audioscrobblerResponse{
+				Status:  "ok",
+				Session: &audioscrobblerSession{Name: user.Username, Key: params.Get("password")},
+			})
+			return
+		}
+
+		if method != "track.scrobble" && method != "track.updatenowplaying" {
+			audioscrobblerError(c, http.StatusBadRequest, audioscrobblerErrorInvalidMethod, "Invalid Method")
+			return
+		}
+
+		user, err := database.GetUserFromIngestToken(c, params.Get("sk"))
+		if err != nil {
+			if err == db.ErrInvalidIngestToken {
+				audioscrobblerError(c, http.StatusForbidden, audioscrobblerErrorInvalidSessionKey, "Invalid session key")
+				return
+			}
+
+			log.Printf("HandlerIngestAudioscrobbler: error getting user from ingest token: %v\n", err)
+			audioscrobblerError(c, http.StatusInternalServerError, audioscrobblerErrorTemporary, "Temporary error")
+			return
+		}
+
+		if method == "track.updatenowplaying" {
+			respondAudioscrobbler(c, http.StatusOK, audioscrobblerResponse{Status: "ok", NowPlaying: &struct{}{}})
+			return
+		}
+
+		plays, ignored := parseIngestScrobbles(params)
+		if len(plays) == 0 && ignored == 0 {
+			audioscrobblerError(c, http.StatusBadRequest, audioscrobblerErrorInvalidParameters, "Invalid parameters")
+			return
+		}
+
+		accepted, skipped, err := ingestPlays(c, database, &user, db.PlaySourceIngestAudioscrobbler, plays, spotifyProvider)
+		if err != nil {
+			log.Printf("HandlerIngestAudioscrobbler: error ingesting scrobbles of {%v}: %v\n", user.Id.String(), err)
+			audioscrobblerError(c, http.StatusInternalServerError, audioscrobblerErrorTemporary, "Temporary error")
+			return
+		}
+
+		respondAudioscrobbler(c, http.StatusOK, audioscrobblerResponse{
+			Status:    "ok",
+			Scrobbles: &audioscrobblerScrobbles{Accepted: accepted, Ignored: ignored + skipped},
+		})
+	}
+}
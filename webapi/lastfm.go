@@ -0,0 +1,230 @@
+package webapi
+
+import (
+	"bool3max/musicdash/db"
+	"bool3max/musicdash/lastfm"
+	"bool3max/musicdash/music"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Last.fm ignores scrobbles older than this
+const lastfmMaxScrobbleAge = 14 * 24 * time.Hour
+
+var responseLastfmNotConfigured = gin.H{"ERROR": "LASTFM_NOT_CONFIGURED"}
+
+// Respond with a Last.fm authorization url that the user should be redirected to in order to link
+// a Last.fm account. Last.fm redirects the user back to the app with a "token" parameter, which is
+// to be forwarded to HandlerLastfmLinkAccount along with the "state" parameter of the redirect.
+func HandlerLastfmAuthUrl(lastfmClient *lastfm.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if lastfmClient == nil || lastfmClient.Secret == "" {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, responseLastfmNotConfigured)
+			return
+		}
+
+		state := randomState()
+
+		// Last.fm appends the token to the callback's query, which the frontend reads from the fragment
+		callback := "http://localhost:7070/#lastfm_connect_account?" + url.Values{"state": {state}}.Encode()
+
+		// save the generated random state on the client
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie("lastfm_connect_state", state, 300, "/", "", true, true)
+
+		c.JSON(http.StatusOK, gin.H{"redirect_url": lastfmClient.AuthorizationUrl(callback)})
+	}
+}
+
+// Link a Last.fm account to the current user's account, given the "token" and "state" URL parameters
+// forwarded from the Last.fm authorization redirect. Plays aggregated from then on are scrobbled to it.
+func HandlerLastfmLinkAccount(database *db.Db, lastfmClient *lastfm.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("current_user").(*db.User)
+
+		if lastfmClient == nil || lastfmClient.Secret == "" {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, responseLastfmNotConfigured)
+			return
+		}
+
+		queryToken := c.Query("token")
+		queryState := c.Query("state")
+
+		clientState, err := c.Cookie("lastfm_connect_state")
+		if queryToken == "" || queryState == "" || err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, responseBadRequest)
+			return
+		}
+
+		if queryState != clientState {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "States don't match."})
+			return
+		}
+
+		session, err := lastfmClient.GetSession(c, queryToken)
+		if err != nil {
+			var apiErr *lastfm.Error
+			if errors.As(err, &apiErr) {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"ERROR": "LASTFM_AUTHORIZATION"})
+				return
+			}
+
+			log.Printf("HandlerLastfmLinkAccount: error getting last.fm session for {%v}: %v\n", user.Id.String(), err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
+			return
+		}
+
+		if err := user.LinkLastfm(c, database, session.Username, session.Key); err != nil {
+			log.Printf("HandlerLastfmLinkAccount: error linking last.fm account of {%v}: %v\n", user.Id.String(), err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
+			return
+		}
+
+		c.SetCookie("lastfm_connect_state", "", -1, "/", "", true, true)
+		c.JSON(http.StatusOK, gin.H{"username": session.Username})
+	}
+}
+
+// Respond with the Last.fm account linked to the current user's account.
+func HandlerLastfmAccount(database *db.Db) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("current_user").(*db.User)
+
+		link, err := user.GetLastfmLink(c, database)
+		if err != nil {
+			if err == db.ErrLastfmNotLinked {
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"ERROR": "LASTFM_NOT_LINKED"})
+				return
+			}
+
+			log.Printf("HandlerLastfmAccount: error getting last.fm account of {%v}: %v\n", user.Id.String(), err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"username":        link.Username,
+			"linked_at":       link.LinkedAt,
+			"scrobbled_until": link.ScrobbledUntil,
+		})
+	}
+}
+
+// Unlink the Last.fm account linked to the current user's account, stopping scrobbling.
+func HandlerLastfmUnlink(database *db.Db) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("current_user").(*db.User)
+
+		if err := user.UnlinkLastfm(c, database); err != nil {
+			if err == db.ErrLastfmNotLinked {
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"ERROR": "LASTFM_NOT_LINKED"})
+				return
+			}
+
+			log.Printf("HandlerLastfmUnlink: error unlinking last.fm account of {%v}: %v\n", user.Id.String(), err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
+			return
+		}
+
+		c.Status(http.StatusOK)
+	}
+}
+
+// Whether track has the title and the artist that Last.fm and ListenBrainz require of every play. Plays
+// of tracks without them, such as local files without tags, are rejected by both.
+func hasScrobbleMetadata(track *music.Track) bool {
+	return track.Title != "" && len(track.Artists) > 0 && track.Artists[0].Name != ""
+}
+
+// Convert a play to a scrobble, crediting it to the track's main artist.
+func newScrobble(at time.Time, track *music.Track) lastfm.Scrobble {
+	scrobble := lastfm.Scrobble{
+		Track:     track.Title,
+		Timestamp: at,
+		Album:     track.Album.Title,
+		Duration:  track.Duration,
+	}
+
+	if len(track.Artists) > 0 {
+		scrobble.Artist = track.Artists[0].Name
+	}
+
+	if len(track.Album.Artists) > 0 {
+		scrobble.AlbumArtist = track.Album.Artists[0].Name
+	}
+
+	return scrobble
+}
+
+// Scrobble the user's plays saved since the last scrobbled one to the user's linked Last.fm account, if
+// any, in batches of lastfm.MaxScrobblesPerRequest, advancing the user's sync cursor after every batch.
+// Plays without the metadata Last.fm requires are skipped. If submitting fails, the remaining plays are
+// left to be scrobbled on a later run. If the user revoked musicdash's access on Last.fm, the account is
+// unlinked. Returns the number of accepted scrobbles.
+func scrobblePlays(ctx context.Context, database *db.Db, lastfmClient *lastfm.Client, user *db.User, spotifyProvider music.ResourceProvider) (int, error) {
+	link, err := user.GetLastfmLink(ctx, database)
+	if err != nil {
+		if err == db.ErrLastfmNotLinked {
+			return 0, nil
+		}
+
+		return 0, err
+	}
+
+	// plays that Last.fm would ignore aren't submitted at all
+	after := link.ScrobbledUntil
+	if oldest := time.Now().Add(-lastfmMaxScrobbleAge); after.Before(oldest) {
+		after = oldest
+	}
+
+	accepted := 0
+	for {
+		plays, err := user.GetPlaysToScrobble(ctx, database, after, lastfm.MaxScrobblesPerRequest, spotifyProvider)
+		if err != nil {
+			return accepted, err
+		}
+
+		if len(plays) == 0 {
+			return accepted, nil
+		}
+
+		scrobbles := make([]lastfm.Scrobble, 0, len(plays))
+		for _, play := range plays {
+			if hasScrobbleMetadata(&play.Track) {
+				scrobbles = append(scrobbles, newScrobble(play.At, &play.Track))
+			}
+		}
+
+		// a batch of only skipped plays still advances the cursor past them
+		if len(scrobbles) > 0 {
+			result, err := lastfmClient.Scrobble(ctx, link.SessionKey, scrobbles)
+			if err != nil {
+				var apiErr *lastfm.Error
+				if errors.As(err, &apiErr) && apiErr.Code == lastfm.ErrorCodeInvalidSessionKey {
+					log.Printf("scrobblePlays: last.fm session of {%v} revoked, unlinking\n", user.Id.String())
+					return accepted, user.UnlinkLastfm(ctx, database)
+				}
+
+				return accepted, err
+			}
+
+			accepted += result.Accepted
+		}
+
+		after = plays[len(plays)-1].At
+
+		if err := user.SetScrobbledUntil(ctx, database, after); err != nil {
+			return accepted, err
+		}
+
+		if len(plays) < lastfm.MaxScrobblesPerRequest {
+			return accepted, nil
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package webapi
+
+import (
+	"bool3max/musicdash/db"
+	"bool3max/musicdash/listenbrainz"
+	"bool3max/musicdash/music"
+	"bool3max/musicdash/spotify"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// number of plays submitted to ListenBrainz in a single request, so that the tracks of a batch's plays
+// that aren't preserved can be looked up with a single Spotify request
+const listenbrainzSubmitBatchSize = spotify.API_MAX_PER_REQUEST_TRACK
+
+// Link a ListenBrainz account to the current user's account, given the account's user token in the
+// request body. Plays aggregated from then on are submitted to it.
+func HandlerListenBrainzLinkAccount(database *db.Db, listenbrainzClient *listenbrainz.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("current_user").(*db.User)
+
+		var requestData ListenBrainzLinkRequestData
+		if err := c.ShouldBindJSON(&requestData); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, responseBadRequest)
+			return
+		}
+
+		username, err := listenbrainzClient.ValidateToken(c, requestData.Token)
+		if err != nil {
+			log.Printf("HandlerListenBrainzLinkAccount: error validating listenbrainz token of {%v}: %v\n", user.Id.String(), err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
+			return
+		}
+
+		if username == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"ERROR": "LISTENBRAINZ_INVALID_TOKEN"})
+			return
+		}
+
+		if err := user.LinkListenBrainz(c, database, username, requestData.Token); err != nil {
+			log.Printf("HandlerListenBrainzLinkAccount: error linking listenbrainz account of {%v}: %v\n", user.Id.String(), err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"username": username})
+	}
+}
+
+// Respond with the ListenBrainz account linked to the current user's account.
+func HandlerListenBrainzAccount(database *db.Db) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("current_user").(*db.User)
+
+		link, err := user.GetListenBrainzLink(c, database)
+		if err != nil {
+			if err == db.ErrListenBrainzNotLinked {
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"ERROR": "LISTENBRAINZ_NOT_LINKED"})
+				return
+			}
+
+			log.Printf("HandlerListenBrainzAccount: error getting listenbrainz account of {%v}: %v\n", user.Id.String(), err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"username":        link.Username,
+			"linked_at":       link.LinkedAt,
+			"submitted_until": link.SubmittedUntil,
+		})
+	}
+}
+
+// Unlink the ListenBrainz account linked to the current user's account, stopping submissions.
+func HandlerListenBrainzUnlink(database *db.Db) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("current_user").(*db.User)
+
+		if err := user.UnlinkListenBrainz(c, database); err != nil {
+			if err == db.ErrListenBrainzNotLinked {
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"ERROR": "LISTENBRAINZ_NOT_LINKED"})
+				return
+			}
+
+			log.Printf("HandlerListenBrainzUnlink: error unlinking listenbrainz account of {%v}: %v\n", user.Id.String(), err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
+			return
+		}
+
+		c.Status(http.StatusOK)
+	}
+}
+
+// Convert a play to a listen, crediting it to the track's main artist.
+func newListenPayload(at time.Time, track *music.Track) listenbrainz.ListenPayload {
+	payload := listenbrainz.ListenPayload{
+		ListenedAt: at.Unix(),
+		TrackMetadata: listenbrainz.TrackMetadata{
+			TrackName:   track.Title,
+			ReleaseName: track.Album.Title,
+			AdditionalInfo: &listenbrainz.AdditionalInfo{
+				DurationMs:       track.Duration.Milliseconds(),
+				Isrc:             track.Isrc,
+				SubmissionClient: "musicdash",
+			},
+		},
+	}
+
+	// tracks of local files aren't on Spotify
+	if music.IsSpotifyId(track.SpotifyId) {
+		payload.TrackMetadata.AdditionalInfo.SpotifyId = "https://open.spotify.com/track/" + track.SpotifyId
+		payload.TrackMetadata.AdditionalInfo.MusicService = "spotify.com"
+	}
+
+	if len(track.Artists) > 0 {
+		payload.TrackMetadata.ArtistName = track.Artists[0].Name
+	}
+
+	return payload
+}
+
+// Submit the user's plays saved since the last submitted one to the user's linked ListenBrainz account,
+// if any, in batches of listenbrainzSubmitBatchSize, advancing the user's sync cursor after every batch.
+// Plays without the metadata ListenBrainz requires are skipped, and a batch that ListenBrainz rejects as
+// invalid is logged and skipped as well, as it would be rejected again. If submitting fails otherwise, the
+// remaining plays are left to be submitted on a later run. If the user's token is no longer valid, the
+// account is unlinked. Returns the number of submitted plays.
+func submitListens(ctx context.Context, database *db.Db, listenbrainzClient *listenbrainz.Client, user *db.User, spotifyProvider music.ResourceProvider) (int, error) {
+	link, err := user.GetListenBrainzLink(ctx, database)
+	if err != nil {
+		if err == db.ErrListenBrainzNotLinked {
+			return 0, nil
+		}
+
+		return 0, err
+	}
+
+	after := link.SubmittedUntil
+	submitted := 0
+	for {
+		plays, err := user.GetPlaysToScrobble(ctx, database, after, listenbrainzSubmitBatchSize, spotifyProvider)
+		if err != nil {
+			return submitted, err
+		}
+
+		if len(plays) == 0 {
+			return submitted, nil
+		}
+
+		listens := make([]listenbrainz.ListenPayload, 0, len(plays))
+		for _, play := range plays {
+			if hasScrobbleMetadata(&play.Track) {
+				listens = append(listens, newListenPayload(play.At, &play.Track))
+			}
+		}
+
+		listenType := listenbrainz.ListenTypeImport
+		if len(listens) == 1 {
+			listenType = listenbrainz.ListenTypeSingle
+		}
+
+		// a batch of only skipped plays still advances the cursor past them
+		if len(listens) > 0 {
+			err := listenbrainzClient.SubmitListens(ctx, link.Token, listenType, listens)
+
+			var apiErr *listenbrainz.Error
+			switch {
+			case err == nil:
+				submitted += len(listens)
+			case errors.As(err, &apiErr) && apiErr.Code == http.StatusUnauthorized:
+				log.Printf("submitListens: listenbrainz token of {%v} revoked, unlinking\n", user.Id.String())
+				return submitted, user.UnlinkListenBrainz(ctx, database)
+			case errors.As(err, &apiErr) && apiErr.Code >= 400 && apiErr.Code < 500 && !apiErr.Temporary():
+				log.Printf("submitListens: listenbrainz rejected a batch of {%v} plays of {%v}, skipping it: %v\n", len(listens), user.Id.String(), err)
+			default:
+				return submitted, err
+			}
+		}
+
+		after = plays[len(plays)-1].At
+
+		if err := user.SetSubmittedUntil(ctx, database, after); err != nil {
+			return submitted, err
+		}
+
+		if len(plays) < listenbrainzSubmitBatchSize {
+			return submitted, nil
+		}
+	}
+}
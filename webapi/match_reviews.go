@@ -0,0 +1,144 @@
+package webapi
+
+import (
+	"bool3max/musicdash/db"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// maximum number of match reviews responded with by HandlerMatchReviews
+const matchReviewsLimit = 500
+
+func matchReviewResponse(review db.MatchReview) gin.H {
+	return gin.H{
+		"id":          review.Id,
+		"artist":      review.Artist,
+		"album":       review.Album,
+		"title":       review.Title,
+		"spotify_id":  review.SpotifyId,
+		"score":       review.Score,
+		"candidates":  review.Candidates,
+		"status":      review.Status,
+		"created_at":  review.CreatedAt,
+		"reviewed_at": review.ReviewedAt,
+	}
+}
+
+// Respond with the current user's most recent match reviews, pending ones unless the "status" URL
+// parameter is "confirmed" or "corrected".
+func HandlerMatchReviews(database *db.Db) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("current_user").(*db.User)
+
+		status := db.MatchReviewStatus(c.DefaultQuery("status", string(db.MatchReviewPending)))
+		switch status {
+		case db.MatchReviewPending, db.MatchReviewConfirmed, db.MatchReviewCorrected:
+		default:
+			c.AbortWithStatusJSON(http.StatusBadRequest, responseBadRequest)
+			return
+		}
+
+		reviews, err := user.GetMatchReviews(c, database, status, matchReviewsLimit)
+		if err != nil {
+			log.Printf("HandlerMatchReviews: error getting match reviews of {%v}: %v\n", user.Id.String(), err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
+			return
+		}
+
+		response := make([]gin.H, len(reviews))
+		for idx, review := range reviews {
+			response[idx] = matchReviewResponse(review)
+		}
+
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// Confirm the track of one of the current user's match reviews, identified by the "reviewId" URL parameter.
+func HandlerConfirmMatchReview(database *db.Db) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("current_user").(*db.User)
+
+		reviewId, err := uuid.Parse(c.Param("reviewId"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, responseBadRequest)
+			return
+		}
+
+		if err := user.ConfirmMatchReview(c, database, reviewId); err != nil {
+			if err == db.ErrMatchReviewNotFound {
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"ERROR": "MATCH_REVIEW_NOT_FOUND"})
+				return
+			}
+
+			log.Printf("HandlerConfirmMatchReview: error confirming match review {%v}: %v\n", reviewId.String(), err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
+			return
+		}
+
+		c.Status(http.StatusOK)
+	}
+}
+
+// Correct the track of one of the current user's match reviews, identified by the "reviewId" URL
+// parameter, to the track whose Spotify id is given in the request body. All plays recorded by the
+// match are re-pointed to it, and later plays with the same metadata are matched to it. Responds with
+// the number of re-pointed plays.
+func HandlerCorrectMatchReview(database *db.Db) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("current_user").(*db.User)
+
+		reviewId, err := uuid.Parse(c.Param("reviewId"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, responseBadRequest)
+			return
+		}
+
+		var requestData CorrectMatchReviewRequestData
+		if err := c.ShouldBindJSON(&requestData); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, responseBadRequest)
+			return
+		}
+
+		if _, err := user.GetMatchReview(c, database, reviewId); err != nil {
+			if err == db.ErrMatchReviewNotFound {
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"ERROR": "MATCH_REVIEW_NOT_FOUND"})
+				return
+			}
+
+			log.Printf("HandlerCorrectMatchReview: error getting match review {%v}: %v\n", reviewId.String(), err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
+			return
+		}
+
+		// the track has to be preserved for plays to be re-pointed to it
+		resolved, err := resolveImportTracks(c, database, []string{requestData.SpotifyId}, user.Spotify)
+		if err != nil {
+			log.Printf("HandlerCorrectMatchReview: error resolving track {%v}: %v\n", requestData.SpotifyId, err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
+			return
+		}
+
+		if !resolved[requestData.SpotifyId] {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"ERROR": "TRACK_NOT_FOUND"})
+			return
+		}
+
+		corrected, err := user.CorrectMatchReview(c, database, reviewId, requestData.SpotifyId)
+		if err != nil {
+			if err == db.ErrMatchReviewNotFound {
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"ERROR": "MATCH_REVIEW_NOT_FOUND"})
+				return
+			}
+
+			log.Printf("HandlerCorrectMatchReview: error correcting match review {%v}: %v\n", reviewId.String(), err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, responseInternalServerError)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"plays": corrected})
+	}
+}
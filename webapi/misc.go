@@ -2,6 +2,7 @@ package webapi
 
 import (
 	"encoding/base64"
+	"math/rand"
 	"net/http"
 	"regexp"
 )
@@ -25,3 +26,21 @@ func UsernameIsValid(username string) bool {
 
 	return hasAscii && regexp.MustCompile(`^([a-z]|[A-Z]|[0-9]|_){3,30}$`).MatchString(username)
 }
+
+// bcrypt max password byte length is 72 bytes and passwords are salted with a uuidv4 which is 16 bytes
+func PasswordIsValid(password string) bool {
+	return len(password) >= 8 && len(password) <= (72-16)
+}
+
+// Generate a random string for the state parameter of third-party authorization flows, which is also
+// saved on the client so that the flow can't be completed by anyone other than who started it.
+func randomState() string {
+	var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
+
+	state := make([]rune, 32)
+	for i := range state {
+		state[i] = letters[rand.Intn(len(letters))]
+	}
+
+	return string(state)
+}
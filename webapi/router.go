@@ -2,6 +2,9 @@ package webapi
 
 import (
 	"bool3max/musicdash/db"
+	"bool3max/musicdash/lastfm"
+	"bool3max/musicdash/listenbrainz"
+	"bool3max/musicdash/mailer"
 	"bool3max/musicdash/music"
 	"fmt"
 	"log"
@@ -11,7 +14,11 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func NewRouter(database *db.Db, spotifyProvider music.ResourceProvider) *gin.Engine {
+// lastfmClient may be nil if Last.fm isn't configured, in which case its features are unavailable.
+// listenbrainzClient must not be nil, see listenbrainz.FromEnv(). spotifyProvider is used for matching
+// plays submitted through the ingest API to tracks, and may be nil, in which case only preserved tracks
+// are matched.
+func NewRouter(database *db.Db, spotifyProvider music.ResourceProvider, mail mailer.Mailer, lastfmClient *lastfm.Client, listenbrainzClient *listenbrainz.Client) *gin.Engine {
 	var router = gin.Default()
 
 	api := router.Group("/api")
@@ -20,7 +27,7 @@ func NewRouter(database *db.Db, spotifyProvider music.ResourceProvider) *gin.Eng
 		{
 
 			// Sign-up using classic e-mail address and password combination.
-			groupAccount.POST("/signup", HandlerSignupCred(database))
+			groupAccount.POST("/signup", HandlerSignupCred(database, mail))
 
 			// Log-in using e-mail address and password.
 			groupAccount.POST("/login", HandlerLoginCred(database))
@@ -39,6 +46,16 @@ func NewRouter(database *db.Db, spotifyProvider music.ResourceProvider) *gin.Eng
 				HandlerSpotifyContinueWith(database),
 			)
 
+			// Request a password reset link to be e-mailed to the address in the request body.
+			groupAccount.POST("/forgot-password", HandlerForgotPassword(database, mail))
+
+			// Set a new password using the token from a password reset link.
+			groupAccount.POST("/reset-password", HandlerResetPassword(database))
+
+			// Confirm an e-mail address using the token from a verification link, either verifying the
+			// address the account was registered with or changing the account's address to a new one.
+			groupAccount.POST("/verify-email", HandlerVerifyEmail(database))
+
 			// from this point on all endpoints in the account group require valid auth
 			groupAccount.Use(AuthNeeded(database))
 
@@ -48,6 +65,12 @@ func NewRouter(database *db.Db, spotifyProvider music.ResourceProvider) *gin.Eng
 			// log out everywhere (i.e. revoke all active auth tokens for account)
 			groupAccount.DELETE("/logout-all", HandlerLogout(database, true))
 
+			// list all active sessions (i.e. devices logged into the account)
+			groupAccount.GET("/sessions", HandlerSessions(database))
+
+			// log out a single session, identified by the id listed by the endpoint above
+			groupAccount.DELETE("/sessions/:sessionId", HandlerRevokeSession(database))
+
 			// Link a Spotify account with an existing musicdash account. This endpoint requires the
 			// "code" and "state" url query parameters to be forwarded from the spotify auth response.
 			groupAccount.POST(
@@ -70,6 +93,63 @@ func NewRouter(database *db.Db, spotifyProvider music.ResourceProvider) *gin.Eng
 				"/update-username",
 				HandlerUpdateUsername(database),
 			)
+
+			// change the password, given the current one. All other sessions are logged out.
+			groupAccount.POST("/change-password", HandlerChangePassword(database))
+
+			// resend the verification link of the account's unverified e-mail address
+			groupAccount.POST("/resend-email-verification", HandlerResendEmailVerification(database, mail))
+
+			// change the e-mail address, confirmed by the current password. The change takes effect
+			// once confirmed through the link sent to the new address.
+			groupAccount.POST("/change-email", HandlerChangeEmail(database, mail))
+
+			// delete the account, confirmed by the password or by re-authorizing with Spotify. Unless
+			// requested to be deleted immediately, the account can be restored during a grace period.
+			groupAccount.DELETE("", HandlerDeleteAccount(database))
+
+			// restore an account scheduled for deletion
+			groupAccount.POST("/restore", HandlerRestoreAccount(database))
+
+			// download a zip archive of all of the account's data, including the complete play history
+			groupAccount.GET("/export", HandlerExport(database))
+
+			// download the play history in the format of another tool, "lastfm", "listenbrainz" or "spotify",
+			// optionally limited to the range given by the "from" and "to" RFC 3339 query parameters
+			groupAccount.GET("/export/:format", HandlerExportHistory(database))
+
+			// Obtain a Last.fm authorization url that the user should be redirected to in order to link
+			// a Last.fm account, which plays are then scrobbled to.
+			groupAccount.GET("/lastfm-auth-url", HandlerLastfmAuthUrl(lastfmClient))
+
+			// Link a Last.fm account. This endpoint requires the "token" and "state" url query parameters
+			// to be forwarded from the Last.fm auth redirect.
+			groupAccount.POST("/lastfm-link-account", HandlerLastfmLinkAccount(database, lastfmClient))
+
+			// the linked Last.fm account and how far scrobbling has progressed
+			groupAccount.GET("/lastfm", HandlerLastfmAccount(database))
+
+			// unlink the Last.fm account, stopping scrobbling
+			groupAccount.DELETE("/lastfm", HandlerLastfmUnlink(database))
+
+			// link a ListenBrainz account, given its user token as "Token" in the request body, which
+			// plays are then submitted to
+			groupAccount.POST("/listenbrainz", HandlerListenBrainzLinkAccount(database, listenbrainzClient))
+
+			// the linked ListenBrainz account and how far submitting has progressed
+			groupAccount.GET("/listenbrainz", HandlerListenBrainzAccount(database))
+
+			// unlink the ListenBrainz account, stopping submissions
+			groupAccount.DELETE("/listenbrainz", HandlerListenBrainzUnlink(database))
+
+			// issue a new token for submitting plays through the ingest API, replacing any previous one
+			groupAccount.POST("/ingest-token", HandlerNewIngestToken(database))
+
+			// when the ingest token was issued and last used
+			groupAccount.GET("/ingest-token", HandlerIngestToken(database))
+
+			// revoke the ingest token
+			groupAccount.DELETE("/ingest-token", HandlerRevokeIngestToken(database))
 		}
 
 		groupSpotify := api.Group("/spotify", AuthNeeded(database), SpotifyAuthNeeded(database))
@@ -96,6 +176,94 @@ func NewRouter(database *db.Db, spotifyProvider music.ResourceProvider) *gin.Eng
 			})
 		}
 
+		// statistics of the current user's plays. Spotify auth is needed for tracks that
+		// aren't preserved in the database
+		groupStats := api.Group("/stats", AuthNeeded(database), SpotifyAuthNeeded(database))
+		{
+			// optional URL parameters: "mode" (track or recording), "limit", "from" and "to"
+			groupStats.GET("/top-tracks", HandlerTopTracks(database))
+		}
+
+		// the current user's play history. Tracks that aren't preserved are completed through the user's
+		// Spotify account, if one is linked
+		groupMe := api.Group("/me", AuthNeeded(database))
+		{
+			// paginated, most recent first. Every play embeds the track along with its album and artists.
+			// optional URL parameters: "limit", "before", "from", "to", "artist", "album", "track" and "explicit"
+			groupMe.GET("/plays", HandlerPlays(database))
+		}
+
+		// importing plays from other sources. Imports run in the background as jobs, whose progress
+		// can be followed through the endpoints below.
+		groupImports := api.Group("/imports", AuthNeeded(database))
+		{
+			// list all import jobs of the current user, most recent first
+			groupImports.GET("", HandlerImportJobs(database))
+
+			groupImports.GET("/:jobId", HandlerImportJob(database))
+
+			// plays of an import job that couldn't be matched to tracks, and so weren't imported
+			groupImports.GET("/:jobId/unmatched", HandlerImportUnmatched(database))
+
+			// upload Spotify's Extended Streaming History, as the JSON files from the privacy export or
+			// the export's zip archive, in the multipart "files" field. Spotify auth is needed for
+			// resolving tracks that aren't preserved.
+			groupImports.POST(
+				"/spotify-history",
+				SpotifyAuthNeeded(database),
+				HandlerImportSpotifyHistory(database),
+			)
+
+			// import all scrobbles of the Last.fm user whose "Username" is given in the request body,
+			// matching them to tracks. Spotify auth is needed for tracks that aren't preserved.
+			groupImports.POST(
+				"/lastfm",
+				SpotifyAuthNeeded(database),
+				HandlerImportLastfm(database, lastfmClient),
+			)
+
+			// import all listens of the ListenBrainz user whose "Username" is given in the request body,
+			// matching them to tracks. Spotify auth is needed for tracks that aren't preserved.
+			groupImports.POST(
+				"/listenbrainz",
+				SpotifyAuthNeeded(database),
+				HandlerImportListenBrainz(database, listenbrainzClient),
+			)
+		}
+
+		// low-confidence matches of imported or submitted plays to tracks, for the user to review
+		groupMatchReviews := api.Group("/match-reviews", AuthNeeded(database))
+		{
+			// most recent reviews, pending ones unless the "status" URL parameter says otherwise
+			groupMatchReviews.GET("", HandlerMatchReviews(database))
+
+			// confirm that the matched track is the right one
+			groupMatchReviews.POST("/:reviewId/confirm", HandlerConfirmMatchReview(database))
+
+			// correct the matched track to the one whose "SpotifyId" is given in the request body,
+			// re-pointing the plays. Spotify auth is needed if the track isn't preserved.
+			groupMatchReviews.POST(
+				"/:reviewId/correct",
+				SpotifyAuthNeeded(database),
+				HandlerCorrectMatchReview(database),
+			)
+		}
+
+		// submitting plays from other players, authenticated by the user's ingest token rather than by a
+		// login session. Players are pointed at these endpoints as if they were ListenBrainz or Last.fm.
+		groupIngest := api.Group("/ingest")
+		{
+			// ListenBrainz API: the player's API url is to be set to "<host>/api/ingest/listenbrainz/"
+			groupIngest.GET("/listenbrainz/1/validate-token", HandlerIngestListenBrainzValidateToken(database))
+			groupIngest.POST("/listenbrainz/1/submit-listens", HandlerIngestListenBrainzSubmit(database, spotifyProvider))
+
+			// Audioscrobbler 2.0 API: the player's API url is to be set to "<host>/api/ingest/audioscrobbler/2.0/"
+			groupIngest.POST("/audioscrobbler/2.0/", HandlerIngestAudioscrobbler(database, spotifyProvider))
+
+			// the current user's most recent submitted plays that couldn't be matched to tracks
+			groupIngest.GET("/unmatched", AuthNeeded(database), HandlerIngestUnmatched(database))
+		}
+
 		api.GET("/user/:userid/profile-image", HandlerGetUserProfileImage(database))
 	}
 